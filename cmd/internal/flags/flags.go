@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/urfave/cli/v2"
+	"github.com/wetware/go/system"
 )
 
 // CapabilityFlags returns the capability control flags that can be shared across commands
@@ -30,7 +31,7 @@ func CapabilityFlags() []cli.Flag {
 		&cli.BoolFlag{
 			Name:     "with-exec",
 			Category: "CAPABILITIES",
-			Usage:    "grant process execution capability",
+			Usage:    "grant capability to spawn child processes",
 			EnvVars:  []string{"WW_WITH_EXEC"},
 		},
 		&cli.BoolFlag{
@@ -42,6 +43,26 @@ func CapabilityFlags() []cli.Flag {
 	}
 }
 
+// Capabilities returns the capabilities granted by the flags in CapabilityFlags
+func Capabilities(c *cli.Context) system.Capability {
+	if c.Bool("with-all") {
+		return system.CapAll
+	}
+
+	var caps system.Capability
+	for flag, cap := range map[string]system.Capability{
+		"with-console": system.CapConsole,
+		"with-ipfs":    system.CapIPFS,
+		"with-exec":    system.CapExec,
+		"with-p2p":     system.CapP2P,
+	} {
+		if c.Bool(flag) {
+			caps |= cap
+		}
+	}
+	return caps
+}
+
 // P2PFlags returns the P2P networking flags that can be shared across commands
 func P2PFlags() []cli.Flag {
 	return []cli.Flag{
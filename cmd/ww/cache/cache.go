@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"github.com/wetware/go/cmd/ww/run"
+	"github.com/wetware/go/util"
+)
+
+func Command() *cli.Command {
+	return &cli.Command{
+		Name:  "cache",
+		Usage: "Inspect and prune the WASM compilation cache",
+		Description: `Manage the on-disk compilation cache used by 'ww run'.
+
+Compiled modules are stored under <path>/cache, keyed by the CID of the
+module's bytecode.  Entries are created the first time a module is run,
+and reused on subsequent runs of the same module.
+
+Examples:
+  ww cache ls
+  ww cache gc --max-age 168h
+  ww cache gc --all`,
+		Subcommands: []*cli.Command{
+			{
+				Name:   "ls",
+				Usage:  "list cached modules",
+				Action: ls,
+			},
+			{
+				Name:  "gc",
+				Usage: "remove cached modules that have not been used recently",
+				Flags: []cli.Flag{
+					&cli.DurationFlag{
+						Name:  "max-age",
+						Usage: "remove entries not used within this duration",
+						Value: 30 * 24 * time.Hour,
+					},
+					&cli.BoolFlag{
+						Name:  "all",
+						Usage: "remove all entries",
+					},
+				},
+				Action: gc,
+			},
+		},
+	}
+}
+
+func ls(c *cli.Context) error {
+	entries, err := util.ModuleCache{Dir: run.CacheDir(c)}.Entries()
+	if err != nil {
+		return fmt.Errorf("failed to list cache: %w", err)
+	}
+
+	w := tabwriter.NewWriter(c.App.Writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tSIZE\tLAST USED")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", e.Key, e.Size, e.LastUsed.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+func gc(c *cli.Context) error {
+	cutoff := time.Now().Add(-c.Duration("max-age"))
+	if c.Bool("all") {
+		cutoff = time.Now()
+	}
+
+	removed, err := util.ModuleCache{Dir: run.CacheDir(c)}.Prune(cutoff)
+	for _, e := range removed {
+		fmt.Fprintln(c.App.Writer, e.Key)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to prune cache: %w", err)
+	}
+
+	return nil
+}
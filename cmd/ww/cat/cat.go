@@ -5,15 +5,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log/slog"
 	"os"
 	"syscall"
 
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-msgio"
 	"github.com/urfave/cli/v2"
 	"github.com/wetware/go/cmd/internal/flags"
+	"github.com/wetware/go/system"
 	"github.com/wetware/go/util"
 )
 
@@ -28,13 +29,28 @@ func Command() *cli.Command {
 The command will:
 1. Initialize IPFS environment for stream forwarding
 2. Use IPFS to establish connection to the specified peer
-3. Forward the stream using the /ww/0.1.0/<proc> protocol
+3. Forward the stream using the /ww/<version>/<proc> protocol, negotiating
+   the newest version supported by both peers
 4. Bind the stream to stdin/stdout for communication
 
+With --framed, the stream carries many messages as length-prefixed frames.
+Each line of stdin, or each --file, is sent as one message, and each response
+is printed on a line of its own.
+
+With --stderr, the remote process's stderr is printed to local stderr, along
+with the output of each message.
+
+If the remote process fails, cat exits with the guest's exit code, or with
+75 if the process is busy or has moved, 77 if access is denied, 127 for an
+unknown method, 134 if the guest trapped and 137 if it exceeded a limit.
+
 Examples:
   ww cat QmPeer123 /echo
   ww cat 12D3KooW... /myproc echo
-  ww cat 12D3KooW... /myproc poll`,
+  ww cat 12D3KooW... /myproc poll
+  ww cat --framed 12D3KooW... /myproc echo < requests.txt
+  ww cat --file a.json --file b.json 12D3KooW... /myproc echo
+  ww cat --stderr 12D3KooW... /myproc echo`,
 		Flags: append([]cli.Flag{
 			&cli.StringFlag{
 				Name:    "ipfs",
@@ -42,6 +58,19 @@ Examples:
 				Value:   "/ip4/127.0.0.1/tcp/5001/http",
 				Usage:   "IPFS API endpoint",
 			},
+			&cli.BoolFlag{
+				Name:  "framed",
+				Usage: "send each line of stdin as a message of its own, over a single stream",
+			},
+			&cli.StringSliceFlag{
+				Name:    "file",
+				Aliases: []string{"f"},
+				Usage:   "send the contents of `FILE` as a message of its own (implies --framed)",
+			},
+			&cli.BoolFlag{
+				Name:  "stderr",
+				Usage: "print the remote process's stderr to local stderr",
+			},
 		}, append(flags.CapabilityFlags(), flags.P2PFlags()...)...),
 
 		Before: func(c *cli.Context) error {
@@ -73,10 +102,19 @@ func Main(c *cli.Context) error {
 		return fmt.Errorf("invalid peer ID %s: %w", peerIDStr, err)
 	}
 
-	// Construct protocol ID
-	protocolID := protocol.ID("/ww/0.1.0/" + procName)
-	if method != "" && method != "poll" {
-		protocolID = protocol.ID("/ww/0.1.0/" + procName + "/" + method)
+	// Offer every supported protocol version, newest first.
+	framed := c.Bool("framed") || len(c.StringSlice("file")) > 0
+	stderr := c.Bool("stderr")
+	var protocolIDs []protocol.ID
+	switch {
+	case framed && stderr:
+		protocolIDs = system.FramedStderrProtocolIDs(procName, method)
+	case framed:
+		protocolIDs = system.FramedProtocolIDs(procName, method)
+	case stderr:
+		protocolIDs = system.StderrProtocolIDs(procName, method)
+	default:
+		protocolIDs = system.ProtocolIDs(procName, method)
 	}
 
 	// Create libp2p host in client mode
@@ -86,47 +124,12 @@ func Main(c *cli.Context) error {
 	}
 	defer h.Close()
 
-	dht, err := env.NewDHT(ctx, h)
-	if err != nil {
-		return fmt.Errorf("failed to create DHT client: %w", err)
-	}
-	defer dht.Close()
-
-	// Set up DHT readiness monitoring BEFORE bootstrapping
-	slog.DebugContext(ctx, "setting up DHT readiness monitoring")
-	readyChan := make(chan error, 1)
-	go func() {
-		readyChan <- util.WaitForDHTReady(ctx, dht)
-	}()
-
-	// Bootstrap the DHT to populate routing table with IPFS peers
-	slog.DebugContext(ctx, "bootstrapping DHT")
-	if err := dht.Bootstrap(ctx); err != nil {
-		return fmt.Errorf("failed to bootstrap DHT: %w", err)
-	}
-
-	// Wait for DHT to be ready
-	slog.DebugContext(ctx, "waiting for DHT routing table to populate")
-	if err := <-readyChan; err != nil {
-		slog.WarnContext(ctx, "DHT may not be fully ready", "error", err)
-	}
-
-	// Use DHT for peer discovery
-	slog.DebugContext(ctx, "searching for peer via DHT", "peer", peerID.String()[:12])
-
-	// Try to find the peer using DHT
-	peerInfo, err := dht.FindPeer(ctx, peerID)
-	if err != nil {
-		return fmt.Errorf("failed to find peer %s via DHT: %w", peerID, err)
-	}
-
-	slog.DebugContext(ctx, "target peer found via DHT", "peer", peerInfo.ID.String()[:12])
-	if err := h.Connect(ctx, peerInfo); err != nil {
-		return fmt.Errorf("failed to connect to peer %s: %w", peerInfo.ID, err)
+	if err := env.Connect(ctx, h, peerID); err != nil {
+		return err
 	}
 
 	// Open stream to peer
-	stream, err := h.NewStream(ctx, peerID, protocolID)
+	stream, err := h.NewStream(ctx, peerID, protocolIDs...)
 	if err != nil {
 		return fmt.Errorf("failed to open stream to peer %s: %w", peerID, err)
 	}
@@ -135,20 +138,45 @@ func Main(c *cli.Context) error {
 	// Display connection banner
 	fmt.Printf("⚗️  Wetware Stream Connected\n")
 	fmt.Printf("   Peer: %s...\n", peerID.String()[:12])
-	fmt.Printf("   Endpoint: %s\n", protocolID)
+	fmt.Printf("   Endpoint: %s\n", stream.Protocol())
 	fmt.Printf("   Ctrl+C to exit\n\n")
 
 	// Bind stream to stdin/stdout
-	return bindStreamToStdio(ctx, stream)
+	if framed {
+		err = bindFramedStream(ctx, stream, c.StringSlice("file"), stderr)
+	} else {
+		err = bindStreamToStdio(ctx, stream, stderr)
+	}
+	return exitStatus(procName, method, err)
 }
 
-func bindStreamToStdio(ctx context.Context, stream network.Stream) error {
+// exitStatus reports a stream reset by the remote process with the exit
+// code that matches the outcome of the call, so that scripts can detect
+// remote failures.
+func exitStatus(proc, method string, err error) error {
+	var streamErr *network.StreamError
+	if !errors.As(err, &streamErr) || !streamErr.Remote {
+		return err
+	}
+
+	code := streamErr.ErrorCode
+	return cli.Exit(fmt.Sprintf("%s::%s: %s", proc, method, system.StatusText(code)),
+		system.ExitCode(code))
+}
+
+func bindStreamToStdio(ctx context.Context, stream network.Stream, stderr bool) error {
 	// Copy data between stream and stdin/stdout
 	readDone := make(chan error, 1)
 	writeDone := make(chan error, 1)
 
-	// Copy from stream to stdout
+	// Copy from stream to stdout, and to stderr if it is forwarded
 	go func() {
+		if stderr {
+			r := msgio.NewVarintReaderSize(stream, system.MaxTaggedFrameSize)
+			readDone <- recvTagged(r, os.Stdout, os.Stderr, false)
+			return
+		}
+
 		_, err := io.Copy(os.Stdout, stream)
 		readDone <- err
 	}()
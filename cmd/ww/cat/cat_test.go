@@ -0,0 +1,45 @@
+package cat
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+	"github.com/wetware/go/system"
+)
+
+func TestExitStatus(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Remote", func(t *testing.T) {
+		t.Parallel()
+
+		err := fmt.Errorf("read: %w", &network.StreamError{
+			ErrorCode: system.ErrCodeExit + 3,
+			Remote:    true})
+
+		var exit cli.ExitCoder
+		require.ErrorAs(t, exitStatus("echo", "poll", err), &exit)
+		assert.Equal(t, 3, exit.ExitCode())
+		assert.Equal(t, "echo::poll: exited with code 3", exit.Error())
+	})
+
+	t.Run("Local", func(t *testing.T) {
+		t.Parallel()
+
+		err := &network.StreamError{ErrorCode: system.ErrCodeTrap}
+		assert.Same(t, err, exitStatus("echo", "poll", err))
+	})
+
+	t.Run("Other", func(t *testing.T) {
+		t.Parallel()
+
+		err := errors.New("connection refused")
+		assert.Equal(t, err, exitStatus("echo", "poll", err))
+		assert.NoError(t, exitStatus("echo", "poll", nil))
+	})
+}
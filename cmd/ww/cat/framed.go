@@ -0,0 +1,146 @@
+package cat
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-msgio"
+	"github.com/wetware/go/system"
+)
+
+// bindFramedStream sends each file, or each line of stdin if there are no
+// files, to the stream as a frame, and prints each response frame to stdout
+// on a line of its own.  If stderr is forwarded, it is printed to stderr.
+func bindFramedStream(ctx context.Context, stream network.Stream, files []string, stderr bool) error {
+	readDone := make(chan error, 1)
+	writeDone := make(chan error, 1)
+
+	go func() {
+		if stderr {
+			r := msgio.NewVarintReaderSize(stream, system.MaxTaggedFrameSize)
+			readDone <- recvTagged(r, os.Stdout, os.Stderr, true)
+		} else {
+			readDone <- recvFrames(msgio.NewVarintReaderSize(stream, system.MaxFrameSize), os.Stdout)
+		}
+	}()
+
+	go func() {
+		w := msgio.NewVarintWriter(stream)
+		if len(files) == 0 {
+			writeDone <- sendLines(w, os.Stdin)
+		} else {
+			writeDone <- sendFiles(w, files)
+		}
+	}()
+
+	if err := <-writeDone; err != nil {
+		stream.Reset()
+		return err
+	}
+
+	// Signal the end of the requests, and wait for the remaining responses.
+	stream.CloseWrite()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-readDone:
+		return err
+	}
+}
+
+// sendLines writes each line of r as a frame, without its line ending.
+func sendLines(w msgio.Writer, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, system.MaxFrameSize)
+	for scanner.Scan() {
+		if err := w.WriteMsg(scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// sendFiles writes the contents of each file as a frame.
+func sendFiles(w msgio.Writer, files []string) error {
+	for _, name := range files {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+
+		if len(b) > system.MaxFrameSize {
+			return fmt.Errorf("%s: %w", name, system.ErrFrameTooLarge)
+		}
+
+		if err := w.WriteMsg(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recvFrames prints each frame read from r on a line of its own, until EOF.
+func recvFrames(r msgio.Reader, out io.Writer) error {
+	for {
+		msg, err := r.ReadMsg()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if !bytes.HasSuffix(msg, []byte("\n")) {
+			msg = append(msg, '\n')
+		}
+		_, err = out.Write(msg)
+		r.ReleaseMsg(msg)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// recvTagged writes the tagged frames read from r to out or errOut, until
+// EOF.  If lines is true, each stdout frame is a response, and is printed on
+// a line of its own.
+func recvTagged(r msgio.Reader, out, errOut io.Writer, lines bool) error {
+	for {
+		msg, err := r.ReadMsg()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		} else if len(msg) == 0 {
+			r.ReleaseMsg(msg)
+			return errors.New("invalid frame: missing tag")
+		}
+
+		var w io.Writer
+		switch msg[0] {
+		case system.TagStdout:
+			w = out
+		case system.TagStderr:
+			w = errOut
+		default:
+			r.ReleaseMsg(msg)
+			return fmt.Errorf("invalid frame: unknown tag %d", msg[0])
+		}
+
+		data := msg[1:]
+		if lines && msg[0] == system.TagStdout && !bytes.HasSuffix(data, []byte("\n")) {
+			data = append(data, '\n')
+		}
+		_, err = w.Write(data)
+		r.ReleaseMsg(msg)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package cat
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-msgio"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendLines(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	require.NoError(t, sendLines(msgio.NewVarintWriter(&buf), strings.NewReader("one\ntwo\r\n\nthree")))
+
+	var out bytes.Buffer
+	require.NoError(t, recvFrames(msgio.NewVarintReader(&buf), &out))
+	assert.Equal(t, "one\ntwo\n\nthree\n", out.String())
+}
+
+func TestSendFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.json"), filepath.Join(dir, "b.json")
+	require.NoError(t, os.WriteFile(a, []byte("{\n  \"a\": 1\n}\n"), 0o600))
+	require.NoError(t, os.WriteFile(b, []byte(`{"b": 2}`), 0o600))
+
+	var buf bytes.Buffer
+	require.NoError(t, sendFiles(msgio.NewVarintWriter(&buf), []string{a, b}))
+
+	r := msgio.NewVarintReader(&buf)
+	for _, want := range []string{"{\n  \"a\": 1\n}\n", `{"b": 2}`} {
+		got, err := r.ReadMsg()
+		require.NoError(t, err)
+		assert.Equal(t, want, string(got))
+	}
+
+	assert.Error(t, sendFiles(msgio.NewVarintWriter(&buf), []string{filepath.Join(dir, "missing")}))
+}
+
+func TestRecvTagged(t *testing.T) {
+	t.Parallel()
+
+	frames := func(msgs ...string) msgio.Reader {
+		var buf bytes.Buffer
+		w := msgio.NewVarintWriter(&buf)
+		for _, msg := range msgs {
+			require.NoError(t, w.WriteMsg([]byte(msg)))
+		}
+		return msgio.NewVarintReader(&buf)
+	}
+
+	t.Run("Stream", func(t *testing.T) {
+		t.Parallel()
+
+		var out, errOut bytes.Buffer
+		r := frames("\x01hel", "\x02oops\n", "\x01lo")
+		require.NoError(t, recvTagged(r, &out, &errOut, false))
+		assert.Equal(t, "hello", out.String())
+		assert.Equal(t, "oops\n", errOut.String())
+	})
+
+	t.Run("Lines", func(t *testing.T) {
+		t.Parallel()
+
+		var out, errOut bytes.Buffer
+		r := frames("\x02oops", "\x01one", "\x01", "\x01two\n")
+		require.NoError(t, recvTagged(r, &out, &errOut, true))
+		assert.Equal(t, "one\n\ntwo\n", out.String())
+		assert.Equal(t, "oops", errOut.String())
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+
+		var out, errOut bytes.Buffer
+		assert.ErrorContains(t, recvTagged(frames(""), &out, &errOut, false), "missing tag")
+		assert.ErrorContains(t, recvTagged(frames("\x07x"), &out, &errOut, false), "unknown tag")
+	})
+}
@@ -0,0 +1,92 @@
+package checkpoint
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/urfave/cli/v2"
+	"github.com/wetware/go/cmd/internal/flags"
+	"github.com/wetware/go/system"
+	"github.com/wetware/go/util"
+)
+
+var env util.IPFSEnv
+
+func Command() *cli.Command {
+	return &cli.Command{
+		Name:      "checkpoint",
+		ArgsUsage: "<peer> <proc>",
+		Usage:     "Save the state of a remote process to IPFS",
+		Description: `Connect to a specified peer and ask it to save the linear memory and exported
+globals of a process to IPFS, then print the path of the checkpoint.  The
+process must have been started with --checkpoint.
+
+Restore it, on the same node or another one, with:
+  ww run --restore /ipfs/<cid>
+
+Examples:
+  ww checkpoint 12D3KooW... counter`,
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:    "ipfs",
+				EnvVars: []string{"WW_IPFS"},
+				Value:   "/ip4/127.0.0.1/tcp/5001/http",
+				Usage:   "IPFS API endpoint",
+			},
+		}, flags.P2PFlags()...),
+
+		Before: func(c *cli.Context) error {
+			return env.Boot(c.String("ipfs"))
+		},
+		After: func(c *cli.Context) error {
+			return env.Close()
+		},
+
+		Action: Main,
+	}
+}
+
+func Main(c *cli.Context) error {
+	ctx, cancel := context.WithTimeout(c.Context, c.Duration("timeout"))
+	defer cancel()
+
+	if c.NArg() != 2 {
+		return cli.Exit("checkpoint requires 2 arguments: <peer> <proc>", 1)
+	}
+
+	peerID, err := peer.Decode(c.Args().Get(0))
+	if err != nil {
+		return fmt.Errorf("invalid peer ID %s: %w", c.Args().Get(0), err)
+	}
+
+	h, err := util.NewClient()
+	if err != nil {
+		return fmt.Errorf("failed to create host: %w", err)
+	}
+	defer h.Close()
+
+	if err := env.Connect(ctx, h, peerID); err != nil {
+		return err
+	}
+
+	s, err := h.NewStream(ctx, peerID, system.ProtocolIDs(c.Args().Get(1), system.CheckpointMethod)...)
+	if err != nil {
+		return fmt.Errorf("failed to open stream to peer %s: %w", peerID, err)
+	}
+	defer s.Close()
+
+	if err := s.CloseWrite(); err != nil {
+		return err
+	}
+
+	line, err := bufio.NewReader(s).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+
+	_, err = fmt.Fprintln(c.App.Writer, strings.TrimSpace(line))
+	return err
+}
@@ -0,0 +1,150 @@
+package inspect
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/boxo/path"
+	"github.com/tetratelabs/wazero"
+	"github.com/urfave/cli/v2"
+	"github.com/wetware/go/system"
+	"github.com/wetware/go/util"
+)
+
+var env util.IPFSEnv
+
+func Command() *cli.Command {
+	return &cli.Command{
+		Name:      "inspect",
+		ArgsUsage: "<binary>",
+		Usage:     "Describe a module without running it",
+		Description: `Compile a WASM module and print what it imports and exports, the limits of
+its memory and its custom sections, along with the capabilities that its
+imports require and whether it is a command or a reactor.  Nothing is
+instantiated.
+
+The binary is resolved like that of ww run: an IPFS path, a local file, or
+the name of an executable in $PATH.
+
+Examples:
+  ww inspect ./main.wasm
+  ww inspect --json /ipfs/QmHash...`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "ipfs",
+				EnvVars: []string{"WW_IPFS"},
+				Value:   "/ip4/127.0.0.1/tcp/5001/http",
+				Usage:   "IPFS API endpoint",
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "print the description as JSON",
+			},
+		},
+
+		After: func(c *cli.Context) error {
+			return env.Close()
+		},
+
+		Action: Main,
+	}
+}
+
+func Main(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return cli.Exit("inspect requires 1 argument: <binary>", 1)
+	}
+	name := c.Args().First()
+
+	if _, err := path.NewPath(name); err == nil {
+		if err := env.Boot(c.String("ipfs")); err != nil {
+			return fmt.Errorf("failed to connect to IPFS: %w", err)
+		}
+	}
+
+	f, err := env.ResolveBinary(c.Context, name)
+	if err != nil {
+		return fmt.Errorf("failed to resolve binary %s: %w", name, err)
+	}
+	defer f.Close()
+
+	bytecode, err := io.ReadAll(f)
+	if err != nil {
+		return fmt.Errorf("failed to read binary %s: %w", name, err)
+	}
+
+	r := wazero.NewRuntimeWithConfig(c.Context, wazero.NewRuntimeConfig().
+		WithCustomSections(true))
+	defer r.Close(c.Context)
+
+	in, err := system.Inspect(c.Context, r, bytecode)
+	if err != nil {
+		return fmt.Errorf("failed to compile %s: %w", name, err)
+	}
+
+	if c.Bool("json") {
+		return json.NewEncoder(c.App.Writer).Encode(struct {
+			Name string `json:"name"`
+			Caps string `json:"caps"`
+			*system.Inspection
+		}{name, in.Caps.String(), in})
+	}
+
+	return Print(c.App.Writer, name, in)
+}
+
+// Print writes a human-readable description of in to w.
+func Print(w io.Writer, name string, in *system.Inspection) error {
+	kind := "no entry point"
+	switch {
+	case in.Command:
+		kind = "command"
+	case in.Reactor:
+		kind = "reactor"
+	}
+
+	lines := []string{
+		fmt.Sprintf("%s (%s)\n", name, kind),
+		fmt.Sprintf("\ncapabilities: %s\n", in.Caps),
+		"\nimports:\n",
+	}
+	for _, imp := range in.Imports {
+		line := fmt.Sprintf("  %s", imp)
+		switch {
+		case imp.Unknown:
+			line += "  [no such host function]"
+		case imp.Cap != 0:
+			line += fmt.Sprintf("  [%s]", imp.Cap)
+		}
+		lines = append(lines, line+"\n")
+	}
+
+	lines = append(lines, "\nexports:\n")
+	for _, m := range in.Exports {
+		lines = append(lines, fmt.Sprintf("  %s\n", m))
+	}
+
+	lines = append(lines, "\nmemory:\n")
+	for _, m := range in.Memory {
+		lines = append(lines, fmt.Sprintf("  %s\n", m))
+	}
+
+	if len(in.Sections) > 0 {
+		lines = append(lines, "\ncustom sections:\n")
+	}
+	for _, s := range in.Sections {
+		line := fmt.Sprintf("  %s (%d bytes)", s.Name, s.Size)
+		if s.Text != "" {
+			line += ": " + s.Text
+		}
+		lines = append(lines, line+"\n")
+	}
+
+	for _, line := range lines {
+		if _, err := io.WriteString(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -10,10 +10,15 @@ import (
 	"github.com/lmittmann/tint"
 	"github.com/urfave/cli/v2"
 
+	"github.com/wetware/go/cmd/ww/cache"
 	"github.com/wetware/go/cmd/ww/cat"
+	"github.com/wetware/go/cmd/ww/checkpoint"
 	"github.com/wetware/go/cmd/ww/export"
 	"github.com/wetware/go/cmd/ww/idgen"
 	importcmd "github.com/wetware/go/cmd/ww/import"
+	"github.com/wetware/go/cmd/ww/inspect"
+	"github.com/wetware/go/cmd/ww/methods"
+	"github.com/wetware/go/cmd/ww/migrate"
 	"github.com/wetware/go/cmd/ww/run"
 )
 
@@ -46,8 +51,13 @@ func main() {
 			},
 		},
 		Commands: []*cli.Command{
+			cache.Command(),
 			cat.Command(),
+			checkpoint.Command(),
 			idgen.Command(),
+			inspect.Command(),
+			methods.Command(),
+			migrate.Command(),
 			run.Command(),
 			export.Command(),
 			importcmd.Command(),
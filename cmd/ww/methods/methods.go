@@ -0,0 +1,133 @@
+package methods
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/urfave/cli/v2"
+	"github.com/wetware/go/cmd/internal/flags"
+	"github.com/wetware/go/system"
+	"github.com/wetware/go/util"
+)
+
+var env util.IPFSEnv
+
+func Command() *cli.Command {
+	return &cli.Command{
+		Name:      "methods",
+		ArgsUsage: "<peer> <proc>",
+		Usage:     "List the methods served by a remote process",
+		Description: `Connect to a specified peer and list the exported functions of a process,
+along with their signatures and any metadata embedded in the module.
+
+Examples:
+  ww methods 12D3KooW... echo
+  ww methods --raw 12D3KooW... echo`,
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:    "ipfs",
+				EnvVars: []string{"WW_IPFS"},
+				Value:   "/ip4/127.0.0.1/tcp/5001/http",
+				Usage:   "IPFS API endpoint",
+			},
+			&cli.BoolFlag{
+				Name:    "raw",
+				Aliases: []string{"r"},
+				Usage:   "output the JSON description as received",
+			},
+		}, flags.P2PFlags()...),
+
+		Before: func(c *cli.Context) error {
+			return env.Boot(c.String("ipfs"))
+		},
+		After: func(c *cli.Context) error {
+			return env.Close()
+		},
+
+		Action: Main,
+	}
+}
+
+func Main(c *cli.Context) error {
+	ctx, cancel := context.WithTimeout(c.Context, c.Duration("timeout"))
+	defer cancel()
+
+	if c.NArg() != 2 {
+		return cli.Exit("methods requires 2 arguments: <peer> <proc>", 1)
+	}
+
+	peerID, err := peer.Decode(c.Args().Get(0))
+	if err != nil {
+		return fmt.Errorf("invalid peer ID %s: %w", c.Args().Get(0), err)
+	}
+
+	h, err := util.NewClient()
+	if err != nil {
+		return fmt.Errorf("failed to create host: %w", err)
+	}
+	defer h.Close()
+
+	if err := env.Connect(ctx, h, peerID); err != nil {
+		return err
+	}
+
+	s, err := h.NewStream(ctx, peerID, system.ProtocolIDs(c.Args().Get(1), system.MethodsMethod)...)
+	if err != nil {
+		return fmt.Errorf("failed to open stream to peer %s: %w", peerID, err)
+	}
+	defer s.Close()
+
+	if err := s.CloseWrite(); err != nil {
+		return err
+	}
+
+	// Servers speaking 0.1.0 leave the stream open, so read a single value
+	// rather than waiting for EOF.
+	var raw json.RawMessage
+	if err := json.NewDecoder(s).Decode(&raw); err != nil {
+		return fmt.Errorf("failed to read methods: %w", err)
+	}
+
+	if c.Bool("raw") {
+		_, err = fmt.Fprintln(c.App.Writer, string(raw))
+		return err
+	}
+
+	var ms system.Methods
+	if err := json.Unmarshal(raw, &ms); err != nil {
+		return fmt.Errorf("failed to read methods: %w", err)
+	}
+
+	return Print(c.App.Writer, ms)
+}
+
+// Print writes a human-readable description of ms to w.
+func Print(w io.Writer, ms system.Methods) error {
+	if _, err := fmt.Fprintf(w, "%s\n", ms.Proc); err != nil {
+		return err
+	}
+
+	for _, m := range ms.Methods {
+		if _, err := fmt.Fprintf(w, "  %s\n", m); err != nil {
+			return err
+		}
+	}
+
+	if len(ms.Metadata) > 0 {
+		if _, err := fmt.Fprintf(w, "\nmetadata:\n"); err != nil {
+			return err
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(ms.Metadata)) {
+		if _, err := fmt.Fprintf(w, "  %s: %s\n", name, ms.Metadata[name]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
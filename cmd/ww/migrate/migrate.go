@@ -0,0 +1,107 @@
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/urfave/cli/v2"
+	"github.com/wetware/go/cmd/internal/flags"
+	"github.com/wetware/go/system"
+	"github.com/wetware/go/util"
+)
+
+var env util.IPFSEnv
+
+func Command() *cli.Command {
+	return &cli.Command{
+		Name:      "migrate",
+		ArgsUsage: "<proc> <peer>",
+		Usage:     "Move a running process to another peer",
+		Description: `Connect to the peer running a process, given by --from, and ask it to move the
+process to another peer.  The source stops accepting streams, waits for those
+in progress, saves a checkpoint of the process to IPFS, and has the target
+restore it.  From then on, the source forwards streams sent to the process to
+its new location.
+
+The process must have been started with --checkpoint, and the target with
+--accept-migrations.
+
+Examples:
+  ww migrate --from 12D3KooWSource... counter 12D3KooWTarget...`,
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:    "ipfs",
+				EnvVars: []string{"WW_IPFS"},
+				Value:   "/ip4/127.0.0.1/tcp/5001/http",
+				Usage:   "IPFS API endpoint",
+			},
+			&cli.StringFlag{
+				Name:     "from",
+				EnvVars:  []string{"WW_FROM"},
+				Usage:    "`PEER` currently running the process",
+				Required: true,
+			},
+		}, flags.P2PFlags()...),
+
+		Before: func(c *cli.Context) error {
+			return env.Boot(c.String("ipfs"))
+		},
+		After: func(c *cli.Context) error {
+			return env.Close()
+		},
+
+		Action: Main,
+	}
+}
+
+func Main(c *cli.Context) error {
+	ctx, cancel := context.WithTimeout(c.Context, c.Duration("timeout"))
+	defer cancel()
+
+	if c.NArg() != 2 {
+		return cli.Exit("migrate requires 2 arguments: <proc> <peer>", 1)
+	}
+
+	from, err := peer.Decode(c.String("from"))
+	if err != nil {
+		return fmt.Errorf("invalid peer ID %s: %w", c.String("from"), err)
+	}
+
+	to, err := peer.Decode(c.Args().Get(1))
+	if err != nil {
+		return fmt.Errorf("invalid peer ID %s: %w", c.Args().Get(1), err)
+	}
+
+	h, err := util.NewClient()
+	if err != nil {
+		return fmt.Errorf("failed to create host: %w", err)
+	}
+	defer h.Close()
+
+	if err := env.Connect(ctx, h, from); err != nil {
+		return err
+	}
+
+	s, err := h.NewStream(ctx, from, system.ProtocolIDs(c.Args().Get(0), system.MigrateMethod)...)
+	if err != nil {
+		return fmt.Errorf("failed to open stream to peer %s: %w", from, err)
+	}
+	defer s.Close()
+
+	if _, err := fmt.Fprintln(s, to); err != nil {
+		return err
+	}
+	if err := s.CloseWrite(); err != nil {
+		return err
+	}
+
+	var r system.Redirect
+	if err := json.NewDecoder(s).Decode(&r); err != nil {
+		return fmt.Errorf("failed to migrate: %w", err)
+	}
+
+	_, err = fmt.Fprintf(c.App.Writer, "%s moved to %s as %s\n", c.Args().Get(0), r.Peer.ID, r.Proc)
+	return err
+}
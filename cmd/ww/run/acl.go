@@ -0,0 +1,54 @@
+package run
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/wetware/go/system"
+)
+
+// loadACL loads the ACL file, if any, and reloads it whenever the process
+// receives SIGHUP, until ctx expires.  If a reload fails, the previous ACL
+// stays in effect.  Without a file, the ACL permits everyone.
+func loadACL(ctx context.Context, name string) (*atomic.Pointer[system.ACL], error) {
+	var acl atomic.Pointer[system.ACL]
+	if name == "" {
+		return &acl, nil
+	}
+
+	initial, err := system.LoadACL(name)
+	if err != nil {
+		return nil, err
+	}
+	acl.Store(initial)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+			}
+
+			if next, err := system.LoadACL(name); err != nil {
+				slog.ErrorContext(ctx, "failed to reload ACL",
+					"path", name,
+					"reason", err)
+			} else {
+				acl.Store(next)
+				slog.InfoContext(ctx, "reloaded ACL",
+					"path", name)
+			}
+		}
+	}()
+
+	return &acl, nil
+}
@@ -0,0 +1,57 @@
+package run
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wetware/go/system"
+)
+
+func TestLoadACL(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	t.Run("no file", func(t *testing.T) {
+		acl, err := loadACL(ctx, "")
+		require.NoError(t, err)
+		assert.Nil(t, acl.Load(), "everyone should be permitted")
+	})
+
+	t.Run("reload on SIGHUP", func(t *testing.T) {
+		const alice = "12D3KooWKnDdG3iXw9eTFijk3EWSunZcFi54Zka4wmtqtt6rPxc8"
+		const bob = "12D3KooWJWEKvSFbben74C7H4YtKjhPMTDxd7gP7zxWSUEeF27st"
+
+		name := filepath.Join(t.TempDir(), "acl.json")
+		require.NoError(t, os.WriteFile(name, []byte(`{"allow": ["`+alice+`"]}`), 0o600))
+
+		acl, err := loadACL(ctx, name)
+		require.NoError(t, err)
+
+		id, err := peer.Decode(bob)
+		require.NoError(t, err)
+		assert.ErrorIs(t, acl.Load().Check(id, "poll"), system.ErrDenied)
+
+		// A broken file leaves the previous ACL in effect.
+		require.NoError(t, os.WriteFile(name, []byte(`{`), 0o600))
+		require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+		time.Sleep(50 * time.Millisecond)
+		assert.ErrorIs(t, acl.Load().Check(id, "poll"), system.ErrDenied)
+
+		require.NoError(t, os.WriteFile(name, []byte(`{"allow": ["`+alice+`", "`+bob+`"]}`), 0o600))
+		require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+		assert.Eventually(t, func() bool { return acl.Load().Check(id, "poll") == nil },
+			time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("invalid file", func(t *testing.T) {
+		_, err := loadACL(ctx, filepath.Join(t.TempDir(), "missing.json"))
+		assert.Error(t, err)
+	})
+}
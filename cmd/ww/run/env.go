@@ -8,8 +8,8 @@ import (
 	"runtime"
 	"strings"
 
-	"github.com/ipfs/boxo/files"
 	"github.com/ipfs/boxo/path"
+	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p-kad-dht/dual"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/wetware/go/util"
@@ -28,8 +28,9 @@ func ExpandHome(path string) (string, error) {
 }
 
 type EnvConfig struct {
-	IPFS string
-	Port int
+	IPFS      string
+	Port      int
+	Resources util.ResourceLimits // libp2p resources per process and per peer
 }
 
 func (cfg EnvConfig) New(ctx context.Context) (env Env, err error) {
@@ -48,7 +49,14 @@ func (cfg EnvConfig) New(ctx context.Context) (env Env, err error) {
 
 	// Initialize libp2p host
 	////
-	env.Host, err = util.NewServer(cfg.Port)
+	env.Limiter = cfg.Resources.NewLimiter()
+	rm, err := env.Limiter.NewResourceManager()
+	if err != nil {
+		err = fmt.Errorf("failed to create resource manager: %w", err)
+		return
+	}
+
+	env.Host, err = util.NewServer(cfg.Port, libp2p.ResourceManager(rm))
 	if err != nil {
 		err = fmt.Errorf("failed to create libp2p host: %w", err)
 		return
@@ -70,6 +78,9 @@ type Env struct {
 	NS   string
 	Dir  string // Temporary directory for cell execution
 	DHT  *dual.DHT
+
+	// Limiter applies the resource limits of a process to its service.
+	Limiter *util.Limiter
 }
 
 func (env *Env) Close() error {
@@ -152,24 +163,3 @@ func (env *Env) Arch() string {
 	}
 	return runtime.GOARCH
 }
-
-// LoadIPFSFile resolves an IPFS path to WASM bytecode
-func (env *Env) LoadIPFSFile(ctx context.Context, p path.Path) (files.File, error) {
-	if env.IPFS == nil {
-		return nil, fmt.Errorf("IPFS environment not initialized")
-	}
-
-	// Get the file from IPFS
-	node, err := env.IPFS.Unixfs().Get(ctx, p)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get from IPFS: %w", err)
-	}
-
-	// Read the file content
-	file, ok := node.(files.File)
-	if !ok {
-		return nil, fmt.Errorf("IPFS path does not point to a file")
-	}
-
-	return file, nil
-}
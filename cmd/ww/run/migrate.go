@@ -0,0 +1,145 @@
+package run
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync/atomic"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/wetware/go/system"
+)
+
+// migrateTo returns a function that saves checkpoints to IPFS, and asks
+// the target peer to restore them over system.MigrateProtocol.
+func migrateTo(h host.Host) system.MigrateFunc {
+	return func(ctx context.Context, c *system.Checkpoint, to peer.ID) (system.Redirect, error) {
+		if env.IPFS == nil {
+			return system.Redirect{}, fmt.Errorf("IPFS environment not initialized")
+		}
+
+		p, err := c.Save(ctx, env.IPFS)
+		if err != nil {
+			return system.Redirect{}, fmt.Errorf("failed to save checkpoint: %w", err)
+		}
+
+		s, err := h.NewStream(ctx, to, system.MigrateProtocol)
+		if err != nil {
+			return system.Redirect{}, err
+		}
+		defer s.Close()
+
+		if err := json.NewEncoder(s).Encode(system.MigrateRequest{Checkpoint: p.String()}); err != nil {
+			return system.Redirect{}, err
+		}
+		if err := s.CloseWrite(); err != nil {
+			return system.Redirect{}, err
+		}
+
+		var r system.Redirect
+		if err := json.NewDecoder(s).Decode(&r); err != nil {
+			return system.Redirect{}, fmt.Errorf("failed to restore checkpoint %s: %w", p, err)
+		}
+		return r, nil
+	}
+}
+
+// acceptMigration restores the process requested on s with config, serves
+// it with handle, and answers with its forwarding record.
+func acceptMigration(ctx context.Context, s network.Stream, sup *system.Supervisor, config system.ProcConfig, rts *runtimes, acl *atomic.Pointer[system.ACL], handle func(*system.Service)) {
+	defer s.Close()
+
+	svc, err := restoreMigrated(ctx, s, sup, config, rts, acl)
+	if err != nil {
+		if errors.Is(err, system.ErrDenied) {
+			_ = s.ResetWithError(system.ErrCodeDenied)
+		} else {
+			_ = s.Reset()
+		}
+		slog.WarnContext(ctx, "rejected migration",
+			"peer", s.Conn().RemotePeer(),
+			"reason", err)
+		return
+	}
+	handle(svc)
+
+	slog.InfoContext(ctx, "process migrated here",
+		"peer", s.Conn().RemotePeer(),
+		"endpoint", svc.Name())
+
+	h := env.Host
+	if err := json.NewEncoder(s).Encode(system.Redirect{
+		Proc: svc.Name(),
+		Peer: peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()},
+	}); err != nil {
+		slog.WarnContext(ctx, "failed to answer migration",
+			"peer", s.Conn().RemotePeer(),
+			"endpoint", svc.Name(),
+			"reason", err)
+	}
+}
+
+// errMigrateACL is returned for --accept-migrations without an ACL that
+// allows specific peers to migrate processes here.
+var errMigrateACL = fmt.Errorf("--accept-migrations requires an --acl that allows %s to specific peers", system.MigrateMethod)
+
+// errCheckpointACL is returned for --checkpoint without an ACL that allows
+// specific peers to migrate the process, which anyone could otherwise move
+// to a peer of their own.
+var errCheckpointACL = fmt.Errorf("--checkpoint requires an --acl that allows %s to specific peers", system.MigrateMethod)
+
+// checkMigration returns ErrDenied unless acl explicitly allows id to
+// migrate processes here.  Since a migrated process runs code of the
+// caller's choosing, permitting everyone is not enough.
+func checkMigration(acl *system.ACL, id peer.ID) error {
+	if !acl.Explicit(system.MigrateMethod) {
+		return fmt.Errorf("%w: %w", system.ErrDenied, errMigrateACL)
+	}
+	return acl.Check(id, system.MigrateMethod)
+}
+
+func restoreMigrated(ctx context.Context, s network.Stream, sup *system.Supervisor, config system.ProcConfig, rts *runtimes, acl *atomic.Pointer[system.ACL]) (*system.Service, error) {
+	if err := checkMigration(acl.Load(), s.Conn().RemotePeer()); err != nil {
+		return nil, err
+	}
+
+	var req system.MigrateRequest
+	if err := json.NewDecoder(io.LimitReader(s, 4096)).Decode(&req); err != nil {
+		return nil, fmt.Errorf("invalid migration request: %w", err)
+	}
+
+	c, err := loadCheckpoint(ctx, req.Checkpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load checkpoint: %w", err)
+	}
+
+	// The module is compiled in a runtime of its own, which caches it
+	// under its own bytecode rather than ours.
+	if config.Runtime, err = rts.New(ctx, c.Module); err != nil {
+		return nil, err
+	}
+
+	return sup.Start(ctx, migratedConfig(config, c, req.Checkpoint))
+}
+
+// migratedConfig returns the config of a process restored from checkpoint
+// c, found at path.  The process keeps its endpoint name, and our own
+// limits apply to it.  Since its code comes from another node, it is
+// granted no capabilities, and none of our mounts or environment.
+func migratedConfig(config system.ProcConfig, c *system.Checkpoint, path string) system.ProcConfig {
+	config.Name = ""
+	config.Src = io.NopCloser(bytes.NewReader(c.Module))
+	config.Args = []string{path}
+	config.Env = nil
+	config.Caps = 0
+	config.Mounts = nil
+	config.Async, config.Serve = true, false
+	config.Restore = c
+	return config
+}
@@ -0,0 +1,65 @@
+package run
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wetware/go/system"
+)
+
+func TestCheckMigration(t *testing.T) {
+	t.Parallel()
+
+	alice, err := peer.Decode("12D3KooWKnDdG3iXw9eTFijk3EWSunZcFi54Zka4wmtqtt6rPxc8")
+	require.NoError(t, err)
+	bob, err := peer.Decode("12D3KooWJWEKvSFbben74C7H4YtKjhPMTDxd7gP7zxWSUEeF27st")
+	require.NoError(t, err)
+
+	t.Run("no ACL", func(t *testing.T) {
+		t.Parallel()
+
+		err := checkMigration(nil, alice)
+		assert.ErrorIs(t, err, system.ErrDenied)
+		assert.ErrorIs(t, err, errMigrateACL)
+	})
+
+	t.Run("no method ACL", func(t *testing.T) {
+		t.Parallel()
+
+		// Allowing a peer to call the process is not enough.
+		acl := &system.ACL{Allow: []peer.ID{alice}}
+		assert.ErrorIs(t, checkMigration(acl, alice), errMigrateACL)
+	})
+
+	t.Run("method ACL", func(t *testing.T) {
+		t.Parallel()
+
+		acl := &system.ACL{Methods: map[string]system.MethodACL{
+			system.MigrateMethod: {Allow: []peer.ID{alice}},
+		}}
+		assert.NoError(t, checkMigration(acl, alice))
+		assert.ErrorIs(t, checkMigration(acl, bob), system.ErrDenied)
+	})
+}
+
+func TestMigratedConfig(t *testing.T) {
+	t.Parallel()
+
+	c := &system.Checkpoint{Proc: "counter", Module: []byte("module")}
+	config := migratedConfig(system.ProcConfig{
+		Name:   "ours",
+		Env:    []string{"SECRET=1"},
+		Caps:   system.CapConsole | system.CapIPFS,
+		Mounts: []system.Mount{{Path: "/data", Dir: "/srv/data"}},
+	}, c, "/ipfs/QmCheckpoint")
+
+	assert.Empty(t, config.Name, "should keep the name of the checkpoint")
+	assert.Equal(t, []string{"/ipfs/QmCheckpoint"}, config.Args)
+	assert.Nil(t, config.Env, "should not inherit our environment")
+	assert.Zero(t, config.Caps, "should not inherit our capabilities")
+	assert.Nil(t, config.Mounts, "should not inherit our mounts")
+	assert.True(t, config.Async)
+	assert.Same(t, c, config.Restore)
+}
@@ -0,0 +1,102 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ipfs/boxo/path"
+	"github.com/wetware/go/system"
+)
+
+// MountSpec is a parsed --mount flag of the form SRC:DST[:ro|rw].
+type MountSpec struct {
+	Source   string // IPFS path or host directory
+	Target   string // absolute path in the guest
+	ReadOnly bool
+}
+
+// ParseMount parses a mount spec.  Mounts are read-only unless ":rw" is
+// given.
+func ParseMount(spec string) (MountSpec, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return MountSpec{}, fmt.Errorf("invalid mount %q: expected SRC:DST[:ro|rw]", spec)
+	}
+
+	m := MountSpec{Source: parts[0], Target: parts[1], ReadOnly: true}
+	if m.Source == "" {
+		return MountSpec{}, fmt.Errorf("invalid mount %q: empty source", spec)
+	}
+	if !strings.HasPrefix(m.Target, "/") {
+		return MountSpec{}, fmt.Errorf("invalid mount %q: target must be an absolute path", spec)
+	}
+
+	if len(parts) == 3 {
+		switch parts[2] {
+		case "ro":
+		case "rw":
+			m.ReadOnly = false
+		default:
+			return MountSpec{}, fmt.Errorf("invalid mount %q: unknown mode %q", spec, parts[2])
+		}
+	}
+
+	return m, nil
+}
+
+// Mounts resolves mount specs against the environment.  IPFS paths are
+// served lazily from the IPFS node, and are always read-only.  If tmp is
+// true, a writable scratch directory under env.Dir is mounted at /tmp.
+func (env *Env) Mounts(ctx context.Context, specs []string, tmp bool) ([]system.Mount, error) {
+	var mounts []system.Mount
+	for _, spec := range specs {
+		m, err := ParseMount(spec)
+		if err != nil {
+			return nil, err
+		}
+
+		if p, err := path.NewPath(m.Source); err == nil {
+			if !m.ReadOnly {
+				return nil, fmt.Errorf("invalid mount %q: IPFS mounts are read-only", spec)
+			}
+
+			mounts = append(mounts, system.Mount{
+				Path:     m.Target,
+				FS:       env.FS(ctx, p),
+				ReadOnly: true,
+			})
+			continue
+		}
+
+		dir, err := filepath.Abs(m.Source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mount %q: %w", spec, err)
+		}
+
+		if info, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("invalid mount %q: %w", spec, err)
+		} else if !info.IsDir() {
+			return nil, fmt.Errorf("invalid mount %q: %s is not a directory", spec, dir)
+		}
+
+		mounts = append(mounts, system.Mount{
+			Path:     m.Target,
+			Dir:      dir,
+			ReadOnly: m.ReadOnly,
+		})
+	}
+
+	if tmp {
+		dir := filepath.Join(env.Dir, "tmp")
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create /tmp: %w", err)
+		}
+
+		mounts = append(mounts, system.Mount{Path: "/tmp", Dir: dir})
+	}
+
+	return mounts, nil
+}
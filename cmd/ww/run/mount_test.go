@@ -0,0 +1,92 @@
+package run_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wetware/go/cmd/ww/run"
+)
+
+func TestParseMount(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		spec string
+		want run.MountSpec
+		err  string
+	}{
+		{spec: "/ipfs/bafkqaaa:/data:ro", want: run.MountSpec{Source: "/ipfs/bafkqaaa", Target: "/data", ReadOnly: true}},
+		{spec: "./local:/work:rw", want: run.MountSpec{Source: "./local", Target: "/work"}},
+		{spec: "./local:/work", want: run.MountSpec{Source: "./local", Target: "/work", ReadOnly: true}},
+		{spec: "./local", err: "expected SRC:DST[:ro|rw]"},
+		{spec: "./local:/work:rw:x", err: "expected SRC:DST[:ro|rw]"},
+		{spec: ":/work", err: "empty source"},
+		{spec: "./local:work", err: "absolute path"},
+		{spec: "./local:/work:wr", err: `unknown mode "wr"`},
+	} {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := run.ParseMount(tt.spec)
+			if tt.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEnv_Mounts(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	env := &run.Env{Dir: t.TempDir()}
+	local := t.TempDir()
+
+	t.Run("host directory", func(t *testing.T) {
+		mounts, err := env.Mounts(ctx, []string{local + ":/work:rw"}, false)
+		require.NoError(t, err)
+		require.Len(t, mounts, 1)
+		assert.Equal(t, "/work", mounts[0].Path)
+		assert.Equal(t, local, mounts[0].Dir)
+		assert.False(t, mounts[0].ReadOnly)
+	})
+
+	t.Run("ipfs path", func(t *testing.T) {
+		mounts, err := env.Mounts(ctx, []string{"/ipfs/bafkqaaa:/data"}, false)
+		require.NoError(t, err)
+		require.Len(t, mounts, 1)
+		assert.Equal(t, "/data", mounts[0].Path)
+		assert.NotNil(t, mounts[0].FS)
+		assert.True(t, mounts[0].ReadOnly)
+	})
+
+	t.Run("writable ipfs path", func(t *testing.T) {
+		_, err := env.Mounts(ctx, []string{"/ipfs/bafkqaaa:/data:rw"}, false)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "read-only")
+	})
+
+	t.Run("missing directory", func(t *testing.T) {
+		_, err := env.Mounts(ctx, []string{filepath.Join(local, "missing") + ":/work"}, false)
+		require.Error(t, err)
+	})
+
+	t.Run("tmp", func(t *testing.T) {
+		mounts, err := env.Mounts(ctx, nil, true)
+		require.NoError(t, err)
+		require.Len(t, mounts, 1)
+		assert.Equal(t, "/tmp", mounts[0].Path)
+		assert.False(t, mounts[0].ReadOnly)
+
+		info, err := os.Stat(mounts[0].Dir)
+		require.NoError(t, err)
+		assert.True(t, info.IsDir())
+	})
+}
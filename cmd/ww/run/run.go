@@ -1,15 +1,16 @@
 package run
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
-	"os"
-	"os/exec"
 	"path/filepath"
-	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ipfs/boxo/path"
 	"github.com/libp2p/go-libp2p/core/event"
@@ -21,6 +22,7 @@ import (
 	"github.com/urfave/cli/v2"
 	"github.com/wetware/go/cmd/internal/flags"
 	"github.com/wetware/go/system"
+	"github.com/wetware/go/util"
 )
 
 var env Env
@@ -48,11 +50,146 @@ func Command() *cli.Command {
 				Usage:   "enable wasm debug info",
 				EnvVars: []string{"WW_WASM_DEBUG"},
 			},
+			&cli.BoolFlag{
+				Name:    "no-cache",
+				Usage:   "disable the on-disk compilation cache",
+				EnvVars: []string{"WW_NO_CACHE"},
+			},
+			&cli.StringFlag{
+				Name:    "name",
+				Aliases: []string{"n"},
+				Usage:   "endpoint `NAME` (defaults to a hash of the binary and peer ID)",
+				EnvVars: []string{"WW_NAME"},
+			},
 			&cli.BoolFlag{
 				Name:    "async",
 				Usage:   "run in async mode for stream processing",
 				EnvVars: []string{"WW_ASYNC"},
 			},
+			&cli.BoolFlag{
+				Name:    "serve",
+				Usage:   "serve every stream by running the command in a fresh instance (implies --async)",
+				EnvVars: []string{"WW_SERVE"},
+			},
+			&cli.StringFlag{
+				Name:    "restart",
+				Usage:   "restart the process when it exits: `never`, on-failure or always",
+				EnvVars: []string{"WW_RESTART"},
+				Value:   "never",
+			},
+			&cli.DurationFlag{
+				Name:    "restart-delay",
+				Usage:   "initial delay between restarts, doubled after each restart",
+				EnvVars: []string{"WW_RESTART_DELAY"},
+				Value:   system.DefaultBackoffMin,
+			},
+			&cli.DurationFlag{
+				Name:    "grace",
+				Usage:   "time given to streams in progress to finish on shutdown, before the guest's shutdown export is called",
+				EnvVars: []string{"WW_GRACE"},
+				Value:   10 * time.Second,
+			},
+			&cli.StringFlag{
+				Name:    "restore",
+				Usage:   "restore the process from the checkpoint at an IPFS `PATH` (implies --async)",
+				EnvVars: []string{"WW_RESTORE"},
+			},
+			&cli.BoolFlag{
+				Name:    "checkpoint",
+				Usage:   "allow callers to save checkpoints of the process with ww checkpoint, and to move it with ww migrate; requires an --acl that allows .migrate to specific peers",
+				EnvVars: []string{"WW_CHECKPOINT"},
+			},
+			&cli.BoolFlag{
+				Name:    "accept-migrations",
+				Usage:   "run processes that other nodes migrate here with ww migrate, without capabilities; requires an --acl that allows .migrate to specific peers",
+				EnvVars: []string{"WW_ACCEPT_MIGRATIONS"},
+			},
+			&cli.PathFlag{
+				Name:    "acl",
+				Usage:   "restrict callers to the peers listed in the JSON `FILE`, reloaded on SIGHUP",
+				EnvVars: []string{"WW_ACL"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "mount",
+				Aliases: []string{"m"},
+				Usage:   "mount an IPFS path or host directory into the guest, as `SRC:DST[:ro|rw]`",
+				EnvVars: []string{"WW_MOUNT"},
+			},
+			&cli.BoolFlag{
+				Name:    "tmp",
+				Usage:   "mount a writable scratch directory at /tmp",
+				EnvVars: []string{"WW_TMP"},
+			},
+			&cli.IntFlag{
+				Name:    "pool-size",
+				Usage:   "maximum number of concurrent module instances in async mode",
+				EnvVars: []string{"WW_POOL_SIZE"},
+				Value:   1,
+			},
+			&cli.BoolFlag{
+				Name:    "fresh",
+				Usage:   "serve every stream with a fresh module instance",
+				EnvVars: []string{"WW_FRESH"},
+			},
+			&cli.IntFlag{
+				Name:    "queue-depth",
+				Usage:   "maximum number of streams waiting for an instance (-1 disables queueing)",
+				EnvVars: []string{"WW_QUEUE_DEPTH"},
+				Value:   system.DefaultQueueDepth,
+			},
+			&cli.DurationFlag{
+				Name:    "queue-timeout",
+				Usage:   "maximum time a stream waits for an instance (0 means no limit)",
+				EnvVars: []string{"WW_QUEUE_TIMEOUT"},
+			},
+			&cli.UintFlag{
+				Name:     "max-memory",
+				Category: "LIMITS",
+				Usage:    "maximum linear memory per instance, in 64KiB pages (0 means no limit)",
+				EnvVars:  []string{"WW_MAX_MEMORY"},
+			},
+			&cli.DurationFlag{
+				Name:     "call-timeout",
+				Category: "LIMITS",
+				Usage:    "maximum wall time of a single call (0 means no limit)",
+				EnvVars:  []string{"WW_CALL_TIMEOUT"},
+			},
+			&cli.DurationFlag{
+				Name:     "run-time",
+				Category: "LIMITS",
+				Usage:    "maximum total wall time the process may spend running, excluding time blocked on I/O (0 means no limit)",
+				EnvVars:  []string{"WW_RUN_TIME"},
+			},
+			&cli.IntFlag{
+				Name:     "max-streams",
+				Category: "P2P LIMITS",
+				Usage:    "maximum inbound streams to the process (0 means the libp2p default)",
+				EnvVars:  []string{"WW_MAX_STREAMS"},
+			},
+			&cli.IntFlag{
+				Name:     "max-streams-per-peer",
+				Category: "P2P LIMITS",
+				Usage:    "maximum inbound streams to the process from a single peer (0 means the libp2p default)",
+				EnvVars:  []string{"WW_MAX_STREAMS_PER_PEER"},
+			},
+			&cli.Int64Flag{
+				Name:     "max-stream-memory",
+				Category: "P2P LIMITS",
+				Usage:    "maximum bytes reserved by the process's streams (0 means the libp2p default)",
+				EnvVars:  []string{"WW_MAX_STREAM_MEMORY"},
+			},
+			&cli.Int64Flag{
+				Name:     "max-stream-memory-per-peer",
+				Category: "P2P LIMITS",
+				Usage:    "maximum bytes reserved by the process's streams from a single peer (0 means the libp2p default)",
+				EnvVars:  []string{"WW_MAX_STREAM_MEMORY_PER_PEER"},
+			},
+			&cli.IntFlag{
+				Name:     "max-conns-per-peer",
+				Category: "P2P LIMITS",
+				Usage:    "maximum connections from a single peer (0 means the libp2p default)",
+				EnvVars:  []string{"WW_MAX_CONNS_PER_PEER"},
+			},
 		}, flags.CapabilityFlags()...),
 
 		// Environment hooks.
@@ -61,6 +198,13 @@ func Command() *cli.Command {
 			env, err = EnvConfig{
 				IPFS: c.String("ipfs"),
 				Port: c.Int("port"),
+				Resources: util.ResourceLimits{
+					Streams:        c.Int("max-streams"),
+					StreamsPerPeer: c.Int("max-streams-per-peer"),
+					Memory:         c.Int64("max-stream-memory"),
+					MemoryPerPeer:  c.Int64("max-stream-memory-per-peer"),
+					ConnsPerPeer:   c.Int("max-conns-per-peer"),
+				},
 			}.New(c.Context)
 			return
 		},
@@ -78,41 +222,138 @@ func Main(c *cli.Context) error {
 	ctx, cancel := context.WithCancel(c.Context)
 	defer cancel()
 
+	restore, err := loadCheckpoint(ctx, c.String("restore"))
+	if err != nil {
+		return fmt.Errorf("failed to load checkpoint: %w", err)
+	}
+
 	// Get the binary path from arguments
 	binaryPath := c.Args().First()
-	if binaryPath == "" {
+	args := c.Args().Slice()
+	if binaryPath == "" && restore == nil {
 		return fmt.Errorf("no binary specified")
 	}
 
-	// Resolve the binary path to WASM bytecode
-	f, err := resolveBinary(ctx, binaryPath)
+	// A checkpoint carries its own bytecode, which a binary given
+	// alongside it must match.
+	var bytecode []byte
+	if binaryPath == "" {
+		bytecode, args = restore.Module, []string{c.String("restore")}
+	} else if bytecode, err = readBinary(ctx, binaryPath); err != nil {
+		return err
+	}
+
+	mounts, err := env.Mounts(ctx, c.StringSlice("mount"), c.Bool("tmp"))
 	if err != nil {
-		return fmt.Errorf("failed to resolve binary %s: %w", binaryPath, err)
+		return err
 	}
-	defer f.Close()
 
-	// Create wazero runtime
-	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+	policy, err := system.ParseRestartPolicy(c.String("restart"))
+	if err != nil {
+		return err
+	}
+
+	acl, err := loadACL(ctx, c.Path("acl"))
+	if err != nil {
+		return fmt.Errorf("failed to load ACL: %w", err)
+	}
+	if c.Bool("accept-migrations") && !acl.Load().Explicit(system.MigrateMethod) {
+		return errMigrateACL
+	}
+	if c.Bool("checkpoint") && !acl.Load().Explicit(system.MigrateMethod) {
+		return errCheckpointACL
+	}
+
+	// Serve mode runs commands on behalf of streams, like async mode, and
+	// only async processes have state to restore.
+	async := c.Bool("async") || c.Bool("serve") || restore != nil
+
+	limits := system.Limits{
+		MaxMemoryPages: uint32(c.Uint("max-memory")),
+		CallTimeout:    c.Duration("call-timeout"),
+		RunTime:        c.Duration("run-time"),
+	}
+
+	config := limits.RuntimeConfig(wazero.NewRuntimeConfig().
 		WithDebugInfoEnabled(c.Bool("wasm-debug")).
+		WithCustomSections(true). // metadata served by .methods
 		WithCloseOnContextDone(true))
-	defer runtime.Close(ctx)
 
-	p, err := system.ProcConfig{
+	// Reuse compiled code from previous runs of the same module.
+	rts := &runtimes{Config: config}
+	if !c.Bool("no-cache") {
+		cache := moduleCache(c)
+		rts.Cache = &cache
+	}
+	defer rts.Close(ctx)
+
+	// Create wazero runtime
+	runtime, err := rts.New(ctx, bytecode)
+	if err != nil {
+		return err
+	}
+
+	sup := &system.Supervisor{
+		Policy:  policy,
+		Backoff: system.Backoff{Min: c.Duration("restart-delay")},
+		OnExit: func(svc *system.Service, err error, restart bool) {
+			slog.WarnContext(ctx, "process exited",
+				"id", svc.Name(),
+				"restarts", svc.Restarts(),
+				"restart", restart,
+				"reason", err)
+		},
+		Migrate: migrateTo(env.Host),
+	}
+	defer sup.Close(ctx)
+
+	procConfig := system.ProcConfig{
+		Name:      c.String("name"),
 		Host:      env.Host,
 		Runtime:   runtime,
-		Src:       f,
+		Src:       io.NopCloser(bytes.NewReader(bytecode)),
 		Env:       c.StringSlice("env"),
-		Args:      c.Args().Slice(),
+		Args:      args,
 		ErrWriter: c.App.ErrWriter,
-		Async:     c.Bool("async"),
-	}.New(ctx)
-	if err != nil && !errors.Is(err, sys.Errno(0)) {
-		return err
+		Async:     async,
+		Serve:     c.Bool("serve"),
+		Pool:      poolConfig(c),
+		Queue: system.QueueConfig{
+			Depth:   c.Int("queue-depth"),
+			Timeout: c.Duration("queue-timeout"),
+		},
+		Mounts:  mounts,
+		Limits:  limits,
+		Caps:    flags.Capabilities(c),
+		Console: c.App.Writer,
+		IPFS:    env.IPFS,
+		Routing: env.DHT,
+		Resolve: env.ResolveBinary,
+
+		Restore:     restore,
+		Checkpoints: c.Bool("checkpoint"),
+		ACL:         acl,
+
+		// Children get a runtime of their own, configured like ours, but
+		// without a compilation cache, which is keyed by our bytecode.
+		RuntimeConfig: config,
 	}
-	defer p.Close(ctx)
 
-	if !p.Config.Async {
+	svc, err := sup.Start(ctx, procConfig)
+	if errors.Is(err, sys.Errno(0)) {
 		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// In sync mode, wait for the last run of the process.
+	if !async {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-svc.Done():
+			return svc.Err()
+		}
 	}
 
 	sub, err := env.Host.EventBus().Subscribe([]any{
@@ -134,136 +375,301 @@ func Main(c *cli.Context) error {
 	// Log connection information for async mode
 	slog.InfoContext(ctx, "process started in async mode",
 		"peer", env.Host.ID(),
-		"endpoint", p.Endpoint.Name)
-
-	// Set up stream handler that matches both exact protocol and with method suffix
-	baseProto := p.Endpoint.Protocol()
-	env.Host.SetStreamHandlerMatch(baseProto, func(protocol protocol.ID) bool {
-		// Match exact base protocol (/ww/0.1.0/<proc-id>) or with method suffix (/ww/0.1.0/<proc-id>/<method>)
-		return protocol == baseProto || strings.HasPrefix(string(protocol), string(baseProto)+"/")
-	}, func(s network.Stream) {
-		defer s.CloseRead()
-
-		// Extract method from protocol string
-		method := "poll" // default
-		protocolStr := string(s.Protocol())
-		if strings.HasPrefix(protocolStr, string(baseProto)+"/") {
-			// Extract method from /ww/0.1.0/<proc-id>/<method>
-			parts := strings.Split(protocolStr, "/")
-			if len(parts) > 0 {
-				method = parts[len(parts)-1]
-			}
-		}
+		"endpoint", svc.Name(),
+		"serve", c.Bool("serve"),
+		"restart", policy)
 
-		slog.InfoContext(ctx, "stream connected",
-			"peer", s.Conn().RemotePeer(),
-			"stream-id", s.ID(),
-			"endpoint", p.Endpoint.Name,
-			"method", method)
-		if err := p.ProcessMessage(ctx, s, method); err != nil {
-			slog.ErrorContext(ctx, "failed to poll process",
-				"id", p.ID(),
-				"stream", s.ID(),
-				"method", method,
-				"reason", err)
+	// Calls outlive ctx, so that they can be drained on shutdown.
+	callCtx, cancelCalls := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelCalls()
+
+	// Set up a stream handler for each service that matches every supported
+	// protocol version, with or without a method suffix, i.e.
+	// /ww/<version>/<proc-id>[/<method>].  The protocol ID is derived from
+	// the service name, so that it survives restarts.
+	var (
+		mu     sync.Mutex
+		protos []protocol.ID
+	)
+	handle := func(svc *system.Service) {
+		env.Limiter.AddService(svc.Name()) // before its first stream
+
+		baseProto := system.ProtocolIDs(svc.Name(), "")[0]
+		mu.Lock()
+		protos = append(protos, baseProto)
+		mu.Unlock()
+
+		env.Host.SetStreamHandlerMatch(baseProto, func(id protocol.ID) bool {
+			_, proc, _, err := system.ParseProtocol(id)
+			return err == nil && proc == svc.Name()
+		}, func(s network.Stream) {
+			serveStream(callCtx, svc, s, acl)
+		})
+	}
+	unhandle := func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, proto := range protos {
+			env.Host.RemoveStreamHandler(proto)
 		}
-	})
-	defer env.Host.RemoveStreamHandler(baseProto)
+	}
+	defer unhandle()
+	handle(svc)
 
+	// Accept processes migrated from other nodes, with our own config.
+	if c.Bool("accept-migrations") {
+		env.Host.SetStreamHandler(system.MigrateProtocol, func(s network.Stream) {
+			acceptMigration(ctx, s, sup, procConfig, rts, acl, handle)
+		})
+		defer env.Host.RemoveStreamHandler(system.MigrateProtocol)
+	}
+
+	done := svc.Done()
 	for {
 		select {
 		case <-ctx.Done():
+			// Stop accepting streams, and let those in progress finish.
+			env.Host.RemoveStreamHandler(system.MigrateProtocol)
+			unhandle()
+
+			grace, cancel := context.WithTimeout(context.Background(), c.Duration("grace"))
+			defer cancel()
+
+			slog.InfoContext(grace, "draining streams",
+				"id", svc.Name(),
+				"grace", c.Duration("grace"))
+			if err := sup.Shutdown(grace); err != nil {
+				slog.WarnContext(grace, "failed to shut down gracefully",
+					"id", svc.Name(),
+					"reason", err)
+			}
 			return ctx.Err()
+		case <-done:
+			// A migrated service keeps forwarding streams to its new
+			// peer, for as long as we run.
+			r := svc.Redirect()
+			if r == nil {
+				return svc.Err() // exited, and not restarted
+			}
+			slog.InfoContext(ctx, "process migrated",
+				"id", svc.Name(),
+				"peer", r.Peer.ID,
+				"endpoint", r.Proc)
+			done = nil
 		case v := <-sub.Out():
-			switch ev := v.(type) {
-			case event.EvtNATDeviceTypeChanged:
-				slog.InfoContext(ctx, "NAT device type changed",
-					"device type", ev.NatDeviceType)
-			case event.EvtLocalReachabilityChanged:
-				slog.InfoContext(ctx, "local reachability changed",
-					"reachability", ev.Reachability)
-			case event.EvtHostReachableAddrsChanged:
-				slog.DebugContext(ctx, "host reachable addresses changed",
-					"reachable", ev.Reachable,
-					"unreachable", ev.Unreachable,
-					"unknown", ev.Unknown)
-			case event.EvtLocalAddressesUpdated:
-				r, err := ev.SignedPeerRecord.Record()
-				if err != nil {
-					return err
-				}
-				signer, err := peer.IDFromPublicKey(ev.SignedPeerRecord.PublicKey)
-				if err != nil {
-					return err
-				}
-				slog.DebugContext(ctx, "local addresses updated",
-					"current", ev.Current,
-					"diffs", ev.Diffs,
-					"peer", r.(*peer.PeerRecord).PeerID,
-					"addrs", r.(*peer.PeerRecord).Addrs,
-					"seq", r.(*peer.PeerRecord).Seq,
-					"signer", signer)
-			case event.EvtPeerIdentificationCompleted:
-				slog.DebugContext(ctx, "peer identification completed",
-					"peer", ev.Peer,
-					"agent-version", ev.AgentVersion,
-					"protocol-version", ev.ProtocolVersion,
-					"protocols", ev.Protocols)
-			case event.EvtPeerIdentificationFailed:
-				slog.WarnContext(ctx, "peer identification failed",
-					"peer", ev.Peer,
-					"reason", ev.Reason)
-			case event.EvtAutoRelayAddrsUpdated:
-				slog.DebugContext(ctx, "auto relay addresses updated",
-					"addresses", ev.RelayAddrs)
-			case event.EvtPeerProtocolsUpdated:
-				slog.DebugContext(ctx, "peer protocols updated",
-					"peer", ev.Peer,
-					"added", ev.Added,
-					"removed", ev.Removed)
-			case event.EvtLocalProtocolsUpdated:
-				slog.DebugContext(ctx, "local protocols updated",
-					"added", ev.Added,
-					"removed", ev.Removed)
-			case event.EvtPeerConnectednessChanged:
-				slog.DebugContext(ctx, "peer connectedness changed",
-					"peer", ev.Peer,
-					"connectedness", ev.Connectedness)
-
-			default:
-				panic(v) // unhandled event
+			if err := logEvent(ctx, v); err != nil {
+				return err
 			}
 		}
 	}
 }
 
-// resolveBinary resolves a binary path to WASM bytecode
-func resolveBinary(ctx context.Context, name string) (io.ReadCloser, error) {
-	// Parse the IPFS path
-	ipfsPath, err := path.NewPath(name)
-	if err == nil {
-		return env.LoadIPFSFile(ctx, ipfsPath)
+// serveStream passes a stream to the service, or forwards it to the peer
+// the service migrated to.
+func serveStream(ctx context.Context, svc *system.Service, s network.Stream, acl *atomic.Pointer[system.ACL]) {
+	defer s.CloseRead()
+
+	// The version and method were validated by the matcher.
+	version, proc, method, _ := system.ParseProtocol(s.Protocol())
+
+	slog.InfoContext(ctx, "stream connected",
+		"peer", s.Conn().RemotePeer(),
+		"stream-id", s.ID(),
+		"endpoint", svc.Name(),
+		"method", method,
+		"version", version)
+
+	if err := acl.Load().Check(s.Conn().RemotePeer(), method); err != nil {
+		_ = s.ResetWithError(system.ErrCodeDenied)
+		slog.WarnContext(ctx, "rejected stream",
+			"id", svc.Name(),
+			"stream", s.ID(),
+			"method", method,
+			"reason", err)
+		return
 	}
 
-	// Check if it's an absolute path
-	if filepath.IsAbs(name) {
-		return os.Open(name)
+	// Streams to a migrated service are forwarded to its new peer.
+	if r := svc.Redirect(); r != nil {
+		if err := system.Forward(ctx, env.Host, s, *r); err != nil {
+			slog.WarnContext(ctx, "failed to forward stream",
+				"id", svc.Name(),
+				"stream", s.ID(),
+				"method", method,
+				"peer", r.Peer.ID,
+				"reason", err)
+		}
+		return
 	}
 
-	// Check if it's a relative path (starts with . or /)
-	if len(name) > 0 && (name[0] == '.' || name[0] == '/') {
-		return os.Open(name)
+	// Account for the stream in the process's own resource scope.
+	if err := s.Scope().SetService(svc.Name()); err != nil {
+		_ = s.ResetWithError(system.ErrCodeBusy)
+		slog.WarnContext(ctx, "rejected stream",
+			"id", svc.Name(),
+			"stream", s.ID(),
+			"method", method,
+			"reason", err)
+		return
 	}
 
-	// Check if it's in $PATH
-	if resolvedPath, err := exec.LookPath(name); err == nil {
-		return os.Open(resolvedPath)
+	// Forward the guest's stderr to the caller, if requested.
+	if version.Stderr() {
+		ts := system.NewTaggedStream(s)
+		ctx = system.WithStderr(ctx, ts.Stderr())
+		s = ts
 	}
 
-	// Try as a relative path in current directory
-	if _, err := os.Stat(name); err == nil {
-		return os.Open(name)
+	// ACLs and logs name the default method poll, but the process is told
+	// the stream has no method, so that serve mode can refuse explicit
+	// calls to poll.
+	call := method
+	if s.Protocol() == version.ProtocolID(proc, "") {
+		call = ""
+	}
+
+	var err error
+	if version.Framed() {
+		// Each frame is a message of its own.
+		err = system.ServeFramed(ctx, s, call, svc.ProcessMessage)
+	} else {
+		err = svc.ProcessMessage(ctx, s, call)
+	}
+	if err == nil && version.CloseWrite() {
+		err = s.CloseWrite()
+	}
+
+	if errors.Is(err, system.ErrBusy) || errors.Is(err, system.ErrStopped) {
+		slog.WarnContext(ctx, "rejected stream",
+			"id", svc.Name(),
+			"stream", s.ID(),
+			"method", method,
+			"reason", err)
+	} else if err != nil {
+		slog.ErrorContext(ctx, "failed to poll process",
+			"id", svc.Name(),
+			"stream", s.ID(),
+			"method", method,
+			"reason", err)
+	}
+}
+
+// logEvent logs an event from the host's event bus.
+func logEvent(ctx context.Context, v any) error {
+	switch ev := v.(type) {
+	case event.EvtNATDeviceTypeChanged:
+		slog.InfoContext(ctx, "NAT device type changed",
+			"device type", ev.NatDeviceType)
+	case event.EvtLocalReachabilityChanged:
+		slog.InfoContext(ctx, "local reachability changed",
+			"reachability", ev.Reachability)
+	case event.EvtHostReachableAddrsChanged:
+		slog.DebugContext(ctx, "host reachable addresses changed",
+			"reachable", ev.Reachable,
+			"unreachable", ev.Unreachable,
+			"unknown", ev.Unknown)
+	case event.EvtLocalAddressesUpdated:
+		r, err := ev.SignedPeerRecord.Record()
+		if err != nil {
+			return err
+		}
+		signer, err := peer.IDFromPublicKey(ev.SignedPeerRecord.PublicKey)
+		if err != nil {
+			return err
+		}
+		slog.DebugContext(ctx, "local addresses updated",
+			"current", ev.Current,
+			"diffs", ev.Diffs,
+			"peer", r.(*peer.PeerRecord).PeerID,
+			"addrs", r.(*peer.PeerRecord).Addrs,
+			"seq", r.(*peer.PeerRecord).Seq,
+			"signer", signer)
+	case event.EvtPeerIdentificationCompleted:
+		slog.DebugContext(ctx, "peer identification completed",
+			"peer", ev.Peer,
+			"agent-version", ev.AgentVersion,
+			"protocol-version", ev.ProtocolVersion,
+			"protocols", ev.Protocols)
+	case event.EvtPeerIdentificationFailed:
+		slog.WarnContext(ctx, "peer identification failed",
+			"peer", ev.Peer,
+			"reason", ev.Reason)
+	case event.EvtAutoRelayAddrsUpdated:
+		slog.DebugContext(ctx, "auto relay addresses updated",
+			"addresses", ev.RelayAddrs)
+	case event.EvtPeerProtocolsUpdated:
+		slog.DebugContext(ctx, "peer protocols updated",
+			"peer", ev.Peer,
+			"added", ev.Added,
+			"removed", ev.Removed)
+	case event.EvtLocalProtocolsUpdated:
+		slog.DebugContext(ctx, "local protocols updated",
+			"added", ev.Added,
+			"removed", ev.Removed)
+	case event.EvtPeerConnectednessChanged:
+		slog.DebugContext(ctx, "peer connectedness changed",
+			"peer", ev.Peer,
+			"connectedness", ev.Connectedness)
+
+	default:
+		panic(v) // unhandled event
+	}
+
+	return nil
+}
+
+// moduleCache returns the compilation cache under the --path directory.
+func moduleCache(c *cli.Context) util.ModuleCache {
+	return util.ModuleCache{Dir: CacheDir(c)}
+}
+
+// CacheDir returns the compilation cache directory under --path.
+func CacheDir(c *cli.Context) string {
+	root, err := ExpandHome(c.String("path"))
+	if err != nil {
+		root = c.String("path")
+	}
+	return filepath.Join(root, "cache")
+}
+
+func poolConfig(c *cli.Context) system.PoolConfig {
+	config := system.PoolConfig{Size: c.Int("pool-size")}
+	if c.Bool("fresh") {
+		config.Reuse = system.ReuseNever
+	}
+	return config
+}
+
+// readBinary reads the WASM bytecode of a binary path.
+func readBinary(ctx context.Context, name string) ([]byte, error) {
+	f, err := env.ResolveBinary(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve binary %s: %w", name, err)
+	}
+	defer f.Close()
+
+	bytecode, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read binary %s: %w", name, err)
+	}
+	return bytecode, nil
+}
+
+// loadCheckpoint loads the checkpoint at an IPFS path, or returns nil if
+// name is empty.
+func loadCheckpoint(ctx context.Context, name string) (*system.Checkpoint, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	p, err := path.NewPath(name)
+	if err != nil {
+		return nil, err
+	}
+	if env.IPFS == nil {
+		return nil, fmt.Errorf("IPFS environment not initialized")
 	}
 
-	return nil, fmt.Errorf("binary not found: %s", name)
+	return system.LoadCheckpoint(ctx, env.IPFS, p)
 }
@@ -0,0 +1,55 @@
+package run
+
+import (
+	"context"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/wetware/go/system"
+	"github.com/wetware/go/util"
+)
+
+// runtimes creates a wazero runtime per module, each with a compilation
+// cache keyed by the module's own bytecode, so that cache entries never
+// hold code compiled from other bytecode.
+type runtimes struct {
+	Config wazero.RuntimeConfig
+	Cache  *util.ModuleCache // nil if caching is disabled
+
+	mu sync.Mutex
+	cs system.CloserSlice
+}
+
+// New returns a runtime for bytecode.  It is closed by Close.
+func (rs *runtimes) New(ctx context.Context, bytecode []byte) (wazero.Runtime, error) {
+	config := rs.Config
+	var cache wazero.CompilationCache
+	if rs.Cache != nil {
+		var err error
+		if cache, err = rs.Cache.Open(bytecode); err != nil {
+			return nil, err
+		}
+		config = config.WithCompilationCache(cache)
+	}
+
+	r := wazero.NewRuntimeWithConfig(ctx, config)
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rs.cs = append(rs.cs, r)
+	if cache != nil {
+		rs.cs = append(rs.cs, cache) // after the runtime that uses it
+	}
+	return r, nil
+}
+
+// Close every runtime, and then its cache.
+func (rs *runtimes) Close(ctx context.Context) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	err := rs.cs.Close(ctx)
+	rs.cs = nil
+	return err
+}
@@ -0,0 +1,51 @@
+package run
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tetratelabs/wazero"
+	"github.com/wetware/go/util"
+)
+
+func TestRuntimes_CacheByBytecode(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cache := util.ModuleCache{Dir: t.TempDir()}
+
+	rts := &runtimes{Config: wazero.NewRuntimeConfig(), Cache: &cache}
+	defer rts.Close(ctx)
+
+	modules := [][]byte{
+		{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}, // empty module
+		{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+			0x01, 0x04, 0x01, 0x60, 0x00, 0x00, // Type section: () -> ()
+			0x03, 0x02, 0x01, 0x00, // Function section: 1 function of type 0
+			0x0a, 0x04, 0x01, 0x02, 0x00, 0x0b}, // Code section: 1 empty body
+	}
+
+	var want []string
+	for _, bytecode := range modules {
+		r, err := rts.New(ctx, bytecode)
+		require.NoError(t, err)
+
+		_, err = r.CompileModule(ctx, bytecode)
+		require.NoError(t, err)
+
+		key, err := cache.Key(bytecode)
+		require.NoError(t, err)
+		want = append(want, key.String())
+	}
+
+	entries, err := cache.Entries()
+	require.NoError(t, err)
+
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Key)
+	}
+	assert.ElementsMatch(t, want, got, "each module should be cached under its own key")
+}
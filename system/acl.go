@@ -0,0 +1,173 @@
+package system
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/mr-tron/base58"
+)
+
+// ErrDenied is returned for streams from peers that an ACL does not permit
+// to call a method.
+var ErrDenied = errors.New("access denied")
+
+// ErrCodeDenied is sent to the remote peer when a stream is reset because
+// an ACL denied it.
+const ErrCodeDenied network.StreamErrorCode = 0x1003
+
+// ACL controls which peers may call a process.  A peer is permitted if it
+// is not denied, and is allowed either explicitly or because the allow list
+// is empty.  Method ACLs further restrict calls to individual methods.
+// A nil ACL permits everyone.
+type ACL struct {
+	Allow, Deny []peer.ID
+	Methods     map[string]MethodACL // by method name
+}
+
+// MethodACL restricts calls to a single method, in addition to the ACL of
+// the process.
+type MethodACL struct {
+	Allow, Deny []peer.ID
+}
+
+// Check returns ErrDenied if id may not call method.
+func (acl *ACL) Check(id peer.ID, method string) error {
+	if acl == nil {
+		return nil
+	}
+
+	if !permit(acl.Allow, acl.Deny, id) {
+		return fmt.Errorf("%w: peer %s may not call %s", ErrDenied, id, method)
+	}
+
+	if m, ok := acl.Methods[method]; ok && !permit(m.Allow, m.Deny, id) {
+		return fmt.Errorf("%w: peer %s may not call %s", ErrDenied, id, method)
+	}
+
+	return nil
+}
+
+// Explicit reports whether method may only be called by the peers that its
+// method ACL allows by name.  It is false for a nil ACL, and for methods
+// without an allow list of their own, which anyone permitted by the process
+// ACL may call.
+func (acl *ACL) Explicit(method string) bool {
+	return acl != nil && len(acl.Methods[method].Allow) > 0
+}
+
+// explicit reports whether the ACL of the process explicitly allows method;
+// see ACL.Explicit.
+func (c ProcConfig) explicit(method string) bool {
+	return c.ACL != nil && c.ACL.Load().Explicit(method)
+}
+
+func permit(allow, deny []peer.ID, id peer.ID) bool {
+	return !slices.Contains(deny, id) && (len(allow) == 0 || slices.Contains(allow, id))
+}
+
+// LoadACL reads an ACL from a JSON file of the form:
+//
+//	{
+//	  "allow": ["12D3KooW..."],
+//	  "deny": [],
+//	  "methods": {
+//	    "admin": {"allow": ["<base58-encoded public key>"]}
+//	  }
+//	}
+//
+// Entries are peer IDs or public keys; see ParsePrincipal.
+func LoadACL(name string) (*ACL, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	acl, err := ParseACL(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return acl, nil
+}
+
+// ParseACL parses an ACL in the format read by LoadACL.
+func ParseACL(data []byte) (*ACL, error) {
+	type list struct {
+		Allow []string `json:"allow"`
+		Deny  []string `json:"deny"`
+	}
+	var file struct {
+		list
+		Methods map[string]list `json:"methods"`
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("invalid ACL: %w", err)
+	}
+
+	acl := &ACL{Methods: make(map[string]MethodACL, len(file.Methods))}
+	var err error
+	if acl.Allow, err = parsePrincipals(file.Allow); err != nil {
+		return nil, err
+	}
+	if acl.Deny, err = parsePrincipals(file.Deny); err != nil {
+		return nil, err
+	}
+
+	for method, l := range file.Methods {
+		var m MethodACL
+		if m.Allow, err = parsePrincipals(l.Allow); err != nil {
+			return nil, fmt.Errorf("%s: %w", method, err)
+		}
+		if m.Deny, err = parsePrincipals(l.Deny); err != nil {
+			return nil, fmt.Errorf("%s: %w", method, err)
+		}
+		acl.Methods[method] = m
+	}
+
+	return acl, nil
+}
+
+func parsePrincipals(ss []string) ([]peer.ID, error) {
+	ids := make([]peer.ID, 0, len(ss))
+	for _, s := range ss {
+		id, err := ParsePrincipal(s)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// ParsePrincipal parses a peer ID, or a base58-encoded public key, and
+// returns the peer ID it stands for.  Private keys, such as those printed
+// by `ww idgen`, are rejected, so that they are not written to ACL files.
+func ParsePrincipal(s string) (peer.ID, error) {
+	if id, err := peer.Decode(s); err == nil {
+		return id, nil
+	}
+
+	b, err := base58.Decode(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid principal %q: not a peer ID or public key", s)
+	}
+
+	if pub, err := crypto.UnmarshalPublicKey(b); err == nil {
+		return peer.IDFromPublicKey(pub)
+	}
+	if _, err := crypto.UnmarshalPrivateKey(b); err == nil {
+		// Not quoted, so as not to leak the key into logs.
+		return "", errors.New("invalid principal: private key; use its peer ID instead")
+	}
+
+	return "", fmt.Errorf("invalid principal %q: not a peer ID or public key", s)
+}
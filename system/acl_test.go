@@ -0,0 +1,148 @@
+package system_test
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/mr-tron/base58"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wetware/go/system"
+)
+
+// newPeer returns a peer ID, along with its private key encoded as by
+// `ww idgen`, and its public key encoded likewise.  ACLs accept only the
+// latter.
+func newPeer(t *testing.T) (id peer.ID, priv, pub string) {
+	t.Helper()
+
+	sk, pk, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+
+	id, err = peer.IDFromPublicKey(pk)
+	require.NoError(t, err)
+
+	b, err := crypto.MarshalPrivateKey(sk)
+	require.NoError(t, err)
+	priv = base58.Encode(b)
+
+	b, err = crypto.MarshalPublicKey(pk)
+	require.NoError(t, err)
+	pub = base58.Encode(b)
+
+	return id, priv, pub
+}
+
+func TestParsePrincipal(t *testing.T) {
+	t.Parallel()
+
+	id, priv, pub := newPeer(t)
+	for _, s := range []string{id.String(), pub} {
+		got, err := system.ParsePrincipal(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, id, got, s)
+	}
+
+	_, err := system.ParsePrincipal(priv)
+	require.ErrorContains(t, err, "private key")
+	assert.NotContains(t, err.Error(), priv, "error should not leak the key")
+
+	_, err = system.ParsePrincipal("not-a-peer")
+	assert.ErrorContains(t, err, "not a peer ID or public key")
+}
+
+func TestACL_Check(t *testing.T) {
+	t.Parallel()
+
+	alice, _, _ := newPeer(t)
+	bob, _, _ := newPeer(t)
+	carol, _, _ := newPeer(t)
+
+	var nilACL *system.ACL
+	assert.NoError(t, nilACL.Check(alice, "echo"), "nil ACL should permit everyone")
+
+	acl := &system.ACL{
+		Allow: []peer.ID{alice, bob},
+		Methods: map[string]system.MethodACL{
+			"admin": {Allow: []peer.ID{alice}},
+			"echo":  {Deny: []peer.ID{bob}},
+		},
+	}
+
+	for _, tt := range []struct {
+		peer   peer.ID
+		method string
+		ok     bool
+	}{
+		{alice, "poll", true},
+		{alice, "admin", true},
+		{alice, "echo", true},
+		{bob, "poll", true},
+		{bob, "admin", false},
+		{bob, "echo", false},
+		{carol, "poll", false},
+		{carol, "admin", false},
+	} {
+		err := acl.Check(tt.peer, tt.method)
+		if tt.ok {
+			assert.NoError(t, err, "%s %s", tt.peer, tt.method)
+		} else {
+			assert.ErrorIs(t, err, system.ErrDenied, "%s %s", tt.peer, tt.method)
+		}
+	}
+
+	// Deny takes precedence over allow.
+	acl = &system.ACL{Allow: []peer.ID{alice}, Deny: []peer.ID{alice}}
+	assert.ErrorIs(t, acl.Check(alice, "poll"), system.ErrDenied)
+}
+
+func TestACL_Explicit(t *testing.T) {
+	t.Parallel()
+
+	alice, _, _ := newPeer(t)
+
+	var nilACL *system.ACL
+	assert.False(t, nilACL.Explicit("admin"))
+
+	acl := &system.ACL{
+		Allow: []peer.ID{alice},
+		Methods: map[string]system.MethodACL{
+			"admin": {Allow: []peer.ID{alice}},
+			"echo":  {Deny: []peer.ID{alice}},
+		},
+	}
+	assert.True(t, acl.Explicit("admin"))
+	assert.False(t, acl.Explicit("echo"), "a deny list alone allows everyone else")
+	assert.False(t, acl.Explicit("other"))
+}
+
+func TestLoadACL(t *testing.T) {
+	t.Parallel()
+
+	alice, _, pub := newPeer(t)
+	bob, priv, _ := newPeer(t)
+
+	name := filepath.Join(t.TempDir(), "acl.json")
+	require.NoError(t, os.WriteFile(name, []byte(`{
+		"deny": ["`+bob.String()+`"],
+		"methods": {"admin": {"allow": ["`+pub+`"]}}
+	}`), 0o600))
+
+	acl, err := system.LoadACL(name)
+	require.NoError(t, err)
+	assert.Equal(t, []peer.ID{bob}, acl.Deny)
+	assert.Equal(t, []peer.ID{alice}, acl.Methods["admin"].Allow)
+
+	_, err = system.ParseACL([]byte(`{"allow": ["` + pub + `"], "extra": true}`))
+	assert.ErrorContains(t, err, "unknown field")
+
+	_, err = system.ParseACL([]byte(`{"methods": {"admin": {"deny": ["nope"]}}}`))
+	assert.ErrorContains(t, err, "admin")
+
+	_, err = system.ParseACL([]byte(`{"allow": ["` + priv + `"]}`))
+	assert.ErrorContains(t, err, "private key")
+}
@@ -0,0 +1,78 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// CallInfo describes the call a guest is serving.
+type CallInfo struct {
+	Peer     peer.ID   // remote peer, if known
+	Method   string    // export being called
+	Stream   string    // stream ID
+	Deadline time.Time // zero if the call has no deadline
+}
+
+// String returns the call info as "key\tvalue\n" lines, in the format
+// returned to guests by call_info.  The deadline is given in nanoseconds
+// since the Unix epoch, and omitted if there is none.
+func (info CallInfo) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "peer\t%s\n", info.Peer)
+	fmt.Fprintf(&b, "method\t%s\n", info.Method)
+	fmt.Fprintf(&b, "stream\t%s\n", info.Stream)
+	if !info.Deadline.IsZero() {
+		fmt.Fprintf(&b, "deadline\t%d\n", info.Deadline.UnixNano())
+	}
+	return b.String()
+}
+
+type callKey struct{}
+
+// call is the stream and method being served, bound to the context of a
+// call.  CallInfo is derived from it on demand.
+type call struct {
+	stream network.Stream
+	method string
+}
+
+func withCall(ctx context.Context, s network.Stream, method string) context.Context {
+	return context.WithValue(ctx, callKey{}, call{stream: s, method: method})
+}
+
+// CallInfoFromContext returns information about the call served with ctx.
+// It returns false outside of a call, e.g. while a module is instantiated.
+func CallInfoFromContext(ctx context.Context) (CallInfo, bool) {
+	c, ok := ctx.Value(callKey{}).(call)
+	if !ok {
+		return CallInfo{}, false
+	}
+
+	info := CallInfo{Method: c.method, Stream: c.stream.ID()}
+	if conn := c.stream.Conn(); conn != nil {
+		info.Peer = conn.RemotePeer()
+	}
+	info.Deadline, _ = ctx.Deadline()
+	return info, true
+}
+
+// callInfo(out, out_cap i32) -> i32
+//
+// Writes information about the call being served to out, as "key\tvalue\n"
+// lines (see CallInfo.String), and returns its length.  Returns 0 outside
+// of a call.
+func (h *hostModule) callInfo(ctx context.Context, mod api.Module, stack []uint64) {
+	info, ok := CallInfoFromContext(ctx)
+	if !ok {
+		stack[0] = 0
+		return
+	}
+
+	stack[0] = writeString(mod, info.String(), stack[0], stack[1])
+}
@@ -0,0 +1,111 @@
+package system_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tetratelabs/wazero"
+	"github.com/wetware/go/system"
+)
+
+// callInfoWasm exports "info", which calls ww.call_info(0, 1024) and stores
+// the result at offset 1024.
+var callInfoWasm = []byte{
+	0x00, 0x61, 0x73, 0x6d, // WASM magic number
+	0x01, 0x00, 0x00, 0x00, // Version 1
+	0x01, 0x0a, 0x02, // Type section: 2 types
+	0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7f, // (i32, i32) -> i32
+	0x60, 0x00, 0x00, // () -> ()
+	0x02, 0x10, 0x01, // Import section: 1 import
+	0x02, 0x77, 0x77, 0x09, 0x63, 0x61, 0x6c, 0x6c, 0x5f, 0x69, 0x6e, 0x66, 0x6f, 0x00, 0x00, // "ww" "call_info" type 0
+	0x03, 0x02, 0x01, 0x01, // Function section: 1 function of type 1
+	0x05, 0x03, 0x01, 0x00, 0x01, // Memory section: 1 memory, min 1 page
+	0x07, 0x11, 0x02, // Export section: 2 exports
+	0x06, 0x6d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x02, 0x00, // "memory" memory 0
+	0x04, 0x69, 0x6e, 0x66, 0x6f, 0x00, 0x01, // "info" function 1
+	0x0a, 0x11, 0x01, // Code section: 1 body
+	0x0f, 0x00, 0x41, 0x80, 0x08, 0x41, 0x00, 0x41, 0x80, 0x08, // i32.const 1024, call_info(0, 1024)
+	0x10, 0x00, 0x36, 0x02, 0x00, 0x0b, // i32.store
+}
+
+// noDeadlineStream ignores deadlines, which mocknet streams do not support.
+type noDeadlineStream struct{ network.Stream }
+
+func (noDeadlineStream) SetDeadline(time.Time) error      { return nil }
+func (noDeadlineStream) SetReadDeadline(time.Time) error  { return nil }
+func (noDeadlineStream) SetWriteDeadline(time.Time) error { return nil }
+
+func TestHostModule_CallInfo(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	proc, err := system.ProcConfig{
+		Runtime:   runtime,
+		Src:       io.NopCloser(bytes.NewReader(callInfoWasm)),
+		ErrWriter: &bytes.Buffer{},
+		Async:     true,
+	}.New(ctx)
+	require.NoError(t, err)
+	defer proc.Close(ctx)
+
+	mn, err := mocknet.FullMeshLinked(2)
+	require.NoError(t, err)
+	defer mn.Close()
+	server, client := mn.Hosts()[0], mn.Hosts()[1]
+
+	deadline := time.Now().Add(time.Minute)
+	streams := make(chan network.Stream, 1)
+	server.SetStreamHandler(system.ProtocolID(proc.ID(), "info"), func(s network.Stream) {
+		defer s.Close()
+
+		ctx, cancel := context.WithDeadline(ctx, deadline)
+		defer cancel()
+
+		assert.NoError(t, proc.ProcessMessage(ctx, noDeadlineStream{s}, "info"))
+		streams <- s
+	})
+
+	s, err := client.NewStream(ctx, server.ID(), system.ProtocolID(proc.ID(), "info"))
+	require.NoError(t, err)
+	defer s.Close()
+	require.NoError(t, s.CloseWrite())
+	served := <-streams
+
+	mem := proc.Module.Memory()
+	n, ok := mem.ReadUint32Le(1024)
+	require.True(t, ok)
+	require.Less(t, int32(n), int32(1024), "call_info should succeed")
+
+	info, ok := mem.Read(0, n)
+	require.True(t, ok)
+	assert.Equal(t, fmt.Sprintf("peer\t%s\nmethod\tinfo\nstream\t%s\ndeadline\t%d\n",
+		client.ID(), served.ID(), deadline.UnixNano()), string(info))
+
+	// Outside of a call, there is nothing to report.
+	_, err = proc.Module.ExportedFunction("info").Call(ctx)
+	require.NoError(t, err)
+	n, _ = mem.ReadUint32Le(1024)
+	assert.Zero(t, n)
+}
+
+func TestCallInfo_String(t *testing.T) {
+	t.Parallel()
+
+	info := system.CallInfo{Method: "echo", Stream: "s1"}
+	assert.Equal(t, "peer\t\nmethod\techo\nstream\ts1\n", info.String())
+
+	info.Deadline = time.Unix(0, 42)
+	assert.Equal(t, "peer\t\nmethod\techo\nstream\ts1\ndeadline\t42\n", info.String())
+}
@@ -0,0 +1,45 @@
+package system
+
+import "strings"
+
+// Capability is a set of host facilities that may be granted to a guest
+// through the "ww" host module.  Guests receive no capabilities by default.
+type Capability uint32
+
+const (
+	CapConsole Capability = 1 << iota // write to the host console
+	CapIPFS                           // read and add IPFS content
+	CapExec                           // spawn child processes
+	CapP2P                            // open streams to other processes
+
+	CapAll = CapConsole | CapIPFS | CapExec | CapP2P
+)
+
+var capNames = []struct {
+	Cap  Capability
+	Name string
+}{
+	{CapConsole, "console"},
+	{CapIPFS, "ipfs"},
+	{CapExec, "exec"},
+	{CapP2P, "p2p"},
+}
+
+// Has reports whether every capability in want is present in c.
+func (c Capability) Has(want Capability) bool {
+	return c&want == want
+}
+
+func (c Capability) String() string {
+	if c == 0 {
+		return "none"
+	}
+
+	var names []string
+	for _, n := range capNames {
+		if c.Has(n.Cap) {
+			names = append(names, n.Name)
+		}
+	}
+	return strings.Join(names, "|")
+}
@@ -0,0 +1,379 @@
+package system
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/boxo/files"
+	"github.com/ipfs/boxo/path"
+	iface "github.com/ipfs/kubo/core/coreiface"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// CheckpointMethod is the built-in method that saves a checkpoint of the
+// process to IPFS, and answers with its path.  It is answered by the host,
+// and only served by processes with ProcConfig.Checkpoints set.  Since it
+// publishes the memory of the process, it is denied unless ProcConfig.ACL
+// allows it to peers by name.
+const CheckpointMethod = ".checkpoint"
+
+// Names of the files in the UnixFS directory of a checkpoint.
+const (
+	checkpointManifest = "checkpoint.json"
+	checkpointModule   = "module.wasm"
+	checkpointMemory   = "memory"
+)
+
+// Checkpoint is the state of an async process at rest, i.e. between calls:
+// the linear memory and mutable globals of its instance, along with its
+// bytecode.  Globals that are not exported cannot be read by the host, so
+// modules that define mutable globals without exporting them, such as the
+// __stack_pointer of most toolchains, cannot be checkpointed.
+type Checkpoint struct {
+	Proc    string            `json:"proc"`    // endpoint name
+	Globals map[string]uint64 `json:"globals"` // exported mutable globals, by name
+	Module  []byte            `json:"-"`       // bytecode
+	Memory  []byte            `json:"-"`       // linear memory
+}
+
+// Checkpoint saves the state of the process.  It waits for an instance to
+// be idle, so the state is never that of a call in progress.  Only async
+// processes with a single, reused instance have state to save.
+func (p Proc) Checkpoint(ctx context.Context) (*Checkpoint, error) {
+	c, err := p.checkpoint(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: checkpoint: %w", p.ID(), err)
+	}
+	return c, nil
+}
+
+func (p Proc) checkpoint(ctx context.Context) (*Checkpoint, error) {
+	switch {
+	case !p.Config.Async:
+		return nil, errors.New("requires async mode")
+	case p.Config.Pool.Reuse == ReuseNever:
+		return nil, errors.New("requires instances to be reused")
+	case p.Config.Pool.size() > 1:
+		return nil, errors.New("requires a pool size of 1")
+	}
+
+	globals, err := mutableGlobals(p.bytecode)
+	if err != nil {
+		return nil, err
+	}
+
+	inst, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Pool.Release(ctx, inst)
+
+	c := &Checkpoint{
+		Proc:    p.ID(),
+		Globals: make(map[string]uint64, len(globals)),
+		Module:  p.bytecode,
+	}
+
+	if mem := inst.Module.Memory(); mem != nil {
+		b, _ := mem.Read(0, mem.Size())
+		c.Memory = bytes.Clone(b)
+	}
+
+	for _, name := range globals {
+		if g, ok := inst.Module.ExportedGlobal(name).(api.MutableGlobal); ok {
+			c.Globals[name] = g.Get()
+		}
+	}
+
+	return c, nil
+}
+
+// stackPointer is the name of the global that holds the stack pointer of
+// modules built by LLVM-based toolchains, such as clang, rustc and TinyGo.
+const stackPointer = "__stack_pointer"
+
+// mutableGlobals returns the names of the mutable globals defined by a
+// valid module, which wazero does not report before instantiation.  It
+// fails if any of them is not exported, and so could not be saved.
+//
+// The stack pointer is the exception.  Checkpoints are taken between
+// calls, when the stack is empty and the stack pointer is back at its
+// initial value, which the instances restored from the checkpoint start
+// with, so it need not be saved.  Since toolchains do not export it, it is
+// recognized by its name in the "name" section, and modules stripped of
+// that section must export it.
+func mutableGlobals(bytecode []byte) ([]string, error) {
+	var (
+		imported int                // imported globals, which come first in the index space
+		mutable  []int              // indices of mutable globals
+		exported = map[int]string{} // names of exported globals, by index
+		debug    = map[int]string{} // names of globals in the "name" section, by index
+	)
+
+	for id, body := range moduleSections(bytecode) {
+		var ok bool
+		switch id {
+		case 0:
+			globalNames(body, debug)
+			ok = true
+		case 2:
+			imported, ok = importedGlobals(body)
+		case 6:
+			mutable, ok = definedMutableGlobals(body, imported)
+		case 7:
+			ok = exportedGlobals(body, exported)
+		default:
+			ok = true
+		}
+		if !ok {
+			return nil, fmt.Errorf("unsupported encoding of section %d", id)
+		}
+	}
+
+	names := make([]string, 0, len(mutable))
+	for _, i := range mutable {
+		if name, ok := exported[i]; ok {
+			names = append(names, name)
+		} else if name, ok := debug[i]; !ok {
+			return nil, fmt.Errorf("mutable global %d is not exported, so its state cannot be saved", i)
+		} else if name != stackPointer {
+			return nil, fmt.Errorf("mutable global %d (%s) is not exported, so its state cannot be saved", i, name)
+		}
+	}
+	return names, nil
+}
+
+// importedGlobals returns the number of globals in an import section.
+func importedGlobals(body []byte) (count int, ok bool) {
+	r := &sectionReader{b: body, ok: true}
+	for n := r.uleb(); n > 0 && r.ok; n-- {
+		r.name() // module
+		r.name() // name
+		switch r.byte() {
+		case 0: // function
+			r.uleb()
+		case 1: // table
+			r.byte()
+			r.limits()
+		case 2: // memory
+			r.limits()
+		case 3: // global
+			r.byte()
+			r.byte()
+			count++
+		default:
+			return 0, false
+		}
+	}
+	return count, r.ok
+}
+
+// definedMutableGlobals returns the indices of the mutable globals in a
+// global section, which follow the imported globals.
+func definedMutableGlobals(body []byte, imported int) (mutable []int, ok bool) {
+	r := &sectionReader{b: body, ok: true}
+	n := int(r.uleb())
+	for i := 0; i < n && r.ok; i++ {
+		r.byte() // value type
+		if r.byte() == 1 {
+			mutable = append(mutable, imported+i)
+		}
+		r.constExpr()
+	}
+	return mutable, r.ok
+}
+
+// exportedGlobals adds the globals of an export section to names, by index.
+func exportedGlobals(body []byte, names map[int]string) bool {
+	r := &sectionReader{b: body, ok: true}
+	for n := r.uleb(); n > 0 && r.ok; n-- {
+		name := r.name()
+		kind := r.byte()
+		index := r.uleb()
+		if kind == 3 {
+			names[int(index)] = name
+		}
+	}
+	return r.ok
+}
+
+// globalNames adds the global names of a "name" custom section to names, by
+// index.  Other custom sections, and names that cannot be decoded, are
+// ignored.
+func globalNames(body []byte, names map[int]string) {
+	r := &sectionReader{b: body, ok: true}
+	if r.name() != "name" {
+		return
+	}
+
+	for r.ok && len(r.b) > 0 {
+		id := r.byte()
+		sub := &sectionReader{b: r.bytes(r.uleb()), ok: r.ok}
+		if id != 7 { // global names
+			continue
+		}
+
+		for n := sub.uleb(); n > 0 && sub.ok; n-- {
+			index := sub.uleb()
+			if name := sub.name(); sub.ok {
+				names[int(index)] = name
+			}
+		}
+		return
+	}
+}
+
+// constExpr skips a constant expression, as found in global initializers.
+func (r *sectionReader) constExpr() {
+	for r.ok {
+		switch op := r.byte(); op {
+		case 0x0b: // end
+			return
+		case 0x41, 0x42, 0x23, 0xd2: // i32.const, i64.const, global.get, ref.func
+			r.uleb() // LEB128 integers have the same length, signed or not
+		case 0x43: // f32.const
+			r.bytes(4)
+		case 0x44: // f64.const
+			r.bytes(8)
+		case 0xd0: // ref.null
+			r.byte()
+		case 0x6a, 0x6b, 0x6c, 0x7c, 0x7d, 0x7e: // extended constants
+		case 0xfd: // v128.const
+			if r.uleb() != 12 {
+				r.ok = false
+			}
+			r.bytes(16)
+		default:
+			r.ok = false
+		}
+	}
+}
+
+// restore the state of c into a fresh instance of its module.
+func (c *Checkpoint) restore(mod api.Module) error {
+	if len(c.Memory) > 0 {
+		mem := mod.Memory()
+		if mem == nil {
+			return errors.New("restore: module has no memory")
+		}
+
+		if size := mem.Size(); uint64(len(c.Memory)) > uint64(size) {
+			pages := (uint64(len(c.Memory)) - uint64(size) + 65535) / 65536
+			if _, ok := mem.Grow(uint32(pages)); !ok {
+				return &LimitError{Limit: LimitMemory}
+			}
+		}
+
+		// Memory cannot shrink, so anything past the checkpoint is zeroed.
+		mem.Write(0, c.Memory)
+		if rest := mem.Size() - uint32(len(c.Memory)); rest > 0 {
+			mem.Write(uint32(len(c.Memory)), make([]byte, rest))
+		}
+	}
+
+	for name, v := range c.Globals {
+		g, ok := mod.ExportedGlobal(name).(api.MutableGlobal)
+		if !ok {
+			return fmt.Errorf("restore: no mutable global %q", name)
+		}
+		g.Set(v)
+	}
+
+	return nil
+}
+
+// Save adds the checkpoint to IPFS as a UnixFS directory, and returns its
+// path.
+func (c *Checkpoint) Save(ctx context.Context, ipfs iface.CoreAPI) (path.ImmutablePath, error) {
+	manifest, err := json.Marshal(c)
+	if err != nil {
+		return path.ImmutablePath{}, err
+	}
+
+	return ipfs.Unixfs().Add(ctx, files.NewMapDirectory(map[string]files.Node{
+		checkpointManifest: files.NewBytesFile(manifest),
+		checkpointModule:   files.NewBytesFile(c.Module),
+		checkpointMemory:   files.NewBytesFile(c.Memory),
+	}))
+}
+
+// LoadCheckpoint reads a checkpoint saved to IPFS by Checkpoint.Save.
+func LoadCheckpoint(ctx context.Context, ipfs iface.CoreAPI, p path.Path) (*Checkpoint, error) {
+	read := func(name string) ([]byte, error) {
+		fp, err := path.Join(p, name)
+		if err != nil {
+			return nil, err
+		}
+
+		node, err := ipfs.Unixfs().Get(ctx, fp)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fp, err)
+		}
+		defer node.Close()
+
+		f, ok := node.(files.File)
+		if !ok {
+			return nil, fmt.Errorf("%s: not a file", fp)
+		}
+		return io.ReadAll(f)
+	}
+
+	manifest, err := read(checkpointManifest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid checkpoint: %w", err)
+	}
+
+	var c Checkpoint
+	if err := json.Unmarshal(manifest, &c); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint: %w", err)
+	}
+
+	if c.Module, err = read(checkpointModule); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint: %w", err)
+	}
+	if c.Memory, err = read(checkpointMemory); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint: %w", err)
+	}
+
+	return &c, nil
+}
+
+// serveCheckpoint saves a checkpoint of the process to IPFS, and writes its
+// path to s, followed by a newline.
+func (p Proc) serveCheckpoint(ctx context.Context, s network.Stream) error {
+	if !p.Config.explicit(CheckpointMethod) {
+		_ = s.ResetWithError(ErrCodeDenied)
+		return fmt.Errorf("%s::%s: %w: not allowed to specific peers", p.ID(), CheckpointMethod, ErrDenied)
+	}
+
+	err := p.saveCheckpoint(ctx, s)
+	if err != nil {
+		_ = s.Reset()
+		return fmt.Errorf("%s::%s: %w", p.ID(), CheckpointMethod, err)
+	}
+	return nil
+}
+
+func (p Proc) saveCheckpoint(ctx context.Context, w io.Writer) error {
+	if p.Config.IPFS == nil {
+		return errors.New("no IPFS node")
+	}
+
+	c, err := p.checkpoint(ctx)
+	if err != nil {
+		return err
+	}
+
+	cp, err := c.Save(ctx, p.Config.IPFS)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(w, cp)
+	return err
+}
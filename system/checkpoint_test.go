@@ -0,0 +1,266 @@
+package system_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ipfs/boxo/path"
+	iface "github.com/ipfs/kubo/core/coreiface"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wetware/go/system"
+	"github.com/wetware/go/system/mocks"
+	"go.uber.org/mock/gomock"
+)
+
+// counterWasm exports a mutable global "count", and "incr", which
+// increments both it and the first byte of memory.  "check" traps unless
+// both are 2.
+var counterWasm = []byte{
+	0x00, 0x61, 0x73, 0x6d, // WASM magic number
+	0x01, 0x00, 0x00, 0x00, // Version 1
+	0x01, 0x04, 0x01, 0x60, 0x00, 0x00, // Type section: () -> ()
+	0x03, 0x03, 0x02, 0x00, 0x00, // Function section: 2 functions of type 0
+	0x05, 0x03, 0x01, 0x00, 0x01, // Memory section: 1 memory, min 1 page
+	0x06, 0x06, 0x01, 0x7f, 0x01, 0x41, 0x00, 0x0b, // Global section: mutable i32 = 0
+	0x07, 0x18, 0x03, // Export section: 3 exports
+	0x05, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x03, 0x00, // "count" global 0
+	0x04, 0x69, 0x6e, 0x63, 0x72, 0x00, 0x00, // "incr" function 0
+	0x05, 0x63, 0x68, 0x65, 0x63, 0x6b, 0x00, 0x01, // "check" function 1
+	0x0a, 0x30, 0x02, // Code section: 2 bodies
+	0x16, 0x00, 0x23, 0x00, 0x41, 0x01, 0x6a, 0x24, 0x00, // g += 1
+	0x41, 0x00, 0x41, 0x00, 0x2d, 0x00, 0x00, 0x41, 0x01, 0x6a, 0x3a, 0x00, 0x00, 0x0b, // mem[0] += 1
+	0x17, 0x00, 0x23, 0x00, 0x41, 0x02, 0x47, 0x04, 0x40, 0x00, 0x0b, // if g != 2 { unreachable }
+	0x41, 0x00, 0x2d, 0x00, 0x00, 0x41, 0x02, 0x47, 0x04, 0x40, 0x00, 0x0b, 0x0b, // if mem[0] != 2 { unreachable }
+}
+
+func TestProc_Checkpoint(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	proc, err := newTestProc(t, system.ProcConfig{Async: true, Name: "counter"}, counterWasm)
+	require.NoError(t, err)
+
+	for range 2 {
+		require.NoError(t, proc.ProcessMessage(ctx, mocks.NewMockStreamInterface(ctrl), "incr"))
+	}
+
+	c, err := proc.Checkpoint(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "counter", c.Proc)
+	assert.Equal(t, map[string]uint64{"count": 2}, c.Globals)
+	assert.Equal(t, counterWasm, c.Module)
+	require.Len(t, c.Memory, 65536)
+	assert.Equal(t, byte(2), c.Memory[0])
+
+	t.Run("Restore", func(t *testing.T) {
+		t.Parallel()
+
+		restored, err := newTestProc(t, system.ProcConfig{Async: true, Restore: c}, counterWasm)
+		require.NoError(t, err)
+		assert.Equal(t, "counter", restored.ID(), "should keep its endpoint name")
+		assert.NoError(t, restored.ProcessMessage(ctx, mocks.NewMockStreamInterface(ctrl), "check"))
+	})
+
+	t.Run("Fresh", func(t *testing.T) {
+		t.Parallel()
+
+		fresh, err := newTestProc(t, system.ProcConfig{Async: true}, counterWasm)
+		require.NoError(t, err)
+
+		s := mocks.NewMockStreamInterface(ctrl)
+		s.EXPECT().ResetWithError(system.ErrCodeTrap).Return(nil)
+		assert.Error(t, fresh.ProcessMessage(ctx, s, "check"))
+	})
+
+	t.Run("DifferentModule", func(t *testing.T) {
+		t.Parallel()
+
+		other := *c
+		other.Module = shutdownWasm
+
+		_, err := newTestProc(t, system.ProcConfig{Async: true, Restore: &other}, counterWasm)
+		assert.ErrorContains(t, err, "different module")
+	})
+
+	t.Run("MemoryLimit", func(t *testing.T) {
+		t.Parallel()
+
+		big := *c
+		big.Memory = make([]byte, 2*65536)
+
+		_, err := newTestProc(t, system.ProcConfig{
+			Async:   true,
+			Restore: &big,
+			Limits:  system.Limits{MaxMemoryPages: 1},
+		}, counterWasm)
+		var limitErr *system.LimitError
+		assert.ErrorAs(t, err, &limitErr)
+	})
+}
+
+func TestProc_Checkpoint_RequiresState(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	for name, pool := range map[string]system.PoolConfig{
+		"ReuseNever": {Reuse: system.ReuseNever},
+		"PoolSize":   {Size: 2},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			proc, err := newTestProc(t, system.ProcConfig{Async: true, Pool: pool}, counterWasm)
+			require.NoError(t, err)
+
+			_, err = proc.Checkpoint(ctx)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestProc_Checkpoint_UnexportedGlobal(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	// The mutable global of reactorWasm is not exported, so its state
+	// would be lost.
+	proc, err := newTestProc(t, system.ProcConfig{Async: true}, reactorWasm)
+	require.NoError(t, err)
+
+	_, err = proc.Checkpoint(ctx)
+	assert.ErrorContains(t, err, "not exported")
+}
+
+func TestProc_Checkpoint_StackPointer(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// The echo example is built by TinyGo, whose __stack_pointer is a
+	// mutable global that is not exported, but named in the "name" section.
+	echo := func(p *system.Proc, input string) string {
+		done := make(chan struct{})
+		close(done)
+
+		var out bytes.Buffer
+		require.NoError(t, p.ProcessMessage(ctx, echoStream(ctrl, input, done, &out), "echo"))
+		return out.String()
+	}
+
+	proc, err := newTestProc(t, system.ProcConfig{Async: true}, loadEchoWasm(t))
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n", echo(proc, "hello\n"))
+
+	c, err := proc.Checkpoint(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, c.Globals, "stack pointer should not be saved")
+
+	restored, err := newTestProc(t, system.ProcConfig{Async: true, Restore: c}, c.Module)
+	require.NoError(t, err)
+
+	assert.Equal(t, "world\n", echo(restored, "world\n"))
+}
+
+func TestCheckpoint_SaveLoad(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	ipfs := fakeIPFS{fs: &fakeUnixfs{
+		files: map[string][]byte{},
+		dirs:  map[string][]iface.DirEntry{},
+	}}
+
+	c := &system.Checkpoint{
+		Proc:    "counter",
+		Globals: map[string]uint64{"count": 2},
+		Module:  counterWasm,
+		Memory:  []byte{2, 0, 0, 0},
+	}
+
+	p, err := c.Save(ctx, ipfs)
+	require.NoError(t, err)
+
+	loaded, err := system.LoadCheckpoint(ctx, ipfs, p)
+	require.NoError(t, err)
+	assert.Equal(t, c, loaded)
+}
+
+func TestProcessMessage_Checkpoint(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	fs := &fakeUnixfs{
+		files: map[string][]byte{},
+		dirs:  map[string][]iface.DirEntry{},
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var acl atomic.Pointer[system.ACL]
+	acl.Store(&system.ACL{
+		Methods: map[string]system.MethodACL{
+			system.CheckpointMethod: {Allow: []peer.ID{"caller"}},
+		},
+	})
+
+	t.Run("Enabled", func(t *testing.T) {
+		proc, err := newTestProc(t, system.ProcConfig{
+			Async:       true,
+			IPFS:        fakeIPFS{fs: fs},
+			Checkpoints: true,
+			ACL:         &acl,
+		}, counterWasm)
+		require.NoError(t, err)
+
+		var out bytes.Buffer
+		s := mocks.NewMockStreamInterface(ctrl)
+		s.EXPECT().Write(gomock.Any()).DoAndReturn(out.Write).AnyTimes()
+		require.NoError(t, proc.ProcessMessage(ctx, s, system.CheckpointMethod))
+
+		line, err := bufio.NewReader(&out).ReadString('\n')
+		require.NoError(t, err)
+		p, err := path.NewPath(line[:len(line)-1])
+		require.NoError(t, err)
+
+		c, err := system.LoadCheckpoint(ctx, fakeIPFS{fs: fs}, p)
+		require.NoError(t, err)
+		assert.Equal(t, proc.ID(), c.Proc)
+	})
+
+	t.Run("NoExplicitACL", func(t *testing.T) {
+		proc, err := newTestProc(t, system.ProcConfig{
+			Async:       true,
+			IPFS:        fakeIPFS{fs: fs},
+			Checkpoints: true,
+		}, counterWasm)
+		require.NoError(t, err)
+
+		s := mocks.NewMockStreamInterface(ctrl)
+		s.EXPECT().ResetWithError(system.ErrCodeDenied).Return(nil)
+		assert.ErrorIs(t, proc.ProcessMessage(ctx, s, system.CheckpointMethod), system.ErrDenied)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		proc, err := newTestProc(t, system.ProcConfig{Async: true, IPFS: fakeIPFS{fs: fs}}, counterWasm)
+		require.NoError(t, err)
+
+		s := mocks.NewMockStreamInterface(ctrl)
+		s.EXPECT().ResetWithError(system.ErrCodeUnknownMethod).Return(nil)
+		assert.ErrorIs(t, proc.ProcessMessage(ctx, s, system.CheckpointMethod), system.ErrUnknownMethod)
+	})
+}
@@ -0,0 +1,152 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/tetratelabs/wazero/sys"
+	"go.uber.org/multierr"
+)
+
+// ShutdownExport is the optional guest export that is called on each
+// instance of a process when it is shut down gracefully, after the calls in
+// progress have returned.  It cannot be called as a method.
+const ShutdownExport = "shutdown"
+
+// ErrDraining is returned for streams sent to a process that is shutting
+// down.  It is a kind of ErrBusy, so such streams are reset with
+// ErrCodeBusy.
+var ErrDraining = fmt.Errorf("%w: draining", ErrBusy)
+
+// calls counts the calls in progress, and refuses new calls once draining.
+// A nil *calls admits every call.
+type calls struct {
+	mu       sync.Mutex
+	active   int
+	draining bool
+	idle     chan struct{} // closed once draining with no calls in progress
+}
+
+func newCalls() *calls {
+	return &calls{idle: make(chan struct{})}
+}
+
+// enter reports whether a call may start.  Callers that get true MUST call
+// exit when the call returns.
+func (c *calls) enter() bool {
+	if c == nil {
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.draining {
+		return false
+	}
+	c.active++
+	return true
+}
+
+func (c *calls) exit() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.active--; c.active == 0 && c.draining {
+		close(c.idle)
+	}
+}
+
+// drain refuses new calls, and waits for the calls in progress to return,
+// or for ctx to expire.
+func (c *calls) drain(ctx context.Context) error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	if !c.draining {
+		c.draining = true
+		if c.active == 0 {
+			close(c.idle)
+		}
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-c.idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// resume admits new calls again, after drain.
+func (c *calls) resume() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.draining {
+		c.draining = false
+		c.idle = make(chan struct{})
+	}
+}
+
+// Drain stops the process from accepting streams, which are rejected with
+// ErrDraining from then on, and waits for the calls in progress to return,
+// or for ctx to expire.
+func (p Proc) Drain(ctx context.Context) error {
+	if err := p.calls.drain(ctx); err != nil {
+		return fmt.Errorf("%s: drain: %w", p.ID(), err)
+	}
+	return nil
+}
+
+// Resume undoes Drain, so that the process accepts streams again.
+func (p Proc) Resume() {
+	p.calls.resume()
+}
+
+// Shutdown calls the guest's shutdown export, if any, on each idle instance.
+// Use Drain first, so that no instance is serving a stream.  Each call is
+// subject to the limits of the process, and to ctx.
+func (p Proc) Shutdown(ctx context.Context) error {
+	if p.Pool == nil {
+		return nil
+	}
+
+	var errs []error
+	for _, inst := range p.Pool.Idle() {
+		fn := inst.Module.ExportedFunction(ShutdownExport)
+		if fn == nil || inst.Module.IsClosed() {
+			continue
+		}
+
+		ctx, cancel, err := p.Config.Limits.withCallLimit(ctx, p.runTime)
+		if err != nil {
+			return multierr.Combine(append(errs, err)...)
+		}
+
+		var exitErr *sys.ExitError
+		if _, err = fn.Call(ctx); errors.As(err, &exitErr) && exitErr.ExitCode() == 0 {
+			err = nil
+		}
+		cancel()
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s::%s: %w", p.ID(), ShutdownExport, err))
+		}
+	}
+
+	return multierr.Combine(errs...)
+}
@@ -0,0 +1,147 @@
+package system_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wetware/go/system"
+	"github.com/wetware/go/system/mocks"
+	"go.uber.org/mock/gomock"
+)
+
+// shutdownWasm exports "arm", which sets a global, "ok", which does nothing,
+// and "shutdown", which traps if the global is set.
+var shutdownWasm = []byte{
+	0x00, 0x61, 0x73, 0x6d, // WASM magic number
+	0x01, 0x00, 0x00, 0x00, // Version 1
+	0x01, 0x04, 0x01, 0x60, 0x00, 0x00, // Type section: () -> ()
+	0x03, 0x04, 0x03, 0x00, 0x00, 0x00, // Function section: 3 functions of type 0
+	0x06, 0x06, 0x01, 0x7f, 0x01, 0x41, 0x00, 0x0b, // Global section: mutable i32 = 0
+	0x07, 0x17, 0x03, // Export section: 3 exports
+	0x03, 0x61, 0x72, 0x6d, 0x00, 0x00, // "arm" function 0
+	0x02, 0x6f, 0x6b, 0x00, 0x01, // "ok" function 1
+	0x08, 0x73, 0x68, 0x75, 0x74, 0x64, 0x6f, 0x77, 0x6e, 0x00, 0x02, // "shutdown" function 2
+	0x0a, 0x14, 0x03, // Code section: 3 bodies
+	0x06, 0x00, 0x41, 0x01, 0x24, 0x00, 0x0b, // g = 1
+	0x02, 0x00, 0x0b, // nop
+	0x08, 0x00, 0x23, 0x00, 0x04, 0x40, 0x00, 0x0b, 0x0b, // if g { unreachable }
+}
+
+func TestProc_Drain(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	proc, err := newTestProc(t, system.ProcConfig{Async: true}, loadEchoWasm(t))
+	require.NoError(t, err)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// The call blocks at EOF until released.
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s := mocks.NewMockStreamInterface(ctrl)
+	s.EXPECT().Read(gomock.Any()).DoAndReturn(func(p []byte) (int, error) {
+		select {
+		case <-started:
+		default:
+			close(started)
+		}
+		<-release
+		return 0, io.EOF
+	}).AnyTimes()
+	s.EXPECT().Write(gomock.Any()).DoAndReturn(io.Discard.Write).AnyTimes()
+
+	cherr := make(chan error, 1)
+	go func() {
+		cherr <- proc.ProcessMessage(ctx, s, "echo")
+	}()
+	<-started
+
+	// The call in progress outlives the grace period.
+	grace, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, proc.Drain(grace), context.DeadlineExceeded)
+
+	// New streams are rejected as busy.
+	rejected := mocks.NewMockStreamInterface(ctrl)
+	rejected.EXPECT().ResetWithError(system.ErrCodeBusy).Return(nil)
+	err = proc.ProcessMessage(ctx, rejected, "echo")
+	assert.ErrorIs(t, err, system.ErrDraining)
+	assert.ErrorIs(t, err, system.ErrBusy)
+
+	// Once the call returns, the process is drained.
+	close(release)
+	require.NoError(t, <-cherr)
+	assert.NoError(t, proc.Drain(ctx))
+}
+
+func TestProc_Shutdown(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	t.Run("Succeeds", func(t *testing.T) {
+		t.Parallel()
+
+		proc, err := newTestProc(t, system.ProcConfig{Async: true}, shutdownWasm)
+		require.NoError(t, err)
+		require.NoError(t, proc.ProcessMessage(ctx, mocks.NewMockStreamInterface(ctrl), "ok"))
+		assert.NoError(t, proc.Shutdown(ctx))
+	})
+
+	t.Run("Fails", func(t *testing.T) {
+		t.Parallel()
+
+		proc, err := newTestProc(t, system.ProcConfig{Async: true}, shutdownWasm)
+		require.NoError(t, err)
+		require.NoError(t, proc.ProcessMessage(ctx, mocks.NewMockStreamInterface(ctrl), "arm"))
+		assert.ErrorContains(t, proc.Shutdown(ctx), system.ShutdownExport,
+			"shutdown export should have been called")
+	})
+
+	t.Run("NotAMethod", func(t *testing.T) {
+		t.Parallel()
+
+		proc, err := newTestProc(t, system.ProcConfig{Async: true}, shutdownWasm)
+		require.NoError(t, err)
+		s := mocks.NewMockStreamInterface(ctrl)
+		s.EXPECT().ResetWithError(system.ErrCodeUnknownMethod).Return(nil)
+		assert.ErrorIs(t, proc.ProcessMessage(ctx, s, system.ShutdownExport), system.ErrUnknownMethod)
+		assert.NotContains(t, proc.Methods().Methods,
+			system.Method{Name: system.ShutdownExport, Params: []string{}, Results: []string{}})
+	})
+}
+
+func TestService_Shutdown(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sup := &system.Supervisor{Policy: system.RestartAlways}
+	svc := startService(t, sup, system.ProcConfig{Async: true}, shutdownWasm)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	require.NoError(t, svc.ProcessMessage(ctx, mocks.NewMockStreamInterface(ctrl), "arm"))
+	assert.ErrorContains(t, svc.Shutdown(ctx), system.ShutdownExport)
+
+	// The service is stopped for good, regardless of the restart policy.
+	select {
+	case <-svc.Done():
+	default:
+		t.Fatal("service should be done")
+	}
+
+	s := mocks.NewMockStreamInterface(ctrl)
+	s.EXPECT().ResetWithError(system.ErrCodeBusy).Return(nil)
+	assert.ErrorIs(t, svc.ProcessMessage(ctx, s, "ok"), system.ErrStopped)
+}
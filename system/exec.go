@@ -0,0 +1,257 @@
+package system
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+const (
+	ExitFailure = 1   // the child could not be loaded, or trapped
+	ExitKilled  = 137 // the child was killed
+)
+
+// child is a process spawned by a guest.  Each child runs its _start
+// function to completion in its own runtime.
+type child struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	code   uint32
+}
+
+// Wait for the child to exit and return its exit code.
+func (c *child) Wait(ctx context.Context) (uint32, error) {
+	select {
+	case <-c.done:
+		return c.code, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// Close kills the child and waits for it to exit.
+func (c *child) Close() error {
+	c.cancel()
+	<-c.done
+	return nil
+}
+
+// spawn(path, path_len, argv, argv_len, caps, stdin, stdout i32) -> i32
+//
+// Starts a child process from the bytecode at path, and returns its pid.
+// Argv is a NUL-separated list of arguments.  The child is granted caps,
+// restricted to the capabilities of the parent.  Its stdin and stdout are
+// bound to the given handles, which are transferred to the child and
+// closed when it exits; a handle of 0 leaves the stream unbound.
+func (h *hostModule) spawn(ctx context.Context, mod api.Module, stack []uint64) {
+	name, ok := mod.Memory().Read(api.DecodeU32(stack[0]), api.DecodeU32(stack[1]))
+	if !ok {
+		stack[0] = errno(ErrnoFault)
+		return
+	}
+
+	argv, ok := mod.Memory().Read(api.DecodeU32(stack[2]), api.DecodeU32(stack[3]))
+	if !ok {
+		stack[0] = errno(ErrnoFault)
+		return
+	}
+
+	stdin, stdout := api.DecodeI32(stack[5]), api.DecodeI32(stack[6])
+	r, w, ok := h.stdio(mod, stdin, stdout)
+	if !ok {
+		stack[0] = errno(ErrnoBadHandle)
+		return
+	}
+
+	if h.Config.Resolve == nil {
+		stack[0] = errno(ErrnoIO)
+		return
+	}
+
+	src, err := h.Config.Resolve(ctx, string(name))
+	if err != nil {
+		stack[0] = errno(ErrnoIO)
+		return
+	}
+
+	config := ProcConfig{
+		Host:          h.Config.Host,
+		Src:           src,
+		Env:           h.Config.Env,
+		Args:          splitArgs(string(name), argv),
+		ErrWriter:     h.Config.ErrWriter,
+		Limits:        h.Config.Limits,
+		Caps:          Capability(api.DecodeU32(stack[4])) & h.Config.Caps,
+		Console:       h.Config.Console,
+		IPFS:          h.Config.IPFS,
+		Routing:       h.Config.Routing,
+		Resolve:       h.Config.Resolve,
+		RuntimeConfig: h.Config.RuntimeConfig,
+		Stdin:         r,
+		Stdout:        w,
+
+		// Children spend the run-time budget of their parent.
+		runTime: h.Config.runTime,
+	}
+
+	// The handles are transferred to the child.
+	h.handles(mod).Remove(stdin)
+	h.handles(mod).Remove(stdout)
+
+	stack[0] = api.EncodeI32(h.handles(mod).Add(config.start(h.ctx)))
+}
+
+// start runs the process in the background, in a runtime of its own.
+func (c ProcConfig) start(ctx context.Context) *child {
+	ctx, cancel := context.WithCancel(ctx)
+	ch := &child{cancel: cancel, done: make(chan struct{})}
+
+	go func() {
+		defer close(ch.done)
+		defer cancel()
+
+		// Close the streams, so that the other end sees EOF.  A
+		// bidirectional handle may be bound to both.
+		streams := []any{c.Stdin}
+		if any(c.Stdout) != any(c.Stdin) {
+			streams = append(streams, c.Stdout)
+		}
+		for _, s := range streams {
+			if closer, ok := s.(io.Closer); ok {
+				defer closer.Close()
+			}
+		}
+
+		rc := c.RuntimeConfig
+		if rc == nil {
+			rc = wazero.NewRuntimeConfig()
+		}
+		c.Runtime = wazero.NewRuntimeWithConfig(ctx, rc.WithCloseOnContextDone(true))
+		defer c.Runtime.Close(context.Background())
+
+		proc, err := c.New(ctx)
+		if err == nil {
+			proc.Close(context.Background())
+		}
+
+		ch.code = exitCode(ctx, err)
+	}()
+
+	return ch
+}
+
+func exitCode(ctx context.Context, err error) uint32 {
+	if ctx.Err() != nil {
+		return ExitKilled
+	}
+
+	var exitErr *sys.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	} else if err != nil {
+		return ExitFailure
+	}
+
+	return 0
+}
+
+// wait(pid i32) -> i32
+//
+// Blocks until the child exits, releases its pid, and returns its exit
+// code.
+func (h *hostModule) wait(ctx context.Context, mod api.Module, stack []uint64) {
+	pid := api.DecodeI32(stack[0])
+
+	ch, ok := handleAs[*child](h.handles(mod), pid)
+	if !ok {
+		stack[0] = errno(ErrnoBadHandle)
+		return
+	}
+
+	code, err := ch.Wait(ctx)
+	if err != nil {
+		stack[0] = errno(ErrnoIO)
+		return
+	}
+	h.handles(mod).Remove(pid)
+
+	stack[0] = api.EncodeU32(code)
+}
+
+// kill(pid i32) -> i32
+//
+// Stops the child.  Returns 0 on success.  The pid remains valid until it
+// is waited on.
+func (h *hostModule) kill(ctx context.Context, mod api.Module, stack []uint64) {
+	ch, ok := handleAs[*child](h.handles(mod), api.DecodeI32(stack[0]))
+	if !ok {
+		stack[0] = errno(ErrnoBadHandle)
+		return
+	}
+
+	ch.cancel()
+	stack[0] = 0
+}
+
+// pipe(out i32) -> i32
+//
+// Creates a pipe, and writes its read and write handles to out as two
+// little-endian i32s.  Returns 0 on success.
+func (h *hostModule) pipe(ctx context.Context, mod api.Module, stack []uint64) {
+	out := api.DecodeU32(stack[0])
+	if _, ok := mod.Memory().Read(out, 8); !ok {
+		stack[0] = errno(ErrnoFault)
+		return
+	}
+
+	pr, pw := io.Pipe()
+	var buf [8]byte
+	binary.LittleEndian.PutUint32(buf[:4], uint32(h.handles(mod).Add(pr)))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(h.handles(mod).Add(pw)))
+	mod.Memory().Write(out, buf[:])
+
+	stack[0] = 0
+}
+
+// stdio returns the objects for the stdin and stdout handles of a child,
+// without removing them from the table of mod.  A handle of 0 leaves the
+// stream unbound.  The same handle may be bound to both, if it can be read
+// and written, e.g. a stream to another process.
+func (h *hostModule) stdio(mod api.Module, stdin, stdout int32) (r io.Reader, w io.Writer, ok bool) {
+	r, w = bytes.NewReader(nil), io.Discard
+
+	if stdin != 0 {
+		if r, ok = handleAs[io.Reader](h.handles(mod), stdin); !ok {
+			return nil, nil, false
+		}
+	}
+
+	if stdout != 0 {
+		if w, ok = handleAs[io.Writer](h.handles(mod), stdout); !ok {
+			return nil, nil, false
+		}
+	}
+
+	return r, w, true
+}
+
+// splitArgs splits a NUL-separated argument list.  If argv is empty, the
+// process is passed its own name as its only argument.
+func splitArgs(name string, argv []byte) []string {
+	argv = bytes.TrimSuffix(argv, []byte{0})
+	if len(argv) == 0 {
+		return []string{name}
+	}
+
+	var args []string
+	for _, arg := range bytes.Split(argv, []byte{0}) {
+		args = append(args, string(arg))
+	}
+	return args
+}
@@ -0,0 +1,184 @@
+package system_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/wetware/go/system"
+)
+
+// execWasm imports ww.spawn, ww.pipe, ww.wait, ww.kill, ww.close, ww.write
+// and ww.read, and re-exports them unchanged under the same names, along
+// with its memory, so that tests can drive the host functions directly.
+var execWasm = []byte{
+	0x00, 0x61, 0x73, 0x6d, // WASM magic number
+	0x01, 0x00, 0x00, 0x00, // Version 1
+	0x01, 0x18, 0x03, // Type section: 3 types
+	0x60, 0x07, 0x7f, 0x7f, 0x7f, 0x7f, 0x7f, 0x7f, 0x7f, 0x01, 0x7f, // (i32 x 7) -> i32
+	0x60, 0x01, 0x7f, 0x01, 0x7f, // (i32) -> i32
+	0x60, 0x03, 0x7f, 0x7f, 0x7f, 0x01, 0x7f, // (i32, i32, i32) -> i32
+	0x02, 0x4a, 0x07, // Import section: 7 imports
+	0x02, 0x77, 0x77, 0x05, 0x73, 0x70, 0x61, 0x77, 0x6e, 0x00, 0x00, // "ww" "spawn" type 0
+	0x02, 0x77, 0x77, 0x04, 0x70, 0x69, 0x70, 0x65, 0x00, 0x01, // "ww" "pipe" type 1
+	0x02, 0x77, 0x77, 0x04, 0x77, 0x61, 0x69, 0x74, 0x00, 0x01, // "ww" "wait" type 1
+	0x02, 0x77, 0x77, 0x04, 0x6b, 0x69, 0x6c, 0x6c, 0x00, 0x01, // "ww" "kill" type 1
+	0x02, 0x77, 0x77, 0x05, 0x63, 0x6c, 0x6f, 0x73, 0x65, 0x00, 0x01, // "ww" "close" type 1
+	0x02, 0x77, 0x77, 0x05, 0x77, 0x72, 0x69, 0x74, 0x65, 0x00, 0x02, // "ww" "write" type 2
+	0x02, 0x77, 0x77, 0x04, 0x72, 0x65, 0x61, 0x64, 0x00, 0x02, // "ww" "read" type 2
+	0x03, 0x08, 0x07, 0x00, 0x01, 0x01, 0x01, 0x01, 0x02, 0x02, // Function section: 7 functions
+	0x05, 0x03, 0x01, 0x00, 0x01, // Memory section: 1 memory, min 1 page
+	0x07, 0x3e, 0x08, // Export section: 8 exports
+	0x06, 0x6d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x02, 0x00, // "memory" memory 0
+	0x05, 0x73, 0x70, 0x61, 0x77, 0x6e, 0x00, 0x07, // "spawn" function 7
+	0x04, 0x70, 0x69, 0x70, 0x65, 0x00, 0x08, // "pipe" function 8
+	0x04, 0x77, 0x61, 0x69, 0x74, 0x00, 0x09, // "wait" function 9
+	0x04, 0x6b, 0x69, 0x6c, 0x6c, 0x00, 0x0a, // "kill" function 10
+	0x05, 0x63, 0x6c, 0x6f, 0x73, 0x65, 0x00, 0x0b, // "close" function 11
+	0x05, 0x77, 0x72, 0x69, 0x74, 0x65, 0x00, 0x0c, // "write" function 12
+	0x04, 0x72, 0x65, 0x61, 0x64, 0x00, 0x0d, // "read" function 13
+	0x0a, 0x46, 0x07, // Code section: 7 bodies
+	0x12, 0x00, 0x20, 0x00, 0x20, 0x01, 0x20, 0x02, 0x20, 0x03, // spawn(p0, ..., p6)
+	0x20, 0x04, 0x20, 0x05, 0x20, 0x06, 0x10, 0x00, 0x0b,
+	0x06, 0x00, 0x20, 0x00, 0x10, 0x01, 0x0b, // pipe(p0)
+	0x06, 0x00, 0x20, 0x00, 0x10, 0x02, 0x0b, // wait(p0)
+	0x06, 0x00, 0x20, 0x00, 0x10, 0x03, 0x0b, // kill(p0)
+	0x06, 0x00, 0x20, 0x00, 0x10, 0x04, 0x0b, // close(p0)
+	0x0a, 0x00, 0x20, 0x00, 0x20, 0x01, 0x20, 0x02, 0x10, 0x05, 0x0b, // write(p0, p1, p2)
+	0x0a, 0x00, 0x20, 0x00, 0x20, 0x01, 0x20, 0x02, 0x10, 0x06, 0x0b, // read(p0, p1, p2)
+}
+
+// pipe returns the read and write handles of a new pipe.
+func pipe(t *testing.T, mod api.Module) (r, w int32) {
+	t.Helper()
+
+	const out = 256
+	require.Zero(t, call(t, mod, "pipe", out))
+
+	buf, ok := mod.Memory().Read(out, 8)
+	require.True(t, ok)
+	return int32(binary.LittleEndian.Uint32(buf)), int32(binary.LittleEndian.Uint32(buf[4:]))
+}
+
+// spawn name with caps, binding its stdin and stdout to the given handles.
+func spawn(t *testing.T, mod api.Module, name string, caps system.Capability, stdin, stdout int32) int32 {
+	t.Helper()
+
+	require.True(t, mod.Memory().WriteString(0, name))
+	return call(t, mod, "spawn", 0, uint64(len(name)), 0, 0,
+		uint64(caps), api.EncodeI32(stdin), api.EncodeI32(stdout))
+}
+
+func TestHostModule_Exec(t *testing.T) {
+	t.Parallel()
+
+	binaries := map[string][]byte{
+		"echo.wasm":    loadEchoWasm(t),
+		"console.wasm": consoleWasm,
+	}
+
+	// newExecModule returns a guest that can spawn the binaries above.
+	newExecModule := func(t *testing.T, caps system.Capability, limits system.Limits) api.Module {
+		proc, err := newTestProc(t, system.ProcConfig{
+			Async:   true,
+			Caps:    caps,
+			Limits:  limits,
+			Console: io.Discard,
+			Resolve: func(ctx context.Context, name string) (io.ReadCloser, error) {
+				if b, ok := binaries[name]; ok {
+					return io.NopCloser(bytes.NewReader(b)), nil
+				}
+				return nil, fmt.Errorf("binary not found: %s", name)
+			},
+		}, execWasm)
+		require.NoError(t, err)
+		return proc.Module
+	}
+
+	t.Run("spawn and wait", func(t *testing.T) {
+		mod := newExecModule(t, system.CapExec, system.Limits{})
+
+		inR, inW := pipe(t, mod)
+		outR, outW := pipe(t, mod)
+
+		pid := spawn(t, mod, "echo.wasm", 0, inR, outW)
+		require.Positive(t, pid)
+
+		msg := "hello, child\n"
+		require.True(t, mod.Memory().WriteString(512, msg))
+		assert.Equal(t, int32(len(msg)), call(t, mod, "write", api.EncodeI32(inW), 512, uint64(len(msg))))
+		require.Zero(t, call(t, mod, "close", api.EncodeI32(inW)))
+
+		assert.Equal(t, msg, readAll(t, mod, outR, 4))
+		assert.Zero(t, call(t, mod, "wait", api.EncodeI32(pid)))
+
+		// Waiting releases the pid.
+		assert.Equal(t, -int32(system.ErrnoBadHandle), call(t, mod, "wait", api.EncodeI32(pid)))
+	})
+
+	t.Run("kill", func(t *testing.T) {
+		mod := newExecModule(t, system.CapExec, system.Limits{})
+
+		// The child blocks reading stdin, which we never close.
+		inR, _ := pipe(t, mod)
+		pid := spawn(t, mod, "echo.wasm", 0, inR, 0)
+		require.Positive(t, pid)
+
+		require.Zero(t, call(t, mod, "kill", api.EncodeI32(pid)))
+		assert.Equal(t, int32(system.ExitKilled), call(t, mod, "wait", api.EncodeI32(pid)))
+	})
+
+	t.Run("child capabilities are a subset", func(t *testing.T) {
+		// The parent cannot grant the console capability it does not have,
+		// so a child that imports console_write fails to load.
+		mod := newExecModule(t, system.CapExec, system.Limits{})
+		pid := spawn(t, mod, "console.wasm", system.CapAll, 0, 0)
+		require.Positive(t, pid)
+		assert.Equal(t, int32(system.ExitFailure), call(t, mod, "wait", api.EncodeI32(pid)))
+
+		mod = newExecModule(t, system.CapExec|system.CapConsole, system.Limits{})
+		pid = spawn(t, mod, "console.wasm", system.CapConsole, 0, 0)
+		require.Positive(t, pid)
+		assert.Zero(t, call(t, mod, "wait", api.EncodeI32(pid)))
+	})
+
+	t.Run("binary not found", func(t *testing.T) {
+		mod := newExecModule(t, system.CapExec, system.Limits{})
+		assert.Equal(t, -int32(system.ErrnoIO), spawn(t, mod, "missing.wasm", 0, 0, 0))
+	})
+
+	t.Run("bad stdio handle", func(t *testing.T) {
+		mod := newExecModule(t, system.CapExec, system.Limits{})
+
+		// The write end of a pipe cannot be used as stdin, nor its read end
+		// as stdout.
+		r, w := pipe(t, mod)
+		assert.Equal(t, -int32(system.ErrnoBadHandle), spawn(t, mod, "echo.wasm", 0, w, 0))
+		assert.Equal(t, -int32(system.ErrnoBadHandle), spawn(t, mod, "echo.wasm", 0, 0, r))
+		assert.Equal(t, -int32(system.ErrnoBadHandle), spawn(t, mod, "echo.wasm", 0, r, r))
+
+		// The handles are not transferred to a child that was not spawned.
+		assert.Zero(t, call(t, mod, "close", api.EncodeI32(r)))
+		assert.Zero(t, call(t, mod, "close", api.EncodeI32(w)))
+	})
+
+	t.Run("child limits", func(t *testing.T) {
+		mod := newExecModule(t, system.CapExec, system.Limits{
+			CallTimeout: 50 * time.Millisecond,
+		})
+
+		// The child blocks reading stdin, which we never close, until it
+		// runs out of time.
+		inR, _ := pipe(t, mod)
+		pid := spawn(t, mod, "echo.wasm", 0, inR, 0)
+		require.Positive(t, pid)
+		assert.Equal(t, int32(system.ExitFailure), call(t, mod, "wait", api.EncodeI32(pid)))
+	})
+}
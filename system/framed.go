@@ -0,0 +1,115 @@
+package system
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-msgio"
+)
+
+// MaxFrameSize bounds the size of request and response frames in framed
+// protocol versions.
+const MaxFrameSize = 1 << 20
+
+// ErrFrameTooLarge is returned to guests whose response exceeds MaxFrameSize.
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// ServeFramed reads varint length-prefixed frames from s, and passes each one
+// to process as a message of its own, along with method.  The output of each
+// message is written back to s as a single frame.  ServeFramed returns nil
+// once s reaches EOF.  If a frame cannot be read or processed, the stream is
+// reset and the error returned.
+func ServeFramed(ctx context.Context, s network.Stream, method string, process func(context.Context, network.Stream, string) error) error {
+	r := msgio.NewVarintReaderSize(s, MaxFrameSize)
+	w := frameWriter(s)
+
+	for {
+		if err := serveFrame(ctx, s, r, w, method, process); err == io.EOF {
+			return nil
+		} else if err != nil {
+			// Don't leave the client waiting for a response.
+			_ = s.Reset()
+			return err
+		}
+	}
+}
+
+// frameWriter returns the writer of response frames.  Streams that frame
+// their own output, such as TaggedStream, are used as is.
+func frameWriter(s network.Stream) msgio.Writer {
+	if w, ok := s.(msgio.Writer); ok {
+		return w
+	}
+	return msgio.NewVarintWriter(s)
+}
+
+// serveFrame processes a single request frame.  The memory used by the
+// request and its response is reserved in the stream's resource scope.
+func serveFrame(ctx context.Context, s network.Stream, r msgio.Reader, w msgio.Writer, method string, process func(context.Context, network.Stream, string) error) error {
+	n, err := r.NextMsgLen()
+	if err == io.EOF {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("read frame: %w", err)
+	}
+
+	if err := s.Scope().ReserveMemory(n, network.ReservationPriorityMedium); err != nil {
+		return fmt.Errorf("read frame: %w", err)
+	}
+	defer s.Scope().ReleaseMemory(n)
+
+	msg, err := r.ReadMsg()
+	if err != nil {
+		return fmt.Errorf("read frame: %w", err)
+	}
+	defer r.ReleaseMsg(msg)
+
+	f := &frame{Stream: s, in: bytes.NewReader(msg)}
+	defer f.release()
+
+	if err := process(ctx, f, method); err != nil {
+		return err
+	}
+
+	if err := w.WriteMsg(f.out.Bytes()); err != nil {
+		return fmt.Errorf("write frame: %w", err)
+	}
+	return nil
+}
+
+// frame is a single message carried by a framed stream.  The guest reads
+// the request frame, and its output is buffered until the call returns.
+// Closing a frame leaves the underlying stream open for the next one.
+type frame struct {
+	network.Stream
+	in  *bytes.Reader
+	out bytes.Buffer
+}
+
+// release the memory reserved for the output.
+func (f *frame) release() {
+	f.Scope().ReleaseMemory(f.out.Len())
+}
+
+func (f *frame) Read(p []byte) (int, error) {
+	return f.in.Read(p)
+}
+
+func (f *frame) Write(p []byte) (int, error) {
+	if f.out.Len()+len(p) > MaxFrameSize {
+		return 0, ErrFrameTooLarge
+	}
+
+	if err := f.Scope().ReserveMemory(len(p), network.ReservationPriorityMedium); err != nil {
+		return 0, err
+	}
+	return f.out.Write(p)
+}
+
+func (f *frame) Close() error      { return nil }
+func (f *frame) CloseRead() error  { return nil }
+func (f *frame) CloseWrite() error { return nil }
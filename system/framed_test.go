@@ -0,0 +1,71 @@
+package system_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/libp2p/go-msgio"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wetware/go/system"
+)
+
+func TestServeFramed(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	mn, err := mocknet.FullMeshLinked(2)
+	require.NoError(t, err)
+	defer mn.Close()
+	server, client := mn.Hosts()[0], mn.Hosts()[1]
+
+	echo, err := newTestProc(t, system.ProcConfig{Async: true}, loadEchoWasm(t))
+	require.NoError(t, err)
+	errs := make(chan error, 1)
+	server.SetStreamHandlerMatch(system.V0_2_0_Framed.ProtocolID(echo.ID(), ""), func(id protocol.ID) bool {
+		v, _, _, err := system.ParseProtocol(id)
+		return err == nil && v.Framed()
+	}, func(s network.Stream) {
+		defer s.Close()
+
+		_, _, method, _ := system.ParseProtocol(s.Protocol())
+		errs <- system.ServeFramed(ctx, s, method, echo.ProcessMessage)
+	})
+
+	open := func(t *testing.T, method string) network.Stream {
+		s, err := client.NewStream(ctx, server.ID(), system.FramedProtocolIDs(echo.ID(), method)...)
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+		assert.Equal(t, system.V0_2_0_Framed.ProtocolID(echo.ID(), method), s.Protocol())
+		return s
+	}
+
+	t.Run("many messages per stream", func(t *testing.T) {
+		s := open(t, "echo")
+		w, r := msgio.NewVarintWriter(s), msgio.NewVarintReader(s)
+
+		for _, msg := range []string{"hello", "", "world"} {
+			require.NoError(t, w.WriteMsg([]byte(msg)))
+
+			got, err := r.ReadMsg()
+			require.NoError(t, err)
+			assert.Equal(t, msg, string(got))
+		}
+
+		require.NoError(t, s.CloseWrite())
+		require.NoError(t, <-errs)
+	})
+
+	t.Run("unknown method", func(t *testing.T) {
+		s := open(t, "missing")
+		require.NoError(t, msgio.NewVarintWriter(s).WriteMsg([]byte("hello")))
+
+		_, err := msgio.NewVarintReader(s).ReadMsg()
+		assert.Error(t, err, "stream should be reset")
+		assert.ErrorIs(t, <-errs, system.ErrUnknownMethod)
+	})
+}
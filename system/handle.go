@@ -0,0 +1,201 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/tetratelabs/wazero/api"
+	"go.uber.org/multierr"
+)
+
+// handleTable maps the integer handles held by a guest instance to host
+// objects, such as open IPFS files.  Each instance has a table of its own,
+// so that it cannot use the handles of another, and the table is closed
+// when the instance is released.  Handles are positive, and are never
+// reused within a table.
+type handleTable struct {
+	mu    sync.Mutex
+	next  int32
+	items map[int32]any
+}
+
+// Add v to the table and return its handle.
+func (t *handleTable) Add(v any) int32 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.items == nil {
+		t.items = make(map[int32]any)
+	}
+
+	t.next++
+	t.items[t.next] = v
+	return t.next
+}
+
+// Get the object for handle h.
+func (t *handleTable) Get(h int32) (v any, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	v, ok = t.items[h]
+	return
+}
+
+// Remove handle h from the table and return its object.
+func (t *handleTable) Remove(h int32) (v any, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if v, ok = t.items[h]; ok {
+		delete(t.items, h)
+	}
+	return
+}
+
+// handleAs returns the object for handle h, if it is of type T.
+func handleAs[T any](t *handleTable, h int32) (v T, ok bool) {
+	x, _ := t.Get(h)
+	v, ok = x.(T)
+	return
+}
+
+// handles returns the handle table of the guest instance mod.
+func (h *hostModule) handles(mod api.Module) *handleTable {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.tables == nil {
+		h.tables = make(map[api.Module]*handleTable)
+	}
+
+	t, ok := h.tables[mod]
+	if !ok {
+		t = new(handleTable)
+		h.tables[mod] = t
+	}
+	return t
+}
+
+// release closes the handles held by the guest instance mod.
+func (h *hostModule) release(mod api.Module) error {
+	h.mu.Lock()
+	t, ok := h.tables[mod]
+	delete(h.tables, mod)
+	h.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return t.Close()
+}
+
+// Close every object in the table that implements io.Closer, and empty
+// the table.
+func (t *handleTable) Close() error {
+	t.mu.Lock()
+	items := t.items
+	t.items = nil
+	t.mu.Unlock()
+
+	var errs []error
+	for _, v := range items {
+		if c, ok := v.(io.Closer); ok {
+			errs = append(errs, c.Close())
+		}
+	}
+	return multierr.Combine(errs...)
+}
+
+// read(handle, buf, len i32) -> i32
+//
+// Reads up to len bytes from the handle into buf.  Returns the number of
+// bytes read, or 0 at end of stream.
+func (h *hostModule) read(ctx context.Context, mod api.Module, stack []uint64) {
+	r, ok := handleAs[io.Reader](h.handles(mod), api.DecodeI32(stack[0]))
+	if !ok {
+		stack[0] = errno(ErrnoBadHandle)
+		return
+	}
+
+	buf, ok := mod.Memory().Read(api.DecodeU32(stack[1]), api.DecodeU32(stack[2]))
+	if !ok {
+		stack[0] = errno(ErrnoFault)
+		return
+	}
+
+	n, err := r.Read(buf)
+	if err != nil && !errors.Is(err, io.EOF) && n == 0 {
+		stack[0] = errno(ErrnoIO)
+		return
+	}
+
+	stack[0] = api.EncodeI32(int32(n))
+}
+
+// write(handle, buf, len i32) -> i32
+//
+// Writes len bytes at buf to the handle.  Returns the number of bytes
+// written.
+func (h *hostModule) write(ctx context.Context, mod api.Module, stack []uint64) {
+	w, ok := handleAs[io.Writer](h.handles(mod), api.DecodeI32(stack[0]))
+	if !ok {
+		stack[0] = errno(ErrnoBadHandle)
+		return
+	}
+
+	buf, ok := mod.Memory().Read(api.DecodeU32(stack[1]), api.DecodeU32(stack[2]))
+	if !ok {
+		stack[0] = errno(ErrnoFault)
+		return
+	}
+
+	n, err := w.Write(buf)
+	if err != nil && n == 0 {
+		stack[0] = errno(ErrnoIO)
+		return
+	}
+
+	stack[0] = api.EncodeI32(int32(n))
+}
+
+// close(handle i32) -> i32
+//
+// Releases the handle.  Returns 0 on success.
+func (h *hostModule) close(ctx context.Context, mod api.Module, stack []uint64) {
+	v, ok := h.handles(mod).Remove(api.DecodeI32(stack[0]))
+	if !ok {
+		stack[0] = errno(ErrnoBadHandle)
+		return
+	}
+
+	if c, ok := v.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			stack[0] = errno(ErrnoIO)
+			return
+		}
+	}
+
+	stack[0] = 0
+}
+
+// closeWrite(handle i32) -> i32
+//
+// Closes the handle for writing, signaling EOF to the remote end, while
+// leaving it open for reading.  Returns 0 on success.
+func (h *hostModule) closeWrite(ctx context.Context, mod api.Module, stack []uint64) {
+	c, ok := handleAs[interface{ CloseWrite() error }](h.handles(mod), api.DecodeI32(stack[0]))
+	if !ok {
+		stack[0] = errno(ErrnoBadHandle)
+		return
+	}
+
+	if err := c.CloseWrite(); err != nil {
+		stack[0] = errno(ErrnoIO)
+		return
+	}
+
+	stack[0] = 0
+}
@@ -0,0 +1,228 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tetratelabs/wazero"
+)
+
+// Inspection describes what a module needs and provides, as reported by
+// Inspect.
+type Inspection struct {
+	// Command reports whether the module is a WASI command, which exports
+	// _start.
+	Command bool `json:"command"`
+
+	// Reactor reports whether the module is a WASI reactor, which exports
+	// _initialize but not _start.  A module that is neither has no entry
+	// point; like a reactor, it can only be served in async mode.
+	Reactor bool `json:"reactor"`
+
+	// Caps are the capabilities required by the imports of the module.
+	Caps Capability `json:"-"`
+
+	Imports  []Import  `json:"imports"`
+	Exports  []Method  `json:"exports"` // every exported function
+	Memory   []Memory  `json:"memory"`
+	Sections []Section `json:"sections"` // custom sections
+}
+
+// Import is a function imported by a module.
+type Import struct {
+	Module  string     `json:"module"`
+	Name    string     `json:"name"`
+	Params  []string   `json:"params"`  // value types, e.g. "i32"
+	Results []string   `json:"results"` // value types, e.g. "i32"
+	Cap     Capability `json:"-"`       // required by a "ww" import
+
+	// Unknown is set for "ww" imports of functions that the host module
+	// does not provide, which prevent the module from running.
+	Unknown bool `json:"unknown,omitempty"`
+}
+
+func (i Import) String() string {
+	return fmt.Sprintf("%s.%s(%s) -> (%s)", i.Module, i.Name,
+		strings.Join(i.Params, ", "),
+		strings.Join(i.Results, ", "))
+}
+
+// Memory describes the limits of a linear memory, in 64KiB pages.
+type Memory struct {
+	Min      uint64  `json:"min"`
+	Max      *uint64 `json:"max,omitempty"`      // nil if unbounded
+	Shared   bool    `json:"shared,omitempty"`   // threads proposal
+	Memory64 bool    `json:"memory64,omitempty"` // 64-bit addresses
+	Import   string  `json:"import,omitempty"`   // "module.name", if imported
+}
+
+func (m Memory) String() string {
+	limit := "unbounded"
+	if m.Max != nil {
+		limit = fmt.Sprintf("%d", *m.Max)
+	}
+
+	s := fmt.Sprintf("min %d pages, max %s", m.Min, limit)
+	if m.Shared {
+		s += ", shared"
+	}
+	if m.Memory64 {
+		s += ", 64-bit"
+	}
+	if m.Import != "" {
+		s += fmt.Sprintf(" (imported from %s)", m.Import)
+	}
+	return s
+}
+
+// Section is a custom section of a module.
+type Section struct {
+	Name string `json:"name"`
+	Size int    `json:"size"` // bytes, excluding the name
+
+	// Text holds the contents of metadata sections, whose names start with
+	// MetadataPrefix, if they are valid UTF-8.
+	Text string `json:"text,omitempty"`
+}
+
+// Inspect compiles bytecode with r, and describes its imports, exports,
+// memory and custom sections, without instantiating it.  Custom sections
+// are only reported if r is configured WithCustomSections(true).
+func Inspect(ctx context.Context, r wazero.Runtime, bytecode []byte) (*Inspection, error) {
+	cm, err := r.CompileModule(ctx, bytecode)
+	if err != nil {
+		return nil, err
+	}
+	defer cm.Close(ctx)
+
+	caps := make(map[string]Capability)
+	for _, fn := range (&hostModule{}).functions() {
+		caps[fn.Name] = fn.Cap
+	}
+
+	in := &Inspection{
+		Imports:  []Import{},
+		Exports:  []Method{},
+		Memory:   memories(bytecode),
+		Sections: sections(cm),
+	}
+
+	for _, def := range cm.ImportedFunctions() {
+		module, name, _ := def.Import()
+		imp := Import{
+			Module:  module,
+			Name:    name,
+			Params:  typeNames(def.ParamTypes()),
+			Results: typeNames(def.ResultTypes()),
+		}
+
+		if module == HostModuleName {
+			cap, ok := caps[name]
+			imp.Cap, imp.Unknown = cap, !ok
+			in.Caps |= cap
+		}
+
+		in.Imports = append(in.Imports, imp)
+	}
+
+	for export, def := range cm.ExportedFunctions() {
+		in.Exports = append(in.Exports, Method{
+			Name:    export,
+			Params:  typeNames(def.ParamTypes()),
+			Results: typeNames(def.ResultTypes()),
+		})
+	}
+	slices.SortFunc(in.Exports, func(a, b Method) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	exports := cm.ExportedFunctions()
+	_, in.Command = exports["_start"]
+	_, in.Reactor = exports["_initialize"]
+	in.Reactor = in.Reactor && !in.Command
+
+	// Wazero reports the limits of imported memories, but not those of
+	// memories defined by the module, unless they are exported.
+	for _, def := range cm.ImportedMemories() {
+		module, name, _ := def.Import()
+		m := Memory{Min: uint64(def.Min()), Import: module + "." + name}
+		if limit, ok := def.Max(); ok {
+			m.Max = new(uint64)
+			*m.Max = uint64(limit)
+		}
+		in.Memory = append(in.Memory, m)
+	}
+
+	return in, nil
+}
+
+// Flags of the limits of a memory.
+const (
+	memoryHasMax   = 0x01
+	memoryShared   = 0x02
+	memoryMemory64 = 0x04
+)
+
+// memories returns the limits of the memories defined by a valid module.
+func memories(bytecode []byte) []Memory {
+	ms := []Memory{}
+
+	for id, body := range moduleSections(bytecode) {
+		if id != 5 {
+			continue
+		}
+
+		count, n := uleb128(body)
+		body = body[n:]
+		for ; n != 0 && count > 0 && len(body) > 0; count-- {
+			flags := body[0]
+			body = body[1:]
+			if flags&^(memoryHasMax|memoryShared|memoryMemory64) != 0 {
+				break // unknown encoding
+			}
+
+			// The limits of 64-bit memories are 64-bit as well, which
+			// uleb128 decodes in full.
+			m := Memory{
+				Shared:   flags&memoryShared != 0,
+				Memory64: flags&memoryMemory64 != 0,
+			}
+			if m.Min, n = uleb128(body); n == 0 {
+				break
+			}
+			body = body[n:]
+
+			if flags&memoryHasMax != 0 {
+				var limit uint64
+				if limit, n = uleb128(body); n == 0 {
+					break
+				}
+				body = body[n:]
+				m.Max = &limit
+			}
+
+			ms = append(ms, m)
+		}
+	}
+
+	return ms
+}
+
+// sections returns the custom sections of cm, in order.
+func sections(cm wazero.CompiledModule) []Section {
+	ss := []Section{}
+
+	for _, section := range cm.CustomSections() {
+		name, data := section.Name(), section.Data()
+
+		s := Section{Name: name, Size: len(data)}
+		if strings.HasPrefix(name, MetadataPrefix) && utf8.Valid(data) {
+			s.Text = string(data)
+		}
+		ss = append(ss, s)
+	}
+
+	return ss
+}
@@ -0,0 +1,132 @@
+package system_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
+	"github.com/wetware/go/system"
+)
+
+// importWasm is a command that imports a "ww" function that does not exist,
+// along with a memory of 1 to 2 pages.
+var importWasm = []byte{
+	0x00, 0x61, 0x73, 0x6d, // WASM magic number
+	0x01, 0x00, 0x00, 0x00, // Version 1
+	0x01, 0x04, 0x01, 0x60, 0x00, 0x00, // Type section: () -> ()
+	0x02, 0x1a, 0x02, // Import section: 2 imports
+	0x02, 0x77, 0x77, 0x04, 0x6e, 0x6f, 0x70, 0x65, 0x00, 0x00, // "ww" "nope" function of type 0
+	0x03, 0x65, 0x6e, 0x76, 0x06, 0x6d, 0x65, 0x6d, 0x6f, 0x72, 0x79, // "env" "memory"
+	0x02, 0x01, 0x01, 0x02, // memory, min 1 page, max 2 pages
+	0x03, 0x02, 0x01, 0x00, // Function section: 1 function of type 0
+	0x07, 0x0a, 0x01, // Export section: 1 export
+	0x06, 0x5f, 0x73, 0x74, 0x61, 0x72, 0x74, 0x00, 0x01, // "_start" function 1
+	0x0a, 0x04, 0x01, 0x02, 0x00, 0x0b, // Code section: 1 empty body
+}
+
+// sharedMemoryWasm defines a shared memory of 1 to 2 pages, and nothing
+// else.
+var sharedMemoryWasm = []byte{
+	0x00, 0x61, 0x73, 0x6d, // WASM magic number
+	0x01, 0x00, 0x00, 0x00, // Version 1
+	0x05, 0x04, 0x01, 0x03, 0x01, 0x02, // Memory section: shared, min 1 page, max 2 pages
+}
+
+func TestInspect(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithCustomSections(true).
+		WithCoreFeatures(api.CoreFeaturesV2|experimental.CoreFeaturesThreads))
+	defer runtime.Close(ctx)
+
+	t.Run("NoEntryPoint", func(t *testing.T) {
+		in, err := system.Inspect(ctx, runtime, ipfsWasm)
+		require.NoError(t, err)
+
+		assert.False(t, in.Command)
+		assert.False(t, in.Reactor)
+		assert.Equal(t, system.CapIPFS, in.Caps)
+		require.Len(t, in.Imports, 3)
+		assert.Equal(t, system.Import{
+			Module:  "ww",
+			Name:    "ipfs_open",
+			Params:  []string{"i32", "i32"},
+			Results: []string{"i32"},
+			Cap:     system.CapIPFS,
+		}, in.Imports[0])
+		assert.Equal(t, "ww.ipfs_open(i32, i32) -> (i32)", in.Imports[0].String())
+
+		var names []string
+		for _, m := range in.Exports {
+			names = append(names, m.Name)
+		}
+		assert.Equal(t, []string{"add", "open", "read"}, names)
+
+		assert.Equal(t, []system.Memory{{Min: 1}}, in.Memory)
+		assert.Equal(t, "min 1 pages, max unbounded", in.Memory[0].String())
+		assert.Empty(t, in.Sections)
+	})
+
+	t.Run("Reactor", func(t *testing.T) {
+		in, err := system.Inspect(ctx, runtime, reactorWasm)
+		require.NoError(t, err)
+
+		assert.False(t, in.Command)
+		assert.True(t, in.Reactor)
+	})
+
+	t.Run("Command", func(t *testing.T) {
+		in, err := system.Inspect(ctx, runtime, exitCommandWasm)
+		require.NoError(t, err)
+
+		assert.True(t, in.Command)
+		assert.False(t, in.Reactor)
+		assert.Equal(t, system.Capability(0), in.Caps)
+		require.Len(t, in.Imports, 1)
+		assert.Equal(t, "wasi_snapshot_preview1", in.Imports[0].Module)
+	})
+
+	t.Run("Sections", func(t *testing.T) {
+		in, err := system.Inspect(ctx, runtime, metadataWasm)
+		require.NoError(t, err)
+
+		assert.Equal(t, []system.Section{
+			{Name: "ww.description", Size: 15, Text: "spins and grows"},
+			{Name: ".debug_info", Size: 2},
+		}, in.Sections)
+	})
+
+	t.Run("Imports", func(t *testing.T) {
+		in, err := system.Inspect(ctx, runtime, importWasm)
+		require.NoError(t, err)
+
+		assert.True(t, in.Command)
+		require.Len(t, in.Imports, 1)
+		assert.True(t, in.Imports[0].Unknown)
+
+		max := uint64(2)
+		assert.Equal(t, []system.Memory{{Min: 1, Max: &max, Import: "env.memory"}}, in.Memory)
+		assert.Equal(t, "min 1 pages, max 2 (imported from env.memory)", in.Memory[0].String())
+	})
+
+	t.Run("SharedMemory", func(t *testing.T) {
+		in, err := system.Inspect(ctx, runtime, sharedMemoryWasm)
+		require.NoError(t, err)
+
+		max := uint64(2)
+		assert.Equal(t, []system.Memory{{Min: 1, Max: &max, Shared: true}}, in.Memory)
+		assert.Equal(t, "min 1 pages, max 2, shared", in.Memory[0].String())
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := system.Inspect(ctx, runtime, []byte("not wasm"))
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,232 @@
+package system
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ipfs/boxo/files"
+	"github.com/ipfs/boxo/path"
+	iface "github.com/ipfs/kubo/core/coreiface"
+	"github.com/ipfs/kubo/core/coreiface/options"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// ipfsOpen(path, len i32) -> i32
+//
+// Resolves the UnixFS path and returns a readable handle.  Files are read
+// as a stream; directories read as a listing with one entry name per line,
+// and a trailing '/' on subdirectories.
+func (h *hostModule) ipfsOpen(ctx context.Context, mod api.Module, stack []uint64) {
+	p, ok := h.readPath(mod, stack[0], stack[1])
+	if !ok {
+		stack[0] = errno(ErrnoInval)
+		return
+	}
+
+	if h.Config.IPFS == nil {
+		stack[0] = errno(ErrnoIO)
+		return
+	}
+
+	// The path is resolved as part of the call, and abandoned with it, but
+	// the handle outlives the call, so it is bound to the lifetime of the
+	// process once opened.
+	hctx, cancel := context.WithCancel(h.ctx)
+	stop := context.AfterFunc(ctx, cancel)
+
+	r, code := h.open(hctx, p)
+	if !stop() && code == 0 {
+		r.Close()
+		code = ErrnoIO
+	}
+	if code != 0 {
+		cancel()
+		stack[0] = errno(code)
+		return
+	}
+
+	stack[0] = api.EncodeI32(h.handles(mod).Add(&ipfsHandle{
+		ReadCloser: r,
+		cancel:     cancel}))
+}
+
+// open the file or directory listing at p, for as long as ctx.
+func (h *hostModule) open(ctx context.Context, p path.Path) (io.ReadCloser, Errno) {
+	node, err := h.Config.IPFS.Unixfs().Get(ctx, p)
+	if err != nil {
+		return nil, ErrnoIO
+	}
+
+	switch n := node.(type) {
+	case files.File:
+		return n, 0
+
+	case files.Directory:
+		n.Close()
+
+		entries, err := h.Config.IPFS.Unixfs().Ls(ctx, p,
+			options.Unixfs.ResolveChildren(true))
+		if err != nil {
+			return nil, ErrnoIO
+		}
+		return io.NopCloser(&dirReader{entries: entries}), 0
+
+	default:
+		node.Close()
+		return nil, ErrnoInval
+	}
+}
+
+// ipfsAdd(buf, len, out, out_cap i32) -> i32
+//
+// Adds len bytes at buf to IPFS as a UnixFS file, and writes the resulting
+// "/ipfs/<cid>" path to out.  Returns the length of the path.
+func (h *hostModule) ipfsAdd(ctx context.Context, mod api.Module, stack []uint64) {
+	buf, ok := mod.Memory().Read(api.DecodeU32(stack[0]), api.DecodeU32(stack[1]))
+	if !ok {
+		stack[0] = errno(ErrnoFault)
+		return
+	}
+
+	if h.Config.IPFS == nil {
+		stack[0] = errno(ErrnoIO)
+		return
+	}
+
+	// Copy out of guest memory, which may be reallocated during the call.
+	node := files.NewBytesFile(bytes.Clone(buf))
+	p, err := h.Config.IPFS.Unixfs().Add(ctx, node)
+	if err != nil {
+		stack[0] = errno(ErrnoIO)
+		return
+	}
+
+	stack[0] = writeString(mod, p.String(), stack[2], stack[3])
+}
+
+// ipfsAddDir(entries, len, out, out_cap i32) -> i32
+//
+// Creates a UnixFS directory from a listing of "<name>\t<path>\n" lines,
+// where each path refers to content already in IPFS, and writes the
+// resulting "/ipfs/<cid>" path to out.  Returns the length of the path.
+func (h *hostModule) ipfsAddDir(ctx context.Context, mod api.Module, stack []uint64) {
+	buf, ok := mod.Memory().Read(api.DecodeU32(stack[0]), api.DecodeU32(stack[1]))
+	if !ok {
+		stack[0] = errno(ErrnoFault)
+		return
+	}
+
+	links, err := parseDirEntries(buf)
+	if err != nil {
+		stack[0] = errno(ErrnoInval)
+		return
+	}
+
+	if h.Config.IPFS == nil {
+		stack[0] = errno(ErrnoIO)
+		return
+	}
+
+	dir, err := h.Config.IPFS.Unixfs().Add(ctx, files.NewMapDirectory(nil))
+	if err != nil {
+		stack[0] = errno(ErrnoIO)
+		return
+	}
+
+	// Link existing content into the directory, rather than fetching and
+	// re-adding it.
+	for _, link := range links {
+		if dir, err = h.Config.IPFS.Object().AddLink(ctx, dir, link.Name, link.Path,
+			options.Object.Create(true)); err != nil {
+			stack[0] = errno(ErrnoIO)
+			return
+		}
+	}
+
+	stack[0] = writeString(mod, dir.String(), stack[2], stack[3])
+}
+
+func (h *hostModule) readPath(mod api.Module, ptr, size uint64) (path.Path, bool) {
+	buf, ok := mod.Memory().Read(api.DecodeU32(ptr), api.DecodeU32(size))
+	if !ok {
+		return nil, false
+	}
+
+	p, err := path.NewPath(string(buf))
+	return p, err == nil
+}
+
+type dirLink struct {
+	Name string
+	Path path.Path
+}
+
+func parseDirEntries(buf []byte) ([]dirLink, error) {
+	var links []dirLink
+
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		name, s, ok := strings.Cut(line, "\t")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid directory entry: %q", line)
+		}
+
+		p, err := path.NewPath(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid directory entry %q: %w", name, err)
+		}
+
+		links = append(links, dirLink{Name: name, Path: p})
+	}
+
+	return links, scanner.Err()
+}
+
+// ipfsHandle is a file or directory listing opened by ipfsOpen.  Closing
+// it releases the context it was opened with, which stops a listing that is
+// still in progress.
+type ipfsHandle struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (h *ipfsHandle) Close() error {
+	defer h.cancel()
+	return h.ReadCloser.Close()
+}
+
+// dirReader streams a directory listing, one entry per line.
+type dirReader struct {
+	entries <-chan iface.DirEntry
+	buf     bytes.Buffer
+}
+
+func (d *dirReader) Read(p []byte) (int, error) {
+	for d.buf.Len() == 0 {
+		entry, ok := <-d.entries
+		if !ok {
+			return 0, io.EOF
+		}
+
+		if entry.Err != nil {
+			return 0, entry.Err
+		}
+
+		d.buf.WriteString(entry.Name)
+		if entry.Type == iface.TDirectory {
+			d.buf.WriteByte('/')
+		}
+		d.buf.WriteByte('\n')
+	}
+
+	return d.buf.Read(p)
+}
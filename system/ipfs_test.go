@@ -0,0 +1,336 @@
+package system_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/files"
+	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/go-cid"
+	iface "github.com/ipfs/kubo/core/coreiface"
+	"github.com/ipfs/kubo/core/coreiface/options"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/wetware/go/system"
+)
+
+// ipfsWasm imports ww.ipfs_open, ww.read and ww.ipfs_add, and re-exports
+// them unchanged as "open", "read" and "add", along with its memory, so
+// that tests can drive the host functions directly.
+var ipfsWasm = []byte{
+	0x00, 0x61, 0x73, 0x6d, // WASM magic number
+	0x01, 0x00, 0x00, 0x00, // Version 1
+	0x01, 0x16, 0x03, // Type section: 3 types
+	0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7f, // (i32, i32) -> i32
+	0x60, 0x03, 0x7f, 0x7f, 0x7f, 0x01, 0x7f, // (i32, i32, i32) -> i32
+	0x60, 0x04, 0x7f, 0x7f, 0x7f, 0x7f, 0x01, 0x7f, // (i32, i32, i32, i32) -> i32
+	0x02, 0x28, 0x03, // Import section: 3 imports
+	0x02, 0x77, 0x77, 0x09, 0x69, 0x70, 0x66, 0x73, // "ww" "ipfs_open"
+	0x5f, 0x6f, 0x70, 0x65, 0x6e, 0x00, 0x00, // function of type 0
+	0x02, 0x77, 0x77, 0x04, 0x72, 0x65, 0x61, 0x64, 0x00, 0x01, // "ww" "read" function of type 1
+	0x02, 0x77, 0x77, 0x08, 0x69, 0x70, 0x66, 0x73, // "ww" "ipfs_add"
+	0x5f, 0x61, 0x64, 0x64, 0x00, 0x02, // function of type 2
+	0x03, 0x04, 0x03, 0x00, 0x01, 0x02, // Function section: 3 functions of types 0, 1, 2
+	0x05, 0x03, 0x01, 0x00, 0x01, // Memory section: 1 memory, min 1 page
+	0x07, 0x1e, 0x04, // Export section: 4 exports
+	0x06, 0x6d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x02, 0x00, // "memory" memory 0
+	0x04, 0x6f, 0x70, 0x65, 0x6e, 0x00, 0x03, // "open" function 3
+	0x04, 0x72, 0x65, 0x61, 0x64, 0x00, 0x04, // "read" function 4
+	0x03, 0x61, 0x64, 0x64, 0x00, 0x05, // "add" function 5
+	0x0a, 0x22, 0x03, // Code section: 3 bodies
+	0x08, 0x00, 0x20, 0x00, 0x20, 0x01, 0x10, 0x00, 0x0b, // ipfs_open(p0, p1)
+	0x0a, 0x00, 0x20, 0x00, 0x20, 0x01, 0x20, 0x02, 0x10, 0x01, 0x0b, // read(p0, p1, p2)
+	0x0c, 0x00, 0x20, 0x00, 0x20, 0x01, 0x20, 0x02, 0x20, 0x03, 0x10, 0x02, 0x0b, // ipfs_add(p0, p1, p2, p3)
+}
+
+// fakeIPFS serves UnixFS content from memory.  Unimplemented methods panic.
+type fakeIPFS struct {
+	iface.CoreAPI
+	fs *fakeUnixfs
+}
+
+func (f fakeIPFS) Unixfs() iface.UnixfsAPI { return f.fs }
+
+type fakeUnixfs struct {
+	iface.UnixfsAPI
+	files map[string][]byte
+	dirs  map[string][]iface.DirEntry
+}
+
+func (f *fakeUnixfs) Add(ctx context.Context, node files.Node, opts ...options.UnixfsAddOption) (path.ImmutablePath, error) {
+	if dir, ok := node.(files.Directory); ok {
+		return f.addDir(dir)
+	}
+
+	b, err := io.ReadAll(node.(files.File))
+	if err != nil {
+		return path.ImmutablePath{}, err
+	}
+
+	hash, err := multihash.Sum(b, multihash.SHA2_256, -1)
+	if err != nil {
+		return path.ImmutablePath{}, err
+	}
+
+	p := path.FromCid(cid.NewCidV1(cid.Raw, hash))
+	f.files[p.String()] = b
+	return p, nil
+}
+
+// addDir adds the files in dir, which is not nested.
+func (f *fakeUnixfs) addDir(dir files.Directory) (path.ImmutablePath, error) {
+	var all []byte
+	contents := map[string][]byte{}
+	for it := dir.Entries(); it.Next(); {
+		b, err := io.ReadAll(it.Node().(files.File))
+		if err != nil {
+			return path.ImmutablePath{}, err
+		}
+		contents[it.Name()] = b
+		all = append(append(all, it.Name()...), b...)
+	}
+
+	hash, err := multihash.Sum(all, multihash.SHA2_256, -1)
+	if err != nil {
+		return path.ImmutablePath{}, err
+	}
+
+	p := path.FromCid(cid.NewCidV1(cid.DagProtobuf, hash))
+	for name, b := range contents {
+		f.files[p.String()+"/"+name] = b
+		f.dirs[p.String()] = append(f.dirs[p.String()], iface.DirEntry{Name: name, Type: iface.TFile})
+	}
+	return p, nil
+}
+
+// slowPath cannot be resolved, and blocks until the context is done, like
+// content that no peer provides.
+const slowPath = "/ipfs/bafkqaaa/slow"
+
+func (f *fakeUnixfs) Get(ctx context.Context, p path.Path) (files.Node, error) {
+	if p.String() == slowPath {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	if b, ok := f.files[p.String()]; ok {
+		return files.NewBytesFile(b), nil
+	}
+
+	if _, ok := f.dirs[p.String()]; ok {
+		return files.NewMapDirectory(nil), nil
+	}
+
+	return nil, io.ErrUnexpectedEOF
+}
+
+func (f *fakeUnixfs) Ls(ctx context.Context, p path.Path, opts ...options.UnixfsLsOption) (<-chan iface.DirEntry, error) {
+	ch := make(chan iface.DirEntry, len(f.dirs[p.String()]))
+	for _, entry := range f.dirs[p.String()] {
+		ch <- entry
+	}
+	close(ch)
+	return ch, nil
+}
+
+// newFakeUnixfs returns a fake holding /ipfs/bafkqaaa, a directory with a
+// file and a subdirectory.
+func newFakeUnixfs() *fakeUnixfs {
+	return &fakeUnixfs{
+		files: map[string][]byte{
+			"/ipfs/bafkqaaa/hello.txt": []byte("hello, world"),
+		},
+		dirs: map[string][]iface.DirEntry{
+			"/ipfs/bafkqaaa": {
+				{Name: "hello.txt", Type: iface.TFile},
+				{Name: "sub", Type: iface.TDirectory},
+			},
+		},
+	}
+}
+
+// call an exported function and decode its i32 result.
+func call(t *testing.T, mod api.Module, name string, params ...uint64) int32 {
+	t.Helper()
+
+	res, err := mod.ExportedFunction(name).Call(context.Background(), params...)
+	require.NoError(t, err)
+	return api.DecodeI32(res[0])
+}
+
+// readAll drains handle h through the guest, n bytes at a time.
+func readAll(t *testing.T, mod api.Module, h int32, n uint32) string {
+	t.Helper()
+
+	const buf = 1024
+	var out bytes.Buffer
+	for {
+		got := call(t, mod, "read", api.EncodeI32(h), buf, uint64(n))
+		require.GreaterOrEqual(t, got, int32(0), "read failed")
+		if got == 0 {
+			return out.String()
+		}
+
+		b, ok := mod.Memory().Read(buf, uint32(got))
+		require.True(t, ok)
+		out.Write(b)
+	}
+}
+
+func TestHostModule_IPFS(t *testing.T) {
+	t.Parallel()
+
+	t.Run("read file", func(t *testing.T) {
+		proc, err := newTestProc(t, system.ProcConfig{
+			Async: true,
+			Caps:  system.CapIPFS,
+			IPFS:  fakeIPFS{fs: newFakeUnixfs()},
+		}, ipfsWasm)
+		require.NoError(t, err)
+		mod := proc.Module
+
+		p := "/ipfs/bafkqaaa/hello.txt"
+		require.True(t, mod.Memory().WriteString(0, p))
+
+		h := call(t, mod, "open", 0, uint64(len(p)))
+		require.Positive(t, h)
+
+		// Small reads exercise streaming across several calls.
+		assert.Equal(t, "hello, world", readAll(t, mod, h, 5))
+	})
+
+	t.Run("list directory", func(t *testing.T) {
+		proc, err := newTestProc(t, system.ProcConfig{
+			Async: true,
+			Caps:  system.CapIPFS,
+			IPFS:  fakeIPFS{fs: newFakeUnixfs()},
+		}, ipfsWasm)
+		require.NoError(t, err)
+		mod := proc.Module
+
+		p := "/ipfs/bafkqaaa"
+		require.True(t, mod.Memory().WriteString(0, p))
+
+		h := call(t, mod, "open", 0, uint64(len(p)))
+		require.Positive(t, h)
+		assert.Equal(t, "hello.txt\nsub/\n", readAll(t, mod, h, 64))
+	})
+
+	t.Run("open is abandoned with the call", func(t *testing.T) {
+		proc, err := newTestProc(t, system.ProcConfig{
+			Async: true,
+			Caps:  system.CapIPFS,
+			IPFS:  fakeIPFS{fs: newFakeUnixfs()},
+		}, ipfsWasm)
+		require.NoError(t, err)
+		mod := proc.Module
+
+		require.True(t, mod.Memory().WriteString(0, slowPath))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		res, err := mod.ExportedFunction("open").Call(ctx, 0, uint64(len(slowPath)))
+		require.NoError(t, err)
+		assert.Equal(t, -int32(system.ErrnoIO), api.DecodeI32(res[0]))
+	})
+
+	t.Run("add", func(t *testing.T) {
+		fs := newFakeUnixfs()
+		proc, err := newTestProc(t, system.ProcConfig{
+			Async: true,
+			Caps:  system.CapIPFS,
+			IPFS:  fakeIPFS{fs: fs},
+		}, ipfsWasm)
+		require.NoError(t, err)
+		mod := proc.Module
+
+		require.True(t, mod.Memory().WriteString(0, "new content"))
+
+		n := call(t, mod, "add", 0, 11, 128, 256)
+		require.Positive(t, n)
+
+		p, ok := mod.Memory().Read(128, uint32(n))
+		require.True(t, ok)
+		assert.Equal(t, []byte("new content"), fs.files[string(p)])
+
+		// The returned path can be opened again.
+		h := call(t, mod, "open", 128, uint64(n))
+		require.Positive(t, h)
+		assert.Equal(t, "new content", readAll(t, mod, h, 64))
+	})
+
+	t.Run("add with small output buffer", func(t *testing.T) {
+		proc, err := newTestProc(t, system.ProcConfig{
+			Async: true,
+			Caps:  system.CapIPFS,
+			IPFS:  fakeIPFS{fs: newFakeUnixfs()},
+		}, ipfsWasm)
+		require.NoError(t, err)
+
+		require.True(t, proc.Module.Memory().WriteString(0, "new content"))
+		assert.Equal(t, -int32(system.ErrnoRange), call(t, proc.Module, "add", 0, 11, 128, 8))
+	})
+
+	t.Run("bad handle", func(t *testing.T) {
+		proc, err := newTestProc(t, system.ProcConfig{
+			Async: true,
+			Caps:  system.CapIPFS,
+			IPFS:  fakeIPFS{fs: newFakeUnixfs()},
+		}, ipfsWasm)
+		require.NoError(t, err)
+
+		assert.Equal(t, -int32(system.ErrnoBadHandle), call(t, proc.Module, "read", 42, 0, 16))
+	})
+
+	t.Run("not granted", func(t *testing.T) {
+		proc, err := newTestProc(t, system.ProcConfig{
+			Async: true,
+			Caps:  system.CapConsole,
+			IPFS:  fakeIPFS{fs: newFakeUnixfs()},
+		}, ipfsWasm)
+		require.Error(t, err)
+		assert.Nil(t, proc)
+		assert.Contains(t, err.Error(), `"ipfs"`)
+	})
+}
+
+func TestHostModule_HandlesPerInstance(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	proc, err := newTestProc(t, system.ProcConfig{
+		Async: true,
+		Caps:  system.CapIPFS,
+		IPFS:  fakeIPFS{fs: newFakeUnixfs()},
+		Pool:  system.PoolConfig{Size: 2},
+	}, ipfsWasm)
+	require.NoError(t, err)
+
+	a, err := proc.Pool.Acquire(ctx)
+	require.NoError(t, err)
+	b, err := proc.Pool.Acquire(ctx)
+	require.NoError(t, err)
+	defer proc.Pool.Release(ctx, b)
+
+	p := "/ipfs/bafkqaaa/hello.txt"
+	require.True(t, a.Module.Memory().WriteString(0, p))
+	h := call(t, a.Module, "open", 0, uint64(len(p)))
+	require.Positive(t, h)
+
+	// Another instance cannot use the handle...
+	assert.Equal(t, -int32(system.ErrnoBadHandle), call(t, b.Module, "read", api.EncodeI32(h), 1024, 8))
+
+	// ... and it does not outlive the stream served by its instance.
+	proc.Pool.Release(ctx, a)
+	a, err = proc.Pool.Acquire(ctx)
+	require.NoError(t, err)
+	defer proc.Pool.Release(ctx, a)
+	assert.Equal(t, -int32(system.ErrnoBadHandle), call(t, a.Module, "read", api.EncodeI32(h), 1024, 8))
+}
@@ -0,0 +1,206 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/experimental"
+)
+
+// Names of the limits reported by LimitError.
+const (
+	LimitMemory      = "memory"
+	LimitCallTimeout = "call-timeout"
+	LimitRunTime     = "run-time"
+)
+
+// ErrCodeLimit is sent to the remote peer when a stream is reset because
+// the process exceeded one of its Limits.
+const ErrCodeLimit network.StreamErrorCode = 0x1002
+
+// Limits bounds the resources consumed by a process.  The zero value
+// imposes no limits.
+//
+// Call timeouts and the run-time budget are enforced by canceling the
+// context passed to the guest, which requires a runtime created with the
+// config returned by Limits.RuntimeConfig.
+//
+// The run-time budget is measured in wall-clock time, since wazero does not
+// account for CPU time.  Time that the guest spends blocked in host I/O, i.e.
+// reading or writing its stream or calling a "ww" host function, is not
+// charged, so that an idle caller cannot spend the budget of the process.
+type Limits struct {
+	MaxMemoryPages uint32        // max linear memory per instance, in 64KiB pages
+	CallTimeout    time.Duration // max wall time of a single call
+	RunTime        time.Duration // max total time spent running guest code, across all instances
+}
+
+// LimitError is returned when a process exceeds one of its Limits.  Use
+// errors.As to tell it apart from a guest crash.
+type LimitError struct {
+	Limit string // LimitMemory, LimitCallTimeout or LimitRunTime
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s limit exceeded", e.Limit)
+}
+
+// RuntimeConfig returns a copy of config that allows the limits to be
+// enforced.
+//
+// The memory cap is not set with wazero.RuntimeConfig.WithMemoryLimitPages:
+// a guest that fails to grow past that limit merely traps, and the host
+// cannot tell why.  Limits are reported to callers as a LimitError, which
+// requires the allocator installed by withMemoryLimit to see the failed
+// allocation.  It is the only use of wazero's experimental API.
+func (l Limits) RuntimeConfig(config wazero.RuntimeConfig) wazero.RuntimeConfig {
+	if l.CallTimeout > 0 || l.RunTime > 0 {
+		config = config.WithCloseOnContextDone(true)
+	}
+	return config
+}
+
+// withMemoryLimit returns a context that caps the linear memory of modules
+// instantiated with it, along with the allocator that enforces the cap.
+// The allocator is nil if memory is unlimited.
+func (l Limits) withMemoryLimit(ctx context.Context) (context.Context, *memoryLimit) {
+	if l.MaxMemoryPages == 0 {
+		return ctx, nil
+	}
+
+	mem := &memoryLimit{max: uint64(l.MaxMemoryPages) * 65536}
+	return experimental.WithMemoryAllocator(ctx, mem), mem
+}
+
+// withCallLimit returns a context for a single call, which charges the
+// time spent running guest code to used, the run time of the process.  It
+// fails if the run-time budget is spent.  The cancel function MUST be called
+// when the call returns.
+func (l Limits) withCallLimit(ctx context.Context, used *atomic.Int64) (context.Context, context.CancelFunc, error) {
+	if l.RunTime > 0 && l.RunTime <= time.Duration(used.Load()) {
+		return nil, nil, &LimitError{Limit: LimitRunTime}
+	}
+
+	var cancelTimeout context.CancelFunc = func() {}
+	if l.CallTimeout > 0 {
+		ctx, cancelTimeout = context.WithTimeoutCause(ctx, l.CallTimeout,
+			&LimitError{Limit: LimitCallTimeout})
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	m := &meter{used: used, budget: l.RunTime, cancel: cancel, paused: 1}
+	m.resume()
+
+	return withMeter(ctx, m), func() {
+		m.pause()
+		cancel(context.Canceled)
+		cancelTimeout()
+	}, nil
+}
+
+// meter charges the time spent running guest code during a call, and
+// cancels the call once the run-time budget is spent.  It is paused while
+// the guest is blocked in host I/O.  Since a guest runs on a single
+// goroutine, a meter is not safe for concurrent use, and its methods are
+// no-ops on a nil meter.
+type meter struct {
+	used   *atomic.Int64 // run time of the process, in nanoseconds
+	budget time.Duration // zero if unlimited
+	cancel context.CancelCauseFunc
+
+	paused int // nesting depth; the meter starts out paused
+	since  time.Time
+	timer  *time.Timer
+}
+
+// pause charges the time since the guest last started running.
+func (m *meter) pause() {
+	if m == nil {
+		return
+	}
+
+	if m.paused++; m.paused == 1 {
+		m.used.Add(int64(time.Since(m.since)))
+		if m.timer != nil {
+			m.timer.Stop()
+		}
+	}
+}
+
+// resume charging time, and cancel the call when the budget runs out.
+func (m *meter) resume() {
+	if m == nil {
+		return
+	}
+
+	if m.paused--; m.paused > 0 {
+		return
+	}
+
+	m.since = time.Now()
+	if m.budget > 0 {
+		remaining := m.budget - time.Duration(m.used.Load())
+		m.timer = time.AfterFunc(max(remaining, 0), func() {
+			m.cancel(&LimitError{Limit: LimitRunTime})
+		})
+	}
+}
+
+type meterKey struct{}
+
+func withMeter(ctx context.Context, m *meter) context.Context {
+	return context.WithValue(ctx, meterKey{}, m)
+}
+
+// meterFromContext returns the meter of the call, or nil.
+func meterFromContext(ctx context.Context) *meter {
+	m, _ := ctx.Value(meterKey{}).(*meter)
+	return m
+}
+
+// memoryLimit is an experimental.MemoryAllocator that refuses to grow linear
+// memory beyond max bytes, and records that it did so.
+type memoryLimit struct {
+	max      uint64
+	exceeded atomic.Bool
+}
+
+func (m *memoryLimit) Allocate(capacity, _ uint64) experimental.LinearMemory {
+	return &limitedMemory{limit: m, buf: make([]byte, 0, min(capacity, m.max))}
+}
+
+type limitedMemory struct {
+	limit     *memoryLimit
+	buf       []byte
+	allocated bool
+}
+
+func (m *limitedMemory) Reallocate(size uint64) []byte {
+	if size > m.limit.max {
+		m.limit.exceeded.Store(true)
+
+		// The runtime cannot recover from a failure to allocate the initial
+		// memory, so satisfy it and let ProcConfig.New reject the instance.
+		if m.allocated {
+			return nil
+		}
+	}
+	m.allocated = true
+
+	if size > uint64(cap(m.buf)) {
+		buf := make([]byte, size)
+		copy(buf, m.buf)
+		m.buf = buf
+	}
+
+	m.buf = m.buf[:size]
+	return m.buf
+}
+
+func (m *limitedMemory) Free() {
+	m.buf = nil
+}
@@ -0,0 +1,283 @@
+package system_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wetware/go/system"
+	"github.com/wetware/go/system/mocks"
+	"go.uber.org/mock/gomock"
+)
+
+// limitsWasm exports two functions that misbehave:
+//   - spin loops forever
+//   - grow requests 10 more pages of memory, and traps if that fails
+var limitsWasm = []byte{
+	0x00, 0x61, 0x73, 0x6d, // WASM magic number
+	0x01, 0x00, 0x00, 0x00, // Version 1
+	0x01, 0x04, 0x01, 0x60, 0x00, 0x00, // Type section: () -> ()
+	0x03, 0x03, 0x02, 0x00, 0x00, // Function section: 2 functions of type 0
+	0x05, 0x03, 0x01, 0x00, 0x01, // Memory section: 1 memory, min 1 page
+	0x07, 0x0f, 0x02, // Export section: 2 exports
+	0x04, 0x73, 0x70, 0x69, 0x6e, 0x00, 0x00, // "spin" function 0
+	0x04, 0x67, 0x72, 0x6f, 0x77, 0x00, 0x01, // "grow" function 1
+	0x0a, 0x17, 0x02, // Code section: 2 bodies
+	0x07, 0x00, 0x03, 0x40, 0x0c, 0x00, 0x0b, 0x0b, // spin: loop { br 0 }
+	0x0d, 0x00, 0x41, 0x0a, 0x40, 0x00, 0x41, 0x7f, 0x46, // grow: memory.grow(10) == -1
+	0x04, 0x40, 0x00, 0x0b, 0x0b, // if { unreachable }
+}
+
+func limitedStream(ctrl *gomock.Controller) *mocks.MockStreamInterface {
+	s := mocks.NewMockStreamInterface(ctrl)
+	s.EXPECT().SetDeadline(gomock.Any()).Return(nil).AnyTimes()
+	s.EXPECT().ResetWithError(system.ErrCodeLimit).Return(nil)
+	return s
+}
+
+func TestLimits_CallTimeout(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	proc, err := newTestProc(t, system.ProcConfig{Async: true, Limits: system.Limits{CallTimeout: 50 * time.Millisecond}}, limitsWasm)
+	require.NoError(t, err)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	err = proc.ProcessMessage(ctx, limitedStream(ctrl), "spin")
+	var limitErr *system.LimitError
+	require.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, system.LimitCallTimeout, limitErr.Limit)
+}
+
+func TestLimits_RunTime(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	proc, err := newTestProc(t, system.ProcConfig{Async: true, Limits: system.Limits{RunTime: 50 * time.Millisecond}}, limitsWasm)
+	require.NoError(t, err)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// The first call spends the budget...
+	err = proc.ProcessMessage(ctx, limitedStream(ctrl), "spin")
+	var limitErr *system.LimitError
+	require.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, system.LimitRunTime, limitErr.Limit)
+
+	// ... so the next one is rejected without running.
+	start := time.Now()
+	err = proc.ProcessMessage(ctx, limitedStream(ctrl), "spin")
+	require.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, system.LimitRunTime, limitErr.Limit)
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestLimits_RunTime_BlockedOnStream(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	proc, err := newTestProc(t, system.ProcConfig{
+		Async:  true,
+		Limits: system.Limits{RunTime: 50 * time.Millisecond},
+	}, loadEchoWasm(t))
+	require.NoError(t, err)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// A caller that sends nothing for longer than the budget does not
+	// spend it, since the guest is blocked reading the stream.
+	wait := make(chan struct{})
+	time.AfterFunc(200*time.Millisecond, func() { close(wait) })
+
+	var out bytes.Buffer
+	err = proc.ProcessMessage(ctx, echoStream(ctrl, "", wait, &out), "echo")
+	require.NoError(t, err)
+
+	err = proc.ProcessMessage(ctx, echoStream(ctrl, "hello\n", closed(), &out), "echo")
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n", out.String())
+}
+
+func closed() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+
+func TestLimits_SyncMode(t *testing.T) {
+	t.Parallel()
+
+	// _start blocks reading stdin, which is never written to.
+	stdin, _ := io.Pipe()
+
+	_, err := newTestProc(t, system.ProcConfig{
+		Stdin:  stdin,
+		Limits: system.Limits{CallTimeout: 50 * time.Millisecond},
+	}, loadEchoWasm(t))
+
+	var limitErr *system.LimitError
+	require.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, system.LimitCallTimeout, limitErr.Limit)
+}
+
+func TestLimits_MaxMemoryPages(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("grow beyond limit", func(t *testing.T) {
+		proc, err := newTestProc(t, system.ProcConfig{Async: true, Limits: system.Limits{MaxMemoryPages: 4}}, limitsWasm)
+		require.NoError(t, err)
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		err = proc.ProcessMessage(ctx, limitedStream(ctrl), "grow")
+		var limitErr *system.LimitError
+		require.ErrorAs(t, err, &limitErr)
+		assert.Equal(t, system.LimitMemory, limitErr.Limit)
+	})
+
+	t.Run("grow within limit", func(t *testing.T) {
+		proc, err := newTestProc(t, system.ProcConfig{Async: true, Limits: system.Limits{MaxMemoryPages: 16}}, limitsWasm)
+		require.NoError(t, err)
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		err = proc.ProcessMessage(ctx, mocks.NewMockStreamInterface(ctrl), "grow")
+		require.NoError(t, err)
+		assert.Equal(t, uint32(11*65536), proc.Module.Memory().Size())
+	})
+}
+
+func TestLimits_GuestCrashIsNotALimit(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	// Declare a maximum of 2 pages in the module itself, so that grow traps
+	// through no fault of the limits.
+	bytecode := bytes.Replace(limitsWasm,
+		[]byte{0x05, 0x03, 0x01, 0x00, 0x01},
+		[]byte{0x05, 0x04, 0x01, 0x01, 0x01, 0x02}, 1)
+
+	proc, err := newTestProc(t, system.ProcConfig{Async: true}, bytecode)
+	require.NoError(t, err)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	s := mocks.NewMockStreamInterface(ctrl)
+	s.EXPECT().ResetWithError(system.ErrCodeTrap).Return(nil)
+
+	err = proc.ProcessMessage(ctx, s, "grow")
+	require.Error(t, err)
+
+	var limitErr *system.LimitError
+	assert.False(t, errors.As(err, &limitErr), "trap should not be reported as a limit")
+	assert.True(t, proc.Module.IsClosed(), "instance should be discarded after a trap")
+}
+
+// handledGrowWasm exports "try", which grows memory by 10 pages and
+// ignores a failure, and "trap", which traps.
+var handledGrowWasm = []byte{
+	0x00, 0x61, 0x73, 0x6d, // WASM magic number
+	0x01, 0x00, 0x00, 0x00, // Version 1
+	0x01, 0x04, 0x01, 0x60, 0x00, 0x00, // Type section: () -> ()
+	0x03, 0x03, 0x02, 0x00, 0x00, // Function section: 2 functions of type 0
+	0x05, 0x03, 0x01, 0x00, 0x01, // Memory section: 1 memory, min 1 page
+	0x07, 0x0e, 0x02, // Export section: 2 exports
+	0x03, 0x74, 0x72, 0x79, 0x00, 0x00, // "try" function 0
+	0x04, 0x74, 0x72, 0x61, 0x70, 0x00, 0x01, // "trap" function 1
+	0x0a, 0x0d, 0x02, // Code section: 2 bodies
+	0x07, 0x00, 0x41, 0x0a, 0x40, 0x00, 0x1a, 0x0b, // try: drop(memory.grow(10))
+	0x03, 0x00, 0x00, 0x0b, // trap: unreachable
+}
+
+func TestLimits_MaxMemoryPages_Handled(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	proc, err := newTestProc(t, system.ProcConfig{
+		Async:  true,
+		Limits: system.Limits{MaxMemoryPages: 4},
+	}, handledGrowWasm)
+	require.NoError(t, err)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// The guest handles the failure to grow its memory...
+	require.NoError(t, proc.ProcessMessage(ctx, mocks.NewMockStreamInterface(ctrl), "try"))
+
+	// ... so a later trap of the same instance is not the limit's doing.
+	s := mocks.NewMockStreamInterface(ctrl)
+	s.EXPECT().ResetWithError(system.ErrCodeTrap).Return(nil)
+
+	err = proc.ProcessMessage(ctx, s, "trap")
+	require.Error(t, err)
+
+	var limitErr *system.LimitError
+	assert.False(t, errors.As(err, &limitErr), "trap should not be reported as a limit")
+}
+
+// initWasm exports "_initialize", which spins for 65536 iterations, and
+// "nop".
+var initWasm = []byte{
+	0x00, 0x61, 0x73, 0x6d, // WASM magic number
+	0x01, 0x00, 0x00, 0x00, // Version 1
+	0x01, 0x04, 0x01, 0x60, 0x00, 0x00, // Type section: () -> ()
+	0x03, 0x03, 0x02, 0x00, 0x00, // Function section: 2 functions of type 0
+	0x07, 0x15, 0x02, // Export section: 2 exports
+	0x0b, 0x5f, 0x69, 0x6e, 0x69, 0x74, 0x69, 0x61, 0x6c, 0x69, 0x7a, 0x65, 0x00, 0x00, // "_initialize" function 0
+	0x03, 0x6e, 0x6f, 0x70, 0x00, 0x01, // "nop" function 1
+	0x0a, 0x1a, 0x02, // Code section: 2 bodies
+	0x15, 0x01, 0x01, 0x7f, // _initialize: 1 local i32
+	0x03, 0x40, 0x20, 0x00, 0x41, 0x01, 0x6a, 0x22, 0x00, // loop { local 0 += 1
+	0x41, 0x80, 0x80, 0x04, 0x49, 0x0d, 0x00, 0x0b, 0x0b, // br_if local 0 < 1<<16 }
+	0x02, 0x00, 0x0b, // nop
+}
+
+func TestLimits_RunTime_Initialize(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	// Every stream gets a fresh instance, which runs _initialize first.
+	proc, err := newTestProc(t, system.ProcConfig{
+		Async:  true,
+		Pool:   system.PoolConfig{Reuse: system.ReuseNever},
+		Limits: system.Limits{RunTime: 20 * time.Millisecond},
+	}, initWasm)
+	require.NoError(t, err)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// Calls to "nop" cost next to nothing, so only the initializers of the
+	// instances can spend the budget.
+	for range 100 {
+		s := mocks.NewMockStreamInterface(ctrl)
+		s.EXPECT().SetDeadline(gomock.Any()).Return(nil).AnyTimes()
+		s.EXPECT().Reset().Return(nil).AnyTimes()
+		s.EXPECT().ResetWithError(gomock.Any()).Return(nil).AnyTimes()
+
+		if err := proc.ProcessMessage(ctx, s, "nop"); err != nil {
+			var limitErr *system.LimitError
+			require.ErrorAs(t, err, &limitErr)
+			assert.Equal(t, system.LimitRunTime, limitErr.Limit)
+			return
+		}
+	}
+	t.Fatal("initializers should be charged to the run-time budget")
+}
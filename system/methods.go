@@ -0,0 +1,109 @@
+package system
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// MethodsMethod is the built-in method that describes the methods served by
+// a process.  It is answered by the host, without calling the guest.
+const MethodsMethod = ".methods"
+
+// MetadataPrefix is the prefix of custom sections reported as metadata, e.g.
+// "ww.description".  Other custom sections are left to the toolchain.
+const MetadataPrefix = "ww."
+
+// Methods describes the methods served by a process, as returned by
+// MethodsMethod.
+type Methods struct {
+	Proc    string   `json:"proc"`
+	Methods []Method `json:"methods"`
+
+	// Metadata holds the custom sections of the module whose names start
+	// with MetadataPrefix, by name.  Sections that are not valid UTF-8 are
+	// omitted.
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Method is an exported function of a process.
+type Method struct {
+	Name    string   `json:"name"`
+	Params  []string `json:"params"`  // value types, e.g. "i32"
+	Results []string `json:"results"` // value types, e.g. "i32"
+}
+
+func (m Method) String() string {
+	return fmt.Sprintf("%s(%s) -> (%s)", m.Name,
+		strings.Join(m.Params, ", "),
+		strings.Join(m.Results, ", "))
+}
+
+// newMethods describes the exports of cm, and its metadata.  The start,
+// initialization and shutdown functions are not methods.
+func newMethods(name string, cm wazero.CompiledModule) Methods {
+	ms := Methods{
+		Proc:     name,
+		Methods:  []Method{},
+		Metadata: metadata(cm),
+	}
+
+	for export, def := range cm.ExportedFunctions() {
+		if export == "_start" || export == ShutdownExport || slices.Contains(initFunctions, export) {
+			continue
+		}
+
+		ms.Methods = append(ms.Methods, Method{
+			Name:    export,
+			Params:  typeNames(def.ParamTypes()),
+			Results: typeNames(def.ResultTypes()),
+		})
+	}
+	slices.SortFunc(ms.Methods, func(a, b Method) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return ms
+}
+
+func typeNames(ts []api.ValueType) []string {
+	names := make([]string, len(ts))
+	for i, t := range ts {
+		names[i] = api.ValueTypeName(t)
+	}
+	return names
+}
+
+// metadata returns the metadata sections of cm, by name.  It is empty
+// unless cm was compiled by a runtime configured WithCustomSections(true).
+func metadata(cm wazero.CompiledModule) map[string]string {
+	sections := map[string]string{}
+
+	for _, section := range cm.CustomSections() {
+		name, data := section.Name(), section.Data()
+		if !strings.HasPrefix(name, MetadataPrefix) || !utf8.Valid(data) {
+			continue
+		}
+		sections[name] = string(data)
+	}
+
+	if len(sections) == 0 {
+		return nil
+	}
+	return sections
+}
+
+// serveMethods writes the description of the process to s, as JSON.
+func (p Proc) serveMethods(s network.Stream) error {
+	if err := json.NewEncoder(s).Encode(p.methods); err != nil {
+		_ = s.Reset()
+		return fmt.Errorf("%s::%s: %w", p.ID(), MethodsMethod, err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package system_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tetratelabs/wazero"
+	"github.com/wetware/go/system"
+	"github.com/wetware/go/system/mocks"
+	"go.uber.org/mock/gomock"
+)
+
+// metadataWasm is limitsWasm followed by two custom sections: a
+// "ww.description" section, and a ".debug_info" section that is not
+// metadata.
+var metadataWasm = append(append([]byte{}, limitsWasm...),
+	0x00, 0x1e, 0x0e, 0x77, 0x77, 0x2e, 0x64, 0x65, 0x73, 0x63, 0x72, 0x69, // "ww.description"
+	0x70, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x70, 0x69, 0x6e, 0x73, 0x20, 0x61, // "spins and grows"
+	0x6e, 0x64, 0x20, 0x67, 0x72, 0x6f, 0x77, 0x73,
+	0x00, 0x0e, 0x0b, 0x2e, 0x64, 0x65, 0x62, 0x75, 0x67, 0x5f, 0x69, 0x6e, // ".debug_info"
+	0x66, 0x6f, 0x00, 0x01,
+)
+
+func TestProc_Methods(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithCustomSections(true))
+	defer runtime.Close(ctx)
+
+	proc, err := system.ProcConfig{
+		Name:      "limits",
+		Runtime:   runtime,
+		Src:       io.NopCloser(bytes.NewReader(metadataWasm)),
+		ErrWriter: &bytes.Buffer{},
+		Async:     true,
+	}.New(ctx)
+	require.NoError(t, err)
+	defer proc.Close(ctx)
+
+	want := system.Methods{
+		Proc: "limits",
+		Methods: []system.Method{
+			{Name: "grow", Params: []string{}, Results: []string{}},
+			{Name: "spin", Params: []string{}, Results: []string{}},
+		},
+		Metadata: map[string]string{"ww.description": "spins and grows"},
+	}
+	assert.Equal(t, want, proc.Methods())
+	assert.Equal(t, "grow() -> ()", want.Methods[0].String())
+
+	// The description is served over the stream, without calling the guest.
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var out bytes.Buffer
+	s := mocks.NewMockStreamInterface(ctrl)
+	s.EXPECT().Write(gomock.Any()).DoAndReturn(out.Write).AnyTimes()
+	require.NoError(t, proc.ProcessMessage(ctx, s, system.MethodsMethod))
+
+	var got system.Methods
+	require.NoError(t, json.Unmarshal(out.Bytes(), &got))
+	assert.Equal(t, want, got)
+}
+
+func TestProc_Methods_Echo(t *testing.T) {
+	t.Parallel()
+
+	proc, err := newTestProc(t, system.ProcConfig{Async: true}, loadEchoWasm(t))
+	require.NoError(t, err)
+
+	var names []string
+	for _, m := range proc.Methods().Methods {
+		names = append(names, m.Name)
+		assert.NotEqual(t, "_start", m.Name)
+	}
+	assert.Contains(t, names, "echo")
+	assert.Nil(t, proc.Methods().Metadata)
+}
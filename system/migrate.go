@@ -0,0 +1,203 @@
+package system
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// MigrateMethod is the built-in method that moves a service to another
+// peer.  The caller writes the ID of the target peer, and the host answers
+// with the JSON-encoded Redirect.  Like CheckpointMethod, it is only served
+// by processes with ProcConfig.Checkpoints set, and it is denied unless
+// ProcConfig.ACL allows it to peers by name.
+const MigrateMethod = ".migrate"
+
+// MigrateProtocol is served by nodes that accept migrated processes.  The
+// source peer writes a JSON-encoded MigrateRequest, and the target answers
+// with the JSON-encoded Redirect of the restored process.
+const MigrateProtocol protocol.ID = "/ww/migrate/0.1.0"
+
+// ErrMoved is returned for streams sent to a service that has migrated to
+// another peer.
+var ErrMoved = errors.New("moved")
+
+// ErrCodeMoved is sent to the remote peer when a stream is reset because
+// the service has migrated to another peer.
+const ErrCodeMoved network.StreamErrorCode = 0x1006
+
+// MigrateRequest asks a node to restore a process from a checkpoint.
+type MigrateRequest struct {
+	Checkpoint string `json:"checkpoint"` // IPFS path, as saved by Checkpoint.Save
+}
+
+// Redirect is the forwarding record of a service that has migrated: the
+// peer that runs it now, and its endpoint name there.
+type Redirect struct {
+	Proc string        `json:"proc"`
+	Peer peer.AddrInfo `json:"peer"`
+}
+
+// MigrateFunc moves a checkpoint to the peer with the given ID, which
+// restores it, and returns where the process runs now.
+type MigrateFunc func(ctx context.Context, c *Checkpoint, to peer.ID) (Redirect, error)
+
+// Migrate moves the service to another peer with the supervisor's Migrate
+// function.  The process stops accepting streams, and once the calls in
+// progress have returned, its checkpoint is restored by the target.  The
+// service then stops for good, and keeps the Redirect as a forwarding
+// record.  If the migration fails, the process resumes serving streams.
+func (svc *Service) Migrate(ctx context.Context, to peer.ID) (Redirect, error) {
+	if svc.sup.Migrate == nil {
+		return Redirect{}, fmt.Errorf("%s: migration not supported", svc.Name())
+	}
+
+	svc.mu.Lock()
+	p := svc.proc
+	svc.mu.Unlock()
+
+	if p == nil {
+		return Redirect{}, fmt.Errorf("%s: %w", svc.Name(), ErrBusy)
+	}
+
+	r, err := svc.migrate(ctx, p, to)
+	if err != nil {
+		p.Resume()
+		return Redirect{}, err
+	}
+
+	svc.mu.Lock()
+	svc.redirect = &r
+	p = svc.stop()
+	svc.mu.Unlock()
+
+	if p != nil {
+		_ = p.Close(ctx)
+	}
+	svc.wg.Wait()
+
+	return r, nil
+}
+
+func (svc *Service) migrate(ctx context.Context, p *Proc, to peer.ID) (Redirect, error) {
+	if err := p.Drain(ctx); err != nil {
+		return Redirect{}, err
+	}
+
+	c, err := p.Checkpoint(ctx)
+	if err != nil {
+		return Redirect{}, err
+	}
+
+	r, err := svc.sup.Migrate(ctx, c, to)
+	if err != nil {
+		return Redirect{}, fmt.Errorf("%s: migrate to %s: %w", svc.Name(), to, err)
+	}
+	return r, nil
+}
+
+// Redirect returns the forwarding record of a service that has migrated to
+// another peer, or nil.
+func (svc *Service) Redirect() *Redirect {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	return svc.redirect
+}
+
+// serveMigrate reads the ID of the target peer from s, migrates the service
+// to it, and writes the Redirect to s.  Since the target receives the whole
+// state of the process, and callers are then sent to it, the ACL must allow
+// the method to peers by name.
+func (svc *Service) serveMigrate(ctx context.Context, s network.Stream) error {
+	if !svc.config.explicit(MigrateMethod) {
+		_ = s.ResetWithError(ErrCodeDenied)
+		return fmt.Errorf("%s::%s: %w: not allowed to specific peers", svc.Name(), MigrateMethod, ErrDenied)
+	}
+
+	if err := svc.migrateStream(ctx, s); err != nil {
+		_ = s.Reset()
+		return fmt.Errorf("%s::%s: %w", svc.Name(), MigrateMethod, err)
+	}
+	return nil
+}
+
+func (svc *Service) migrateStream(ctx context.Context, s network.Stream) error {
+	line, err := bufio.NewReader(io.LimitReader(s, 256)).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	to, err := peer.Decode(strings.TrimSpace(line))
+	if err != nil {
+		return fmt.Errorf("invalid target peer: %w", err)
+	}
+
+	r, err := svc.Migrate(ctx, to)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(s).Encode(r)
+}
+
+// Forward relays s to the peer in the forwarding record of a migrated
+// service, under the same protocol version and method, so that callers
+// need not know that it moved.  Errors reported by the target are relayed
+// to the caller.  Streams that cannot be forwarded are reset with
+// ErrCodeMoved.
+//
+// The target sees the forwarded stream as coming from h, not from the
+// original caller, so its ACL and the guest's view of its caller apply to
+// h.  Check the caller against the ACL before forwarding.
+func Forward(ctx context.Context, h host.Host, s network.Stream, r Redirect) error {
+	out, err := dial(ctx, h, s, r)
+	if err != nil {
+		_ = s.ResetWithError(ErrCodeMoved)
+		return fmt.Errorf("forward to %s: %w", r.Peer.ID, err)
+	}
+	defer out.Close()
+
+	go func() {
+		if _, err := io.Copy(out, s); err != nil {
+			_ = out.Reset()
+			return
+		}
+		_ = out.CloseWrite()
+	}()
+
+	if _, err := io.Copy(s, out); err != nil {
+		var streamErr *network.StreamError
+		if errors.As(err, &streamErr) && streamErr.Remote {
+			_ = s.ResetWithError(streamErr.ErrorCode)
+		} else {
+			_ = s.Reset()
+		}
+		return fmt.Errorf("forward to %s: %w", r.Peer.ID, err)
+	}
+
+	return s.CloseWrite()
+}
+
+// dial opens the stream that s is forwarded to.
+func dial(ctx context.Context, h host.Host, s network.Stream, r Redirect) (network.Stream, error) {
+	v, _, method, err := ParseProtocol(s.Protocol())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.Connect(ctx, r.Peer); err != nil {
+		return nil, err
+	}
+
+	return h.NewStream(ctx, r.Peer.ID, v.ProtocolID(r.Proc, method))
+}
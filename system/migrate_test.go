@@ -0,0 +1,206 @@
+package system_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wetware/go/system"
+	"github.com/wetware/go/system/mocks"
+	"go.uber.org/mock/gomock"
+)
+
+const targetPeer = peer.ID("target")
+
+func startCounter(t *testing.T, sup *system.Supervisor, checkpoints bool, acl *system.ACL) *system.Service {
+	t.Helper()
+
+	var ptr atomic.Pointer[system.ACL]
+	ptr.Store(acl)
+
+	return startService(t, sup, system.ProcConfig{
+		Name:        "counter",
+		Async:       true,
+		Checkpoints: checkpoints,
+		ACL:         &ptr,
+	}, counterWasm)
+}
+
+func TestService_Migrate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	var moved *system.Checkpoint
+	sup := &system.Supervisor{
+		Policy: system.RestartAlways,
+		Migrate: func(ctx context.Context, c *system.Checkpoint, to peer.ID) (system.Redirect, error) {
+			moved = c
+			return system.Redirect{Proc: c.Proc, Peer: peer.AddrInfo{ID: to}}, nil
+		},
+	}
+	svc := startCounter(t, sup, false, nil)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	for range 2 {
+		require.NoError(t, svc.ProcessMessage(ctx, mocks.NewMockStreamInterface(ctrl), "incr"))
+	}
+
+	r, err := svc.Migrate(ctx, targetPeer)
+	require.NoError(t, err)
+	assert.Equal(t, system.Redirect{Proc: "counter", Peer: peer.AddrInfo{ID: targetPeer}}, r)
+	assert.Equal(t, &r, svc.Redirect())
+
+	require.NotNil(t, moved, "checkpoint should have been moved")
+	assert.Equal(t, map[string]uint64{"count": 2}, moved.Globals)
+
+	// The service is stopped for good, and rejects streams as moved.
+	select {
+	case <-svc.Done():
+	default:
+		t.Fatal("service should be done")
+	}
+
+	s := mocks.NewMockStreamInterface(ctrl)
+	s.EXPECT().ResetWithError(system.ErrCodeMoved).Return(nil)
+	assert.ErrorIs(t, svc.ProcessMessage(ctx, s, "incr"), system.ErrMoved)
+}
+
+func TestService_Migrate_Fails(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	sup := &system.Supervisor{
+		Migrate: func(ctx context.Context, c *system.Checkpoint, to peer.ID) (system.Redirect, error) {
+			return system.Redirect{}, errors.New("unreachable")
+		},
+	}
+	svc := startCounter(t, sup, false, nil)
+
+	_, err := svc.Migrate(ctx, targetPeer)
+	assert.ErrorContains(t, err, "unreachable")
+	assert.Nil(t, svc.Redirect())
+
+	// The process resumes serving streams.
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	assert.NoError(t, svc.ProcessMessage(ctx, mocks.NewMockStreamInterface(ctrl), "incr"))
+}
+
+func TestService_MigrateMethod(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	target, err := peer.Decode("12D3KooWGzxzKZYveHXtpG6AsrUJBcWxHBFS2HsEoGTxrMLvKXtf")
+	require.NoError(t, err)
+	caller := peer.ID("caller")
+
+	sup := &system.Supervisor{
+		Migrate: func(ctx context.Context, c *system.Checkpoint, to peer.ID) (system.Redirect, error) {
+			return system.Redirect{Proc: c.Proc, Peer: peer.AddrInfo{ID: to}}, nil
+		},
+	}
+
+	t.Run("Enabled", func(t *testing.T) {
+		t.Parallel()
+
+		svc := startCounter(t, sup, true, &system.ACL{
+			Methods: map[string]system.MethodACL{
+				system.MigrateMethod: {Allow: []peer.ID{caller}},
+			},
+		})
+
+		var out bytes.Buffer
+		in := strings.NewReader(target.String() + "\n")
+		s := mocks.NewMockStreamInterface(ctrl)
+		s.EXPECT().Read(gomock.Any()).DoAndReturn(in.Read).AnyTimes()
+		s.EXPECT().Write(gomock.Any()).DoAndReturn(out.Write).AnyTimes()
+		require.NoError(t, svc.ProcessMessage(ctx, s, system.MigrateMethod))
+
+		var r system.Redirect
+		require.NoError(t, json.NewDecoder(&out).Decode(&r))
+		assert.Equal(t, target, r.Peer.ID)
+		assert.NotNil(t, svc.Redirect())
+	})
+
+	t.Run("NoExplicitACL", func(t *testing.T) {
+		t.Parallel()
+
+		sup := &system.Supervisor{Migrate: sup.Migrate}
+		svc := startCounter(t, sup, true, &system.ACL{Allow: []peer.ID{caller}})
+
+		s := mocks.NewMockStreamInterface(ctrl)
+		s.EXPECT().ResetWithError(system.ErrCodeDenied).Return(nil)
+		assert.ErrorIs(t, svc.ProcessMessage(ctx, s, system.MigrateMethod), system.ErrDenied)
+		assert.Nil(t, svc.Redirect())
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		t.Parallel()
+
+		sup := &system.Supervisor{Migrate: sup.Migrate}
+		svc := startCounter(t, sup, false, nil)
+
+		s := mocks.NewMockStreamInterface(ctrl)
+		s.EXPECT().ResetWithError(system.ErrCodeUnknownMethod).Return(nil)
+		assert.ErrorIs(t, svc.ProcessMessage(ctx, s, system.MigrateMethod), system.ErrUnknownMethod)
+		assert.Nil(t, svc.Redirect())
+	})
+}
+
+func TestForward(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	mn, err := mocknet.FullMeshLinked(3)
+	require.NoError(t, err)
+	defer mn.Close()
+	caller, source, target := mn.Hosts()[0], mn.Hosts()[1], mn.Hosts()[2]
+
+	// The process moved from "old" on the source to "new" on the target,
+	// which echoes its input in upper case.
+	target.SetStreamHandler(system.V0_2_0.ProtocolID("new", "shout"), func(s network.Stream) {
+		defer s.Close()
+		b, _ := io.ReadAll(s)
+		s.Write(bytes.ToUpper(b))
+	})
+
+	r := system.Redirect{Proc: "new", Peer: peer.AddrInfo{ID: target.ID(), Addrs: target.Addrs()}}
+	cherr := make(chan error, 1)
+	source.SetStreamHandler(system.V0_2_0.ProtocolID("old", "shout"), func(s network.Stream) {
+		defer s.Close()
+		cherr <- system.Forward(ctx, source, s, r)
+	})
+
+	s, err := caller.NewStream(ctx, source.ID(), system.V0_2_0.ProtocolID("old", "shout"))
+	require.NoError(t, err)
+	defer s.Close()
+
+	go func() {
+		s.Write([]byte("hello"))
+		s.CloseWrite()
+	}()
+
+	out, err := io.ReadAll(s)
+	require.NoError(t, err)
+	assert.Equal(t, "HELLO", string(out))
+	assert.NoError(t, <-cherr)
+}
@@ -0,0 +1,69 @@
+package system
+
+import (
+	"context"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// p2pDial(peer, peer_len, proc, proc_len, method, method_len i32) -> i32
+//
+// Opens a stream to method on the named process at peer, and returns a
+// handle that can be read, written and closed.  An empty method calls
+// "poll".
+func (h *hostModule) p2pDial(ctx context.Context, mod api.Module, stack []uint64) {
+	var args [3]string
+	for i := range args {
+		buf, ok := mod.Memory().Read(api.DecodeU32(stack[2*i]), api.DecodeU32(stack[2*i+1]))
+		if !ok {
+			stack[0] = errno(ErrnoFault)
+			return
+		}
+		args[i] = string(buf)
+	}
+
+	id, err := peer.Decode(args[0])
+	if err != nil || args[1] == "" {
+		stack[0] = errno(ErrnoInval)
+		return
+	}
+
+	if h.Config.Host == nil {
+		stack[0] = errno(ErrnoIO)
+		return
+	}
+
+	if err := h.connect(ctx, id); err != nil {
+		stack[0] = errno(ErrnoIO)
+		return
+	}
+
+	s, err := h.Config.Host.NewStream(ctx, id, ProtocolIDs(args[1], args[2])...)
+	if err != nil {
+		stack[0] = errno(ErrnoIO)
+		return
+	}
+
+	stack[0] = api.EncodeI32(h.handles(mod).Add(s))
+}
+
+// connect to peer id, looking up its addresses through ProcConfig.Routing
+// if we are not already connected.
+func (h *hostModule) connect(ctx context.Context, id peer.ID) error {
+	if h.Config.Host.Network().Connectedness(id) == network.Connected {
+		return nil
+	}
+
+	if h.Config.Routing == nil {
+		return nil // let the host dial any addresses it already knows
+	}
+
+	info, err := h.Config.Routing.FindPeer(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return h.Config.Host.Connect(ctx, info)
+}
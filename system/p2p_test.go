@@ -0,0 +1,141 @@
+package system_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/wetware/go/system"
+)
+
+// p2pWasm imports ww.p2p_dial, ww.write, ww.close_write and ww.read, and
+// re-exports them unchanged as "dial", "write", "close_write" and "read",
+// along with its memory, so that tests can drive the host functions
+// directly.
+var p2pWasm = []byte{
+	0x00, 0x61, 0x73, 0x6d, // WASM magic number
+	0x01, 0x00, 0x00, 0x00, // Version 1
+	0x01, 0x17, 0x03, // Type section: 3 types
+	0x60, 0x06, 0x7f, 0x7f, 0x7f, 0x7f, 0x7f, 0x7f, 0x01, 0x7f, // (i32 x 6) -> i32
+	0x60, 0x03, 0x7f, 0x7f, 0x7f, 0x01, 0x7f, // (i32, i32, i32) -> i32
+	0x60, 0x01, 0x7f, 0x01, 0x7f, // (i32) -> i32
+	0x02, 0x35, 0x04, // Import section: 4 imports
+	0x02, 0x77, 0x77, 0x08, 0x70, 0x32, 0x70, 0x5f, 0x64, 0x69, 0x61, 0x6c, 0x00, 0x00, // "ww" "p2p_dial" type 0
+	0x02, 0x77, 0x77, 0x05, 0x77, 0x72, 0x69, 0x74, 0x65, 0x00, 0x01, // "ww" "write" type 1
+	0x02, 0x77, 0x77, 0x0b, 0x63, 0x6c, 0x6f, 0x73, 0x65, 0x5f, // "ww" "close_write"
+	0x77, 0x72, 0x69, 0x74, 0x65, 0x00, 0x02, // type 2
+	0x02, 0x77, 0x77, 0x04, 0x72, 0x65, 0x61, 0x64, 0x00, 0x01, // "ww" "read" type 1
+	0x03, 0x05, 0x04, 0x00, 0x01, 0x02, 0x01, // Function section: 4 functions of types 0, 1, 2, 1
+	0x05, 0x03, 0x01, 0x00, 0x01, // Memory section: 1 memory, min 1 page
+	0x07, 0x2e, 0x05, // Export section: 5 exports
+	0x06, 0x6d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x02, 0x00, // "memory" memory 0
+	0x04, 0x64, 0x69, 0x61, 0x6c, 0x00, 0x04, // "dial" function 4
+	0x05, 0x77, 0x72, 0x69, 0x74, 0x65, 0x00, 0x05, // "write" function 5
+	0x0b, 0x63, 0x6c, 0x6f, 0x73, 0x65, 0x5f, 0x77, 0x72, 0x69, 0x74, 0x65, 0x00, 0x06, // "close_write" function 6
+	0x04, 0x72, 0x65, 0x61, 0x64, 0x00, 0x07, // "read" function 7
+	0x0a, 0x2f, 0x04, // Code section: 4 bodies
+	0x10, 0x00, 0x20, 0x00, 0x20, 0x01, 0x20, 0x02, // p2p_dial(p0, ..., p5)
+	0x20, 0x03, 0x20, 0x04, 0x20, 0x05, 0x10, 0x00, 0x0b,
+	0x0a, 0x00, 0x20, 0x00, 0x20, 0x01, 0x20, 0x02, 0x10, 0x01, 0x0b, // write(p0, p1, p2)
+	0x06, 0x00, 0x20, 0x00, 0x10, 0x02, 0x0b, // close_write(p0)
+	0x0a, 0x00, 0x20, 0x00, 0x20, 0x01, 0x20, 0x02, 0x10, 0x03, 0x0b, // read(p0, p1, p2)
+}
+
+// writeArgs copies each string into guest memory, starting at offset 0, and
+// returns the (ptr, len) pairs.
+func writeArgs(t *testing.T, mod api.Module, args ...string) []uint64 {
+	t.Helper()
+
+	var params []uint64
+	var off uint32
+	for _, s := range args {
+		require.True(t, mod.Memory().WriteString(off, s))
+		params = append(params, uint64(off), uint64(len(s)))
+		off += uint32(len(s))
+	}
+	return params
+}
+
+func TestHostModule_P2P(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	mn, err := mocknet.FullMeshLinked(2)
+	require.NoError(t, err)
+	defer mn.Close()
+	server, client := mn.Hosts()[0], mn.Hosts()[1]
+
+	// Serve the echo example on the first host.
+	echo, err := newTestProc(t, system.ProcConfig{Async: true}, loadEchoWasm(t))
+	require.NoError(t, err)
+	server.SetStreamHandler(system.ProtocolID(echo.ID(), "echo"), func(s network.Stream) {
+		defer s.Close()
+		echo.ProcessMessage(ctx, s, "echo")
+	})
+
+	newDialer := func(t *testing.T, caps system.Capability) (*system.Proc, error) {
+		runtime := wazero.NewRuntime(ctx)
+		t.Cleanup(func() { runtime.Close(ctx) })
+
+		proc, err := system.ProcConfig{
+			Host:      client,
+			Runtime:   runtime,
+			Src:       io.NopCloser(bytes.NewReader(p2pWasm)),
+			ErrWriter: &bytes.Buffer{},
+			Async:     true,
+			Caps:      caps,
+		}.New(ctx)
+		if err == nil {
+			t.Cleanup(func() { proc.Close(ctx) })
+		}
+		return proc, err
+	}
+
+	t.Run("dial", func(t *testing.T) {
+		proc, err := newDialer(t, system.CapP2P)
+		require.NoError(t, err)
+		mod := proc.Module
+
+		h := call(t, mod, "dial", writeArgs(t, mod, server.ID().String(), echo.ID(), "echo")...)
+		require.Positive(t, h)
+
+		msg := "hello, peer\n"
+		require.True(t, mod.Memory().WriteString(512, msg))
+		assert.Equal(t, int32(len(msg)), call(t, mod, "write", api.EncodeI32(h), 512, uint64(len(msg))))
+		assert.Zero(t, call(t, mod, "close_write", api.EncodeI32(h)))
+
+		assert.Equal(t, msg, readAll(t, mod, h, 4))
+	})
+
+	t.Run("invalid peer", func(t *testing.T) {
+		proc, err := newDialer(t, system.CapP2P)
+		require.NoError(t, err)
+		mod := proc.Module
+
+		assert.Equal(t, -int32(system.ErrnoInval),
+			call(t, mod, "dial", writeArgs(t, mod, "not-a-peer", echo.ID(), "echo")...))
+	})
+
+	t.Run("not granted", func(t *testing.T) {
+		proc, err := newDialer(t, system.CapConsole)
+		require.Error(t, err)
+		assert.Nil(t, proc)
+		assert.Contains(t, err.Error(), `"p2p"`)
+	})
+}
+
+func TestProtocolID(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "/ww/0.1.0/proc", string(system.ProtocolID("proc", "")))
+	assert.Equal(t, "/ww/0.1.0/proc", string(system.ProtocolID("proc", "poll")))
+	assert.Equal(t, "/ww/0.1.0/proc/echo", string(system.ProtocolID("proc", "echo")))
+}
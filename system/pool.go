@@ -0,0 +1,218 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/tetratelabs/wazero/api"
+	"go.uber.org/multierr"
+)
+
+// ReusePolicy controls what happens to a module instance after it has
+// finished processing a stream.
+type ReusePolicy int
+
+const (
+	// ReuseRecycle returns the instance to the pool, so that subsequent
+	// streams observe the state left behind by earlier ones.  This is the
+	// default.
+	ReuseRecycle ReusePolicy = iota
+
+	// ReuseNever closes the instance after a single stream.  Every stream
+	// is served by a freshly instantiated module.
+	ReuseNever
+)
+
+func (r ReusePolicy) String() string {
+	switch r {
+	case ReuseRecycle:
+		return "recycle"
+	case ReuseNever:
+		return "never"
+	}
+
+	return "unknown"
+}
+
+// PoolConfig configures the set of module instances backing an async Proc.
+type PoolConfig struct {
+	Size  int         // maximum number of concurrent instances; defaults to 1
+	Reuse ReusePolicy // what to do with an instance after it served a stream
+}
+
+func (c PoolConfig) size() int {
+	if c.Size <= 0 {
+		return 1
+	}
+	return c.Size
+}
+
+// Instance is a single module instantiation, along with the socket that is
+// bound to its stdin and stdout.  An instance processes at most one stream
+// at a time.
+type Instance struct {
+	Module api.Module
+	Socket *Endpoint
+
+	mem  *memoryLimit // nil if memory is unlimited
+	host *hostModule  // holds the handles of the instance; optional
+}
+
+// Close the module instance.
+func (i *Instance) Close(ctx context.Context) error {
+	return multierr.Combine(
+		i.release(),
+		i.Module.Close(ctx))
+}
+
+// release the handles held by the instance, so that none outlive the
+// stream that the instance served.
+func (i *Instance) release() error {
+	if i.host == nil {
+		return nil
+	}
+	return i.host.release(i.Module)
+}
+
+// Pool hands out module instances to concurrent streams.  Instances are
+// created on demand from the compiled module, up to PoolConfig.Size, and are
+// either recycled or discarded after use according to PoolConfig.Reuse.
+// Instances whose module has been closed (e.g. because the guest called
+// proc_exit) are always discarded.
+type Pool struct {
+	Config PoolConfig
+	New    func(context.Context) (*Instance, error)
+
+	once   sync.Once
+	slots  chan struct{}
+	mu     sync.Mutex
+	idle   []*Instance
+	closed bool
+}
+
+// NewPool returns a pool that instantiates modules with newInstance.  Any
+// instances in idle are made available to the first callers of Acquire.
+func NewPool(config PoolConfig, newInstance func(context.Context) (*Instance, error), idle ...*Instance) *Pool {
+	return &Pool{
+		Config: config,
+		New:    newInstance,
+		idle:   idle,
+	}
+}
+
+var errPoolClosed = errors.New("pool closed")
+
+func (p *Pool) init() {
+	p.once.Do(func() {
+		p.slots = make(chan struct{}, p.Config.size())
+	})
+}
+
+// Acquire an instance, blocking until one is available or the context
+// expires.  The caller MUST pass the instance to Release when finished.
+func (p *Pool) Acquire(ctx context.Context) (*Instance, error) {
+	p.init()
+
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	inst, err := p.next(ctx)
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+
+	return inst, nil
+}
+
+// next returns an idle instance, or instantiates a new one.  The caller
+// holds a slot, so instantiation happens outside of the lock, and does not
+// hold up other callers of Acquire and Release.
+func (p *Pool) next(ctx context.Context) (*Instance, error) {
+	if inst, err := p.pop(); inst != nil || err != nil {
+		return inst, err
+	}
+
+	inst, err := p.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+
+	if closed {
+		_ = inst.Close(ctx)
+		return nil, errPoolClosed
+	}
+
+	return inst, nil
+}
+
+// pop an idle instance, or return nil if there is none.
+func (p *Pool) pop() (*Instance, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, errPoolClosed
+	}
+
+	for len(p.idle) > 0 {
+		inst := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if !inst.Module.IsClosed() {
+			return inst, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Idle returns the instances that are not serving a stream.
+func (p *Pool) Idle() []*Instance {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return slices.Clone(p.idle)
+}
+
+// Release returns an instance obtained from Acquire to the pool.
+func (p *Pool) Release(ctx context.Context, inst *Instance) {
+	defer func() { <-p.slots }()
+
+	_ = inst.release()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed || p.Config.Reuse == ReuseNever || inst.Module.IsClosed() {
+		_ = inst.Close(ctx)
+		return
+	}
+
+	p.idle = append(p.idle, inst)
+}
+
+// Close the pool and all idle instances.  Instances that are in use are
+// closed when they are released.
+func (p *Pool) Close(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+
+	var errs []error
+	for _, inst := range p.idle {
+		errs = append(errs, inst.Close(ctx))
+	}
+	p.idle = nil
+
+	return multierr.Combine(errs...)
+}
@@ -0,0 +1,161 @@
+package system_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wetware/go/system"
+	"github.com/wetware/go/system/mocks"
+	"go.uber.org/mock/gomock"
+)
+
+// echoStream returns a mock stream that yields input, then blocks on wait
+// before signalling EOF.  Everything written to the stream goes to out.
+func echoStream(ctrl *gomock.Controller, input string, wait <-chan struct{}, out *bytes.Buffer) *mocks.MockStreamInterface {
+	s := mocks.NewMockStreamInterface(ctrl)
+	s.EXPECT().Read(gomock.Any()).DoAndReturn(func(p []byte) (int, error) {
+		if len(input) == 0 {
+			<-wait
+			return 0, io.EOF
+		}
+		n := copy(p, input)
+		input = input[n:]
+		return n, nil
+	}).AnyTimes()
+	s.EXPECT().Write(gomock.Any()).DoAndReturn(out.Write).AnyTimes()
+	s.EXPECT().Reset().Return(nil).AnyTimes()
+	return s
+}
+
+func TestPool_ConcurrentStreamsAreIsolated(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	proc, err := newTestProc(t, system.ProcConfig{Async: true, Pool: system.PoolConfig{Size: 2}}, loadEchoWasm(t))
+	require.NoError(t, err)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// Neither stream reaches EOF until both have been dispatched, so the
+	// two calls are guaranteed to overlap.
+	wait := make(chan struct{})
+	inputs := []string{"first message\n", "second message\n"}
+	outputs := []*bytes.Buffer{{}, {}}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(inputs))
+	for i, input := range inputs {
+		wg.Add(1)
+		go func(i int, s *mocks.MockStreamInterface) {
+			defer wg.Done()
+			errs[i] = proc.ProcessMessage(ctx, s, "echo")
+		}(i, echoStream(ctrl, input, wait, outputs[i]))
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	close(wait)
+	wg.Wait()
+
+	for i := range inputs {
+		require.NoError(t, errs[i])
+		assert.Equal(t, inputs[i], outputs[i].String())
+	}
+}
+
+func TestPool_ReuseNever(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	proc, err := newTestProc(t, system.ProcConfig{Async: true, Pool: system.PoolConfig{Reuse: system.ReuseNever}}, loadEchoWasm(t))
+	require.NoError(t, err)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	done := make(chan struct{})
+	close(done)
+
+	var out bytes.Buffer
+	err = proc.ProcessMessage(ctx, echoStream(ctrl, "hello\n", done, &out), "echo")
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n", out.String())
+	assert.True(t, proc.Module.IsClosed(), "primary instance should be discarded after use")
+
+	// A fresh instance serves the next stream.
+	out.Reset()
+	err = proc.ProcessMessage(ctx, echoStream(ctrl, "world\n", done, &out), "echo")
+	require.NoError(t, err)
+	assert.Equal(t, "world\n", out.String())
+}
+
+func TestPool_AcquireBlocksWhenExhausted(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	proc, err := newTestProc(t, system.ProcConfig{Async: true, Pool: system.PoolConfig{Size: 1}}, loadEchoWasm(t))
+	require.NoError(t, err)
+
+	inst, err := proc.Pool.Acquire(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, proc.Module, inst.Module, "primary instance should be handed out first")
+
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = proc.Pool.Acquire(timeout)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	proc.Pool.Release(ctx, inst)
+
+	inst, err = proc.Pool.Acquire(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, proc.Module, inst.Module, "primary instance should be recycled")
+	proc.Pool.Release(ctx, inst)
+}
+
+func TestPool_InstantiatesConcurrently(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	var calls int
+	var mu sync.Mutex
+
+	pool := system.NewPool(system.PoolConfig{Size: 2}, func(ctx context.Context) (*system.Instance, error) {
+		mu.Lock()
+		calls++
+		first := calls == 1
+		mu.Unlock()
+
+		if first {
+			close(started)
+			<-unblock
+		}
+		return &system.Instance{}, nil
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := pool.Acquire(ctx)
+		done <- err
+	}()
+	<-started
+
+	// The first instantiation is still in progress, and must not hold up
+	// the second.
+	timeout, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	_, err := pool.Acquire(timeout)
+	assert.NoError(t, err)
+
+	close(unblock)
+	assert.NoError(t, <-done)
+}
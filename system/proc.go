@@ -1,16 +1,24 @@
 package system
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"slices"
 	"strings"
+	"sync/atomic"
 
+	iface "github.com/ipfs/kubo/core/coreiface"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
 	"github.com/mr-tron/base58"
 	"github.com/tetratelabs/wazero"
 	"github.com/tetratelabs/wazero/api"
@@ -20,13 +28,58 @@ import (
 	"golang.org/x/sync/semaphore"
 )
 
+// ErrUnknownMethod is returned for streams that call a function the guest
+// does not export.
+var ErrUnknownMethod = errors.New("unknown method")
+
+// initFunctions are called once on every instance of an async process, in
+// order, before it serves any stream.  WASI reactors export _initialize to
+// set up their runtime, and guests may export init for setup of their own.
+// Neither can be called as a method.
+var initFunctions = []string{"_initialize", "init"}
+
 type ProcConfig struct {
+	Name      string // Endpoint name; derived from the bytecode and host if empty
 	Host      host.Host
-	Runtime   wazero.Runtime
+	Runtime   wazero.Runtime // Configure WithCustomSections(true) to serve metadata
 	Src       io.ReadCloser
 	Env, Args []string
 	ErrWriter io.Writer
-	Async     bool // If true, use WithStartFunctions() and set up stream handler
+	Async     bool                // If true, use WithStartFunctions() and set up stream handler
+	Serve     bool                // In async mode, serve the default method by running _start on a fresh instance per stream
+	Pool      PoolConfig          // Instance pool used to serve concurrent streams in async mode
+	Queue     QueueConfig         // Bounds streams waiting for an instance in async mode
+	Limits    Limits              // Resource limits enforced on every instance
+	Caps      Capability          // Capabilities granted through the "ww" host module
+	Console   io.Writer           // Host console for CapConsole; defaults to os.Stdout
+	IPFS      iface.CoreAPI       // IPFS node for CapIPFS
+	Routing   routing.PeerRouting // Finds peers dialed with CapP2P; optional
+	Stdin     io.Reader           // Standard input in sync mode; defaults to os.Stdin
+	Stdout    io.Writer           // Standard output in sync mode; defaults to os.Stdout
+	Mounts    []Mount             // Filesystems exposed to the guest through WASI
+
+	// Restore is the state of every instance of an async process, in
+	// place of its initial state; optional.  See Checkpoint.
+	Restore *Checkpoint
+
+	// Checkpoints enables CheckpointMethod, which saves the state of the
+	// process to IPFS on behalf of callers.
+	Checkpoints bool
+
+	// ACL is the ACL of the process, as reloaded by the host, which checks
+	// callers against it.  CheckpointMethod and MigrateMethod are denied
+	// unless the ACL allows them to peers by name; see ACL.Explicit.
+	// Optional.
+	ACL *atomic.Pointer[ACL]
+
+	// Resolve loads the bytecode for a child process spawned with CapExec,
+	// from a local or IPFS path.
+	Resolve func(ctx context.Context, name string) (io.ReadCloser, error)
+
+	// RuntimeConfig configures the runtime of each child process; optional.
+	RuntimeConfig wazero.RuntimeConfig
+
+	runTime *atomic.Int64 // shared with the parent of a child process
 }
 
 func (c ProcConfig) New(ctx context.Context) (*Proc, error) {
@@ -48,18 +101,63 @@ func (c ProcConfig) New(ctx context.Context) (*Proc, error) {
 	}
 	defer c.Src.Close()
 
+	if c.Name = c.name(bytecode); !ValidName(c.Name) {
+		return nil, fmt.Errorf("invalid endpoint name %q", c.Name)
+	}
+
+	if err := c.checkRestore(bytecode); err != nil {
+		return nil, err
+	}
+
 	cm, err := c.Runtime.CompileModule(ctx, bytecode)
 	if err != nil {
 		return nil, err
 	}
 	cs = append(cs, cm)
 
+	// In serve mode, every stream runs the command in an instance of its
+	// own, so that no state is carried over between streams.
+	if c.Serve {
+		if _, ok := cm.ExportedFunctions()["_start"]; !ok {
+			return nil, errors.New("serve mode requires a command module exporting _start")
+		}
+		c.Pool.Reuse = ReuseNever
+	}
+
+	// A command that exports nothing but _start only does anything when
+	// run as a whole, which async mode never does.
+	methods := newMethods(c.Name, cm)
+	if c.Async && !c.Serve && len(methods.Methods) == 0 {
+		if _, ok := cm.ExportedFunctions()["_start"]; ok {
+			return nil, errors.New("module is a WASI command with no exports to serve in async mode; run it in sync or serve mode")
+		}
+	}
+
 	wasi, err := wasi_snapshot_preview1.Instantiate(ctx, c.Runtime)
 	if err != nil {
 		return nil, err
 	}
 	cs = append(cs, wasi)
 
+	// The run time of the process, which its children share.
+	if c.runTime == nil {
+		c.runTime = new(atomic.Int64)
+	}
+
+	// Expose granted capabilities through the "ww" host module, and refuse
+	// guests that import capabilities they were not granted.
+	host := newHostModule(c)
+	cs = append(cs, host)
+	if err := host.CheckImports(cm); err != nil {
+		return nil, err
+	}
+
+	ww, err := host.Instantiate(ctx, c.Runtime)
+	if err != nil {
+		return nil, err
+	}
+	cs = append(cs, ww)
+
 	e := c.NewEndpoint()
 	cs = append(cs, e)
 
@@ -70,16 +168,42 @@ func (c ProcConfig) New(ctx context.Context) (*Proc, error) {
 			io.Reader
 			io.WriteCloser
 		}{
-			Reader:      os.Stdin,
-			WriteCloser: os.Stdout,
+			Reader:      c.stdin(),
+			WriteCloser: c.stdout(),
 		}
 	}
 
 	// Configure module instantiation based on async mode
 	config := c.NewModuleConfig(e)
 
-	mod, err := c.Runtime.InstantiateModule(ctx, cm, config)
+	ictx, mem := c.Limits.withMemoryLimit(ctx)
+
+	// In sync mode, _start runs during instantiation, and in async mode,
+	// the initializers do.  Either is a call that the limits apply to.
+	ictx, cancel, err := c.Limits.withCallLimit(ictx, c.runTime)
 	if err != nil {
+		return nil, err
+	}
+	e.meter = meterFromContext(ictx)
+
+	// A guest blocked reading stdin is only interrupted by closing it.
+	stop := func() bool { return false }
+	if closer, ok := c.Stdin.(io.Closer); ok && !c.Async {
+		stop = context.AfterFunc(ictx, func() { _ = closer.Close() })
+	}
+
+	mod, err := c.Runtime.InstantiateModule(ictx, cm, config)
+	stop()
+	e.meter = nil
+	cancel()
+
+	var limitErr *LimitError
+	if errors.As(context.Cause(ictx), &limitErr) {
+		if mod != nil {
+			mod.Close(ctx)
+		}
+		return nil, limitErr
+	} else if err != nil {
 		// Check if the error is sys.ExitError with exit code 0 which indicates success
 		var exitErr *sys.ExitError
 		if errors.As(err, &exitErr) && exitErr.ExitCode() == 0 {
@@ -98,7 +222,63 @@ func (c ProcConfig) New(ctx context.Context) (*Proc, error) {
 	} else if mod.IsClosed() {
 		return nil, fmt.Errorf("module closed immediately after instantiation")
 	}
-	cs = append(cs, mod)
+
+	if mem != nil && mem.exceeded.Load() {
+		mod.Close(ctx)
+		return nil, &LimitError{Limit: LimitMemory}
+	}
+
+	if c.Restore != nil {
+		if err := c.Restore.restore(mod); err != nil {
+			mod.Close(ctx)
+			return nil, err
+		}
+	}
+
+	// The primary instance seeds the pool.  Additional instances are
+	// created on demand from the compiled module, each with its own socket,
+	// so that concurrent streams never share stdin/stdout or linear memory.
+	var seq atomic.Uint64
+	pool := NewPool(c.Pool, func(ctx context.Context) (*Instance, error) {
+		sock := &Endpoint{Name: fmt.Sprintf("%s.%d", e.Name, seq.Add(1))}
+		ictx, mem := c.Limits.withMemoryLimit(ctx)
+
+		// The initializers are a call like any other.
+		ictx, cancel, err := c.Limits.withCallLimit(ictx, c.runTime)
+		if err != nil {
+			return nil, err
+		}
+		sock.meter = meterFromContext(ictx)
+
+		mod, err := c.Runtime.InstantiateModule(ictx, cm, c.NewModuleConfig(sock))
+		sock.meter = nil
+		cancel()
+
+		var limitErr *LimitError
+		if errors.As(context.Cause(ictx), &limitErr) {
+			if mod != nil {
+				mod.Close(ctx)
+			}
+			return nil, limitErr
+		} else if err != nil {
+			return nil, err
+		}
+
+		if mem != nil && mem.exceeded.Load() {
+			mod.Close(ctx)
+			return nil, &LimitError{Limit: LimitMemory}
+		}
+
+		if c.Restore != nil {
+			if err := c.Restore.restore(mod); err != nil {
+				mod.Close(ctx)
+				return nil, err
+			}
+		}
+
+		return &Instance{Module: mod, Socket: sock, mem: mem, host: host}, nil
+	}, &Instance{Module: mod, Socket: e, mem: mem, host: host})
+	cs = append(cs, pool)
 
 	// Mark proc as initialized and optionally bind stream handler.
 	////
@@ -107,10 +287,56 @@ func (c ProcConfig) New(ctx context.Context) (*Proc, error) {
 		Config:   c,
 		Module:   mod,
 		Endpoint: e,
-		Closer:   cs}
+		Pool:     pool,
+		Closer:   cs,
+		runTime:  c.runTime,
+		calls:    newCalls(),
+		methods:  methods,
+		bytecode: bytecode}
 	return proc, nil
 }
 
+// checkRestore returns an error if c.Restore cannot be restored into
+// instances of bytecode.
+func (c ProcConfig) checkRestore(bytecode []byte) error {
+	switch {
+	case c.Restore == nil:
+		return nil
+	case !c.Async:
+		return errors.New("restore requires async mode")
+	case c.Serve:
+		return errors.New("restore: serve mode keeps no state")
+	case !bytes.Equal(c.Restore.Module, bytecode):
+		return errors.New("restore: checkpoint is of a different module")
+	case c.Limits.MaxMemoryPages > 0 && uint64(len(c.Restore.Memory)) > uint64(c.Limits.MaxMemoryPages)*65536:
+		return &LimitError{Limit: LimitMemory}
+	}
+	return nil
+}
+
+func (c ProcConfig) stdin() io.Reader {
+	if c.Stdin != nil {
+		return c.Stdin
+	}
+	return os.Stdin
+}
+
+func (c ProcConfig) stdout() io.WriteCloser {
+	switch w := c.Stdout.(type) {
+	case nil:
+		// The process does not own os.Stdout, so it must not close it.
+		return nopWriteCloser{os.Stdout}
+	case io.WriteCloser:
+		return w
+	default:
+		return nopWriteCloser{w}
+	}
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
 type ReadWriteStringer interface {
 	String() string
 	io.ReadWriter
@@ -124,10 +350,20 @@ func (c ProcConfig) NewModuleConfig(sock ReadWriteStringer) wazero.ModuleConfig
 		WithStdout(sock).
 		WithStderr(c.ErrWriter)
 
+	// Endpoints can forward stderr to the caller of each message.
+	if e, ok := sock.(*Endpoint); ok {
+		config = config.WithStderr(errWriter{sock: e, w: c.ErrWriter})
+	}
+
 	// async mode?
 	if c.Async {
-		// prevent _start from running automatically
-		config = config.WithStartFunctions()
+		// prevent _start from running automatically, but initialize
+		// reactors before they serve streams
+		config = config.WithStartFunctions(initFunctions...)
+	}
+
+	if len(c.Mounts) > 0 {
+		config = config.WithFSConfig(c.NewFSConfig())
 	}
 
 	// Add environment variables
@@ -140,23 +376,100 @@ func (c ProcConfig) NewModuleConfig(sock ReadWriteStringer) wazero.ModuleConfig
 	return config
 }
 
+// NewFSConfig returns the WASI filesystem configuration for c.Mounts.
+func (c ProcConfig) NewFSConfig() wazero.FSConfig {
+	config := wazero.NewFSConfig()
+	for _, m := range c.Mounts {
+		switch {
+		case m.FS != nil:
+			config = config.WithFSMount(m.FS, m.Path)
+		case m.ReadOnly:
+			config = config.WithReadOnlyDirMount(m.Dir, m.Path)
+		default:
+			config = config.WithDirMount(m.Dir, m.Path)
+		}
+	}
+	return config
+}
+
+// Mount maps a host directory or filesystem into the guest's WASI
+// filesystem.
+type Mount struct {
+	Path     string // mount point in the guest, e.g. "/data"
+	Dir      string // host directory to mount, if FS is nil
+	FS       fs.FS  // filesystem to mount; always read-only
+	ReadOnly bool   // mount Dir read-only
+}
+
 func (p ProcConfig) NewEndpoint() *Endpoint {
+	name := p.Name
+	if name == "" {
+		name = newName()
+	}
+
+	return &Endpoint{
+		Name: name,
+		sem:  semaphore.NewWeighted(int64(p.Pool.size() + p.Queue.depth())),
+	}
+}
+
+// name returns the endpoint name of a process running bytecode.  Unless
+// set explicitly, or restored from a checkpoint, it is derived from the
+// bytecode and the host's peer ID, so that it is stable across restarts.
+// Without a host, it is random.
+func (c ProcConfig) name(bytecode []byte) string {
+	switch {
+	case c.Name != "":
+		return c.Name
+	case c.Restore != nil && c.Restore.Proc != "":
+		return c.Restore.Proc
+	case c.Host != nil:
+		return DeriveName(bytecode, c.Host.ID())
+	default:
+		return newName()
+	}
+}
+
+// DeriveName returns the default endpoint name of a process running
+// bytecode on the host with the given peer ID.
+func DeriveName(bytecode []byte, id peer.ID) string {
+	h := sha256.New()
+	h.Write([]byte(id))
+	h.Write(bytecode)
+	return base58.FastBase58Encoding(h.Sum(nil)[:8])
+}
+
+// ValidName reports whether name can be used as an endpoint name, i.e. as a
+// single segment of a protocol ID.
+func ValidName(name string) bool {
+	return name != "" && name != "." && name != ".." &&
+		!strings.ContainsFunc(name, func(r rune) bool {
+			return r == '/' || r <= ' ' || r == 0x7f
+		})
+}
+
+// newName returns a random endpoint name.
+func newName() string {
 	var buf [8]byte
 	if _, err := io.ReadFull(rand.Reader, buf[:]); err != nil {
 		panic(err)
 	}
 
-	return &Endpoint{
-		Name: base58.FastBase58Encoding(buf[:]),
-		sem:  semaphore.NewWeighted(1),
-	}
+	return base58.FastBase58Encoding(buf[:])
 }
 
 type Proc struct {
 	Config   ProcConfig
 	Endpoint *Endpoint
-	Module   api.Module
+	Module   api.Module // primary instance, created by ProcConfig.New
+	Pool     *Pool      // instances serving streams in async mode
 	api.Closer
+
+	runTime *atomic.Int64 // nanoseconds spent running guest code, for Limits.RunTime
+	calls   *calls        // in progress, for Drain
+	methods Methods       // served by MethodsMethod
+
+	bytecode []byte // saved by Checkpoint
 }
 
 // ID returns the process identifier (endpoint name) without the protocol prefix.
@@ -164,9 +477,15 @@ func (p Proc) ID() string {
 	return p.Endpoint.Name
 }
 
+// Methods describes the exported functions of the process.
+func (p Proc) Methods() Methods {
+	return p.methods
+}
+
 // ProcessMessage processes one complete message synchronously.
 // In sync mode: lets _start run automatically and process one message
-// In async mode: calls the specified export function
+// In async mode: calls the specified export function, or the default
+// method if method is "".  Only the default method is served in serve mode.
 func (p Proc) ProcessMessage(ctx context.Context, s network.Stream, method string) error {
 	if deadline, ok := ctx.Deadline(); ok {
 		if err := s.SetReadDeadline(deadline); err != nil {
@@ -174,38 +493,76 @@ func (p Proc) ProcessMessage(ctx context.Context, s network.Stream, method strin
 		}
 	}
 
-	// Check if module is closed before we start
-	if p.Module.IsClosed() {
-		return fmt.Errorf("%s::ProcessMessage: module closed", p.ID())
+	// The host describes the process itself, without calling the guest.
+	if method == MethodsMethod {
+		return p.serveMethods(s)
 	}
 
-	// Set the stream as the endpoint's ReadWriteCloser for this message
-	// The Endpoint's Read/Write methods will delegate to the stream
-	p.Endpoint.ReadWriteCloser = s
-	defer func() {
-		// Reset to nil after processing this message
-		p.Endpoint.ReadWriteCloser = nil
-	}()
+	if !p.calls.enter() {
+		_ = s.ResetWithError(ErrCodeBusy)
+		return fmt.Errorf("%s::ProcessMessage: %w", p.ID(), ErrDraining)
+	}
+	defer p.calls.exit()
+
+	if method == CheckpointMethod && p.Config.Checkpoints {
+		return p.serveCheckpoint(ctx, s)
+	}
 
-	// In async mode, call the specified export function
+	// In async mode, call the specified export function on an instance
+	// that is dedicated to this stream for the duration of the call.
 	if p.Config.Async {
-		// Normalize method: if empty string, use "poll"
-		if method == "" {
+		// Admit the stream only if there is room for it in the queue.
+		if !p.Endpoint.admit() {
+			_ = s.ResetWithError(ErrCodeBusy)
+			return fmt.Errorf("%s::ProcessMessage: %w", p.ID(), ErrBusy)
+		}
+		defer p.Endpoint.done()
+
+		// A new instance may fail to initialize within the limits.
+		inst, err := p.acquire(ctx)
+		var limitErr *LimitError
+		if errors.Is(err, ErrBusy) || errors.As(err, &limitErr) {
+			_ = s.ResetWithError(ErrorCode(err))
+			return fmt.Errorf("%s::ProcessMessage: %w", p.ID(), err)
+		} else if err != nil {
+			_ = s.Reset()
+			return fmt.Errorf("%s::ProcessMessage: acquire instance: %w", p.ID(), err)
+		}
+		defer p.Pool.Release(ctx, inst)
+
+		// Set the stream as the socket's ReadWriteCloser for this message
+		// The socket's Read/Write methods will delegate to the stream
+		inst.Socket.ReadWriteCloser = s
+		inst.Socket.Stderr = stderrFromContext(ctx)
+		defer func() {
+			// Reset to nil after processing this message
+			inst.Socket.ReadWriteCloser = nil
+			inst.Socket.Stderr = nil
+		}()
+
+		// The default method is poll, or in serve mode, the command, which
+		// is the only method that serve mode exposes.
+		switch {
+		case p.Config.Serve && method != "":
+			_ = s.ResetWithError(ErrCodeUnknownMethod)
+			return fmt.Errorf("%w: %s", ErrUnknownMethod, method)
+		case p.Config.Serve:
+			method = "_start"
+		case method == "":
 			method = "poll"
 		}
 
-		exp := p.Module.ExportedFunction(method)
-		if exp == nil {
-			_ = s.Reset()
-			return fmt.Errorf("unknown method: %s", method)
+		exp := inst.Module.ExportedFunction(method)
+		if exp == nil || slices.Contains(initFunctions, method) || method == ShutdownExport {
+			_ = s.ResetWithError(ErrCodeUnknownMethod)
+			return fmt.Errorf("%w: %s", ErrUnknownMethod, method)
 		}
 
-		if err := exp.CallWithStack(ctx, nil); err != nil {
-			var exitErr *sys.ExitError
-			if errors.As(err, &exitErr) && exitErr.ExitCode() != 0 {
-				return fmt.Errorf("%s::%s: %w", p.ID(), method, err)
-			}
-			// If it's ExitError with code 0, treat as success
+		// Report failures to the caller, who would otherwise see a
+		// normal EOF.
+		if err := p.call(withCall(ctx, s, method), s, inst, exp); err != nil {
+			_ = s.ResetWithError(ErrorCode(err))
+			return fmt.Errorf("%s::%s: %w", p.ID(), method, err)
 		}
 	}
 	// In sync mode, _start already ran during module instantiation
@@ -213,6 +570,72 @@ func (p Proc) ProcessMessage(ctx context.Context, s network.Stream, method strin
 	return nil
 }
 
+// call fn on behalf of stream s, enforcing the process limits.  Instances
+// that fail are closed, so that the pool does not hand them out again.
+func (p Proc) call(ctx context.Context, s network.Stream, inst *Instance, fn api.Function) error {
+	parent, hasParent := ctx.Deadline()
+
+	ctx, cancel, err := p.Config.Limits.withCallLimit(ctx, p.runTime)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	// Time spent blocked on the stream is not charged to the process.
+	inst.Socket.meter = meterFromContext(ctx)
+	defer func() { inst.Socket.meter = nil }()
+
+	// Don't let the guest outlive its deadline while blocked on the stream.
+	if deadline, ok := ctx.Deadline(); ok && (!hasParent || deadline.Before(parent)) {
+		if err := s.SetDeadline(deadline); err != nil {
+			return fmt.Errorf("set deadline: %w", err)
+		}
+	}
+
+	// A failure to grow memory that the guest handled in an earlier call
+	// is not the cause of a trap in this one.
+	if inst.mem != nil {
+		inst.mem.exceeded.Store(false)
+	}
+
+	err = fn.CallWithStack(ctx, nil)
+	if err == nil {
+		return nil
+	}
+
+	// A limit takes precedence over the error reported by the guest, which
+	// is merely a symptom.
+	var limitErr *LimitError
+	var exitErr *sys.ExitError
+	if errors.As(context.Cause(ctx), &limitErr) {
+		err = limitErr
+	} else if inst.mem != nil && inst.mem.exceeded.Load() {
+		err = &LimitError{Limit: LimitMemory}
+	} else if errors.As(err, &exitErr) && exitErr.ExitCode() == 0 {
+		return nil // If it's ExitError with code 0, treat as success
+	}
+
+	_ = inst.Module.Close(ctx)
+	return err
+}
+
+// acquire an instance from the pool, waiting no longer than the queue
+// timeout.  It returns ErrBusy if the timeout expires.
+func (p Proc) acquire(ctx context.Context) (*Instance, error) {
+	if p.Config.Queue.Timeout <= 0 {
+		return p.Pool.Acquire(ctx)
+	}
+
+	wait, cancel := context.WithTimeout(ctx, p.Config.Queue.Timeout)
+	defer cancel()
+
+	inst, err := p.Pool.Acquire(wait)
+	if err != nil && ctx.Err() == nil && wait.Err() != nil {
+		err = fmt.Errorf("%w: timed out after %s", ErrBusy, p.Config.Queue.Timeout)
+	}
+	return inst, err
+}
+
 type CloserSlice []api.Closer
 
 func (cs CloserSlice) Close(ctx context.Context) error {
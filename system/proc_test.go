@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/peer"
 	inproc "github.com/lthibault/go-libp2p-inproc-transport"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -43,6 +44,29 @@ func loadEchoWasm(t *testing.T) []byte {
 	return wasmData
 }
 
+// newTestProc instantiates bytecode with config, on a runtime of its own
+// that enforces config.Limits.  Both are closed when the test ends.  Errors
+// go to a buffer, unless config sets ErrWriter.
+func newTestProc(t *testing.T, config system.ProcConfig, bytecode []byte) (*system.Proc, error) {
+	t.Helper()
+	ctx := context.Background()
+
+	runtime := wazero.NewRuntimeWithConfig(ctx, config.Limits.RuntimeConfig(wazero.NewRuntimeConfig()))
+	t.Cleanup(func() { runtime.Close(ctx) })
+
+	config.Runtime = runtime
+	config.Src = io.NopCloser(bytes.NewReader(bytecode))
+	if config.ErrWriter == nil {
+		config.ErrWriter = &bytes.Buffer{}
+	}
+
+	proc, err := config.New(ctx)
+	if err == nil {
+		t.Cleanup(func() { proc.Close(ctx) })
+	}
+	return proc, err
+}
+
 func TestProcConfig_New(t *testing.T) {
 	t.Parallel()
 
@@ -512,6 +536,75 @@ func TestEndpoint_Concurrency(t *testing.T) {
 	assert.NotEqual(t, endpoint1.Protocol(), endpoint2.Protocol(), "Endpoint protocols should be different")
 }
 
+func TestProcConfig_New_Name(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	bytecode := loadEchoWasm(t)
+
+	host, err := libp2p.New(libp2p.Transport(inproc.New()))
+	require.NoError(t, err)
+	defer host.Close()
+
+	newProc := func(name string) (*system.Proc, error) {
+		runtime := wazero.NewRuntime(ctx)
+		t.Cleanup(func() { runtime.Close(ctx) })
+
+		return system.ProcConfig{
+			Name:      name,
+			Host:      host,
+			Runtime:   runtime,
+			Src:       io.NopCloser(bytes.NewReader(bytecode)),
+			ErrWriter: &bytes.Buffer{},
+			Async:     true,
+		}.New(ctx)
+	}
+
+	t.Run("derived", func(t *testing.T) {
+		proc, err := newProc("")
+		require.NoError(t, err)
+		defer proc.Close(ctx)
+
+		assert.Equal(t, system.DeriveName(bytecode, host.ID()), proc.ID())
+	})
+
+	t.Run("explicit", func(t *testing.T) {
+		proc, err := newProc("echo")
+		require.NoError(t, err)
+		defer proc.Close(ctx)
+
+		assert.Equal(t, "echo", proc.ID())
+		assert.Equal(t, "/ww/0.1.0/echo", string(proc.Endpoint.Protocol()))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := newProc("echo/v2")
+		assert.ErrorContains(t, err, "invalid endpoint name")
+	})
+}
+
+func TestDeriveName(t *testing.T) {
+	t.Parallel()
+
+	name := system.DeriveName([]byte("bytecode"), peer.ID("peer"))
+	assert.Equal(t, name, system.DeriveName([]byte("bytecode"), peer.ID("peer")),
+		"name should be deterministic")
+	assert.NotEqual(t, name, system.DeriveName([]byte("other"), peer.ID("peer")))
+	assert.NotEqual(t, name, system.DeriveName([]byte("bytecode"), peer.ID("other")))
+	assert.True(t, system.ValidName(name))
+}
+
+func TestValidName(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{"echo", "my-service", "svc.v2", "3yZe7d"} {
+		assert.True(t, system.ValidName(name), name)
+	}
+	for _, name := range []string{"", ".", "..", "a/b", "a b", "a\n"} {
+		assert.False(t, system.ValidName(name), name)
+	}
+}
+
 func TestProc_Integration_WithRealWasm(t *testing.T) {
 	ctx := context.Background()
 
@@ -828,3 +921,104 @@ func TestEndpoint_NilReadWriteCloser(t *testing.T) {
 	err = endpoint.Close(context.Background())
 	assert.NoError(t, err, "Close should not return error")
 }
+
+// reactorWasm exports _initialize and init, which set a global to 12 between
+// them, and check, which traps unless the global is 12.
+var reactorWasm = []byte{
+	0x00, 0x61, 0x73, 0x6d, // WASM magic number
+	0x01, 0x00, 0x00, 0x00, // Version 1
+	0x01, 0x04, 0x01, 0x60, 0x00, 0x00, // Type section: () -> ()
+	0x03, 0x04, 0x03, 0x00, 0x00, 0x00, // Function section: 3 functions of type 0
+	0x06, 0x06, 0x01, 0x7f, 0x01, 0x41, 0x00, 0x0b, // Global section: mutable i32 = 0
+	0x07, 0x1e, 0x03, // Export section: 3 exports
+	0x0b, 0x5f, 0x69, 0x6e, 0x69, 0x74, 0x69, 0x61, 0x6c, 0x69, 0x7a, 0x65, 0x00, 0x00, // "_initialize" function 0
+	0x04, 0x69, 0x6e, 0x69, 0x74, 0x00, 0x01, // "init" function 1
+	0x05, 0x63, 0x68, 0x65, 0x63, 0x6b, 0x00, 0x02, // "check" function 2
+	0x0a, 0x27, 0x03, // Code section: 3 bodies
+	0x0c, 0x00, 0x23, 0x00, 0x41, 0x0a, 0x6c, 0x41, 0x01, 0x6a, 0x24, 0x00, 0x0b, // g = g*10 + 1
+	0x0c, 0x00, 0x23, 0x00, 0x41, 0x0a, 0x6c, 0x41, 0x02, 0x6a, 0x24, 0x00, 0x0b, // g = g*10 + 2
+	0x0b, 0x00, 0x23, 0x00, 0x41, 0x0c, 0x47, 0x04, 0x40, 0x00, 0x0b, 0x0b, // if g != 12 { unreachable }
+}
+
+func TestProcConfig_New_Reactor(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	for _, reuse := range []system.ReusePolicy{system.ReuseRecycle, system.ReuseNever} {
+		t.Run(reuse.String(), func(t *testing.T) {
+			t.Parallel()
+
+			runtime := wazero.NewRuntime(ctx)
+			defer runtime.Close(ctx)
+
+			proc, err := system.ProcConfig{
+				Runtime:   runtime,
+				Src:       io.NopCloser(bytes.NewReader(reactorWasm)),
+				ErrWriter: &bytes.Buffer{},
+				Async:     true,
+				Pool:      system.PoolConfig{Reuse: reuse},
+			}.New(ctx)
+			require.NoError(t, err)
+			defer proc.Close(ctx)
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			// Every instance is initialized exactly once, before it
+			// serves its first stream.
+			for range 3 {
+				require.NoError(t, proc.ProcessMessage(ctx, mocks.NewMockStreamInterface(ctrl), "check"))
+			}
+
+			// Initialization functions are not methods.
+			for _, method := range []string{"_initialize", "init"} {
+				s := mocks.NewMockStreamInterface(ctrl)
+				s.EXPECT().ResetWithError(system.ErrCodeUnknownMethod).Return(nil)
+				assert.ErrorIs(t, proc.ProcessMessage(ctx, s, method), system.ErrUnknownMethod)
+			}
+
+			assert.Equal(t, []system.Method{{Name: "check", Params: []string{}, Results: []string{}}},
+				proc.Methods().Methods)
+		})
+	}
+}
+
+func TestProcConfig_New_AsyncCommand(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	_, err := system.ProcConfig{
+		Runtime:   runtime,
+		Src:       io.NopCloser(bytes.NewReader(exitCommandWasm)),
+		ErrWriter: &bytes.Buffer{},
+		Async:     true,
+	}.New(ctx)
+	assert.ErrorContains(t, err, "WASI command")
+}
+
+func TestProcConfig_New_SyncKeepsHostStdout(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	// Stdout defaults to os.Stdout, which the process does not own.
+	proc, err := system.ProcConfig{
+		Runtime:   runtime,
+		Src:       io.NopCloser(bytes.NewReader(loadEchoWasm(t))),
+		Stdin:     bytes.NewReader(nil),
+		ErrWriter: &bytes.Buffer{},
+	}.New(ctx)
+	require.NoError(t, err)
+	require.NoError(t, proc.Close(ctx))
+
+	_, err = os.Stdout.Stat()
+	assert.NoError(t, err, "closing the process should not close os.Stdout")
+}
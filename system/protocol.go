@@ -0,0 +1,149 @@
+package system
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// Version of the /ww stream protocol.  Peers negotiate the version of each
+// stream through multistream-select, by offering every protocol ID returned
+// by ProtocolIDs.
+type Version string
+
+const (
+	// V0_1_0 leaves the stream open when the call returns.  Clients must
+	// know when the response is complete.
+	V0_1_0 Version = "0.1.0"
+
+	// V0_2_0 closes the server's side of the stream when the call returns
+	// successfully, so that clients can read the response until EOF.
+	V0_2_0 Version = "0.2.0"
+
+	// V0_2_0_Framed carries many messages per stream, as varint
+	// length-prefixed frames.  Each request frame is delivered to the guest
+	// as a message of its own, and answered by one response frame.  It
+	// behaves like V0_2_0 otherwise.
+	V0_2_0_Framed Version = "0.2.0-framed"
+
+	// V0_2_0_Stderr forwards the guest's stderr to the caller.  The
+	// server's output is carried in tagged frames (see TaggedStream), which
+	// are either stdout or stderr.  It behaves like V0_2_0 otherwise.
+	V0_2_0_Stderr Version = "0.2.0-stderr"
+
+	// V0_2_0_FramedStderr is V0_2_0_Framed with stderr forwarding.  Each
+	// response is a single stdout frame, preceded by the stderr frames of
+	// its call.
+	V0_2_0_FramedStderr Version = "0.2.0-framed-stderr"
+)
+
+// Versions lists the supported protocol versions, in order of preference.
+var Versions = []Version{V0_2_0, V0_1_0}
+
+// FramedVersions lists the supported framed protocol versions, in order of
+// preference.  Framing is opt-in, so they are not part of Versions.
+var FramedVersions = []Version{V0_2_0_Framed}
+
+// StderrVersions and FramedStderrVersions list the supported protocol
+// versions that forward stderr, in order of preference.  Like framing,
+// forwarding stderr is opt-in.
+var (
+	StderrVersions       = []Version{V0_2_0_Stderr}
+	FramedStderrVersions = []Version{V0_2_0_FramedStderr}
+)
+
+// Supported reports whether v is listed in Versions, FramedVersions,
+// StderrVersions or FramedStderrVersions.
+func (v Version) Supported() bool {
+	return slices.Contains(Versions, v) || v.Framed() || v.Stderr()
+}
+
+// Framed reports whether v carries length-prefixed request frames.
+func (v Version) Framed() bool {
+	return slices.Contains(FramedVersions, v) || slices.Contains(FramedStderrVersions, v)
+}
+
+// Stderr reports whether v forwards the guest's stderr to the caller, in
+// tagged frames.
+func (v Version) Stderr() bool {
+	return slices.Contains(StderrVersions, v) || slices.Contains(FramedStderrVersions, v)
+}
+
+// CloseWrite reports whether the server closes its side of the stream once
+// the call returns.
+func (v Version) CloseWrite() bool {
+	return v != V0_1_0
+}
+
+// ProtocolID returns the libp2p protocol ID for calling method on the named
+// process, under version v.  The default method, "poll", is addressed by
+// the bare process protocol.
+func (v Version) ProtocolID(proc, method string) protocol.ID {
+	id := protocol.ID("/ww/" + string(v) + "/" + proc)
+	if method != "" && method != "poll" {
+		id += protocol.ID("/" + method)
+	}
+	return id
+}
+
+// ProtocolID returns the libp2p protocol ID for calling method on the named
+// process under the original version, V0_1_0.  Use ProtocolIDs to negotiate
+// the version.
+func ProtocolID(proc, method string) protocol.ID {
+	return V0_1_0.ProtocolID(proc, method)
+}
+
+// ProtocolIDs returns the libp2p protocol IDs for calling method on the
+// named process, one per supported version, in order of preference.
+func ProtocolIDs(proc, method string) []protocol.ID {
+	return protocolIDs(Versions, proc, method)
+}
+
+// FramedProtocolIDs is like ProtocolIDs, for framed versions.
+func FramedProtocolIDs(proc, method string) []protocol.ID {
+	return protocolIDs(FramedVersions, proc, method)
+}
+
+// StderrProtocolIDs is like ProtocolIDs, for versions that forward stderr.
+func StderrProtocolIDs(proc, method string) []protocol.ID {
+	return protocolIDs(StderrVersions, proc, method)
+}
+
+// FramedStderrProtocolIDs is like ProtocolIDs, for framed versions that
+// forward stderr.
+func FramedStderrProtocolIDs(proc, method string) []protocol.ID {
+	return protocolIDs(FramedStderrVersions, proc, method)
+}
+
+func protocolIDs(vs []Version, proc, method string) []protocol.ID {
+	ids := make([]protocol.ID, len(vs))
+	for i, v := range vs {
+		ids[i] = v.ProtocolID(proc, method)
+	}
+	return ids
+}
+
+// ParseProtocol splits a protocol ID of the form /ww/<version>/<proc>[/<method>]
+// into its parts.  The method defaults to "poll".  Unsupported versions are
+// an error.
+func ParseProtocol(id protocol.ID) (v Version, proc, method string, err error) {
+	parts := strings.Split(string(id), "/")
+	if len(parts) < 4 || len(parts) > 5 || parts[0] != "" || parts[1] != "ww" || parts[3] == "" {
+		return "", "", "", fmt.Errorf("invalid protocol %q: expected /ww/<version>/<proc>[/<method>]", id)
+	}
+
+	v, proc, method = Version(parts[2]), parts[3], "poll"
+	if !v.Supported() {
+		return "", "", "", fmt.Errorf("invalid protocol %q: unsupported version %s", id, v)
+	}
+
+	if len(parts) == 5 {
+		if method = parts[4]; method == "" {
+			return "", "", "", fmt.Errorf("invalid protocol %q: empty method", id)
+		}
+	}
+
+	return v, proc, method, nil
+}
@@ -0,0 +1,120 @@
+package system_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wetware/go/system"
+)
+
+func TestParseProtocol(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		id      protocol.ID
+		version system.Version
+		proc    string
+		method  string
+		err     string
+	}{
+		{id: "/ww/0.1.0/echo", version: system.V0_1_0, proc: "echo", method: "poll"},
+		{id: "/ww/0.2.0/echo/greet", version: system.V0_2_0, proc: "echo", method: "greet"},
+		{id: "/ww/0.2.0-framed/echo", version: system.V0_2_0_Framed, proc: "echo", method: "poll"},
+		{id: "/ww/0.2.0-stderr/echo", version: system.V0_2_0_Stderr, proc: "echo", method: "poll"},
+		{id: "/ww/0.2.0-framed-stderr/echo/greet", version: system.V0_2_0_FramedStderr, proc: "echo", method: "greet"},
+		{id: "/ww/9.9.9/echo", err: "unsupported version"},
+		{id: "/ww/0.2.0/echo/", err: "empty method"},
+		{id: "/ww/0.2.0", err: "expected /ww/<version>/<proc>[/<method>]"},
+		{id: "/ww/0.2.0/echo/greet/extra", err: "expected /ww/<version>/<proc>[/<method>]"},
+		{id: "/ipfs/id/1.0.0/x", err: "expected /ww/<version>/<proc>[/<method>]"},
+	} {
+		t.Run(string(tt.id), func(t *testing.T) {
+			v, proc, method, err := system.ParseProtocol(tt.id)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.version, v)
+			assert.Equal(t, tt.proc, proc)
+			assert.Equal(t, tt.method, method)
+		})
+	}
+}
+
+func TestProtocolIDs(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []protocol.ID{"/ww/0.2.0/proc/echo", "/ww/0.1.0/proc/echo"},
+		system.ProtocolIDs("proc", "echo"))
+
+	for _, id := range system.ProtocolIDs("proc", "") {
+		_, proc, method, err := system.ParseProtocol(id)
+		require.NoError(t, err)
+		assert.Equal(t, "proc", proc)
+		assert.Equal(t, "poll", method)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		v              system.Version
+		framed, stderr bool
+	}{
+		{v: system.V0_1_0},
+		{v: system.V0_2_0},
+		{v: system.V0_2_0_Framed, framed: true},
+		{v: system.V0_2_0_Stderr, stderr: true},
+		{v: system.V0_2_0_FramedStderr, framed: true, stderr: true},
+	} {
+		t.Run(string(tt.v), func(t *testing.T) {
+			assert.True(t, tt.v.Supported())
+			assert.Equal(t, tt.framed, tt.v.Framed())
+			assert.Equal(t, tt.stderr, tt.v.Stderr())
+		})
+	}
+}
+
+func TestProtocol_Negotiation(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	mn, err := mocknet.FullMeshLinked(2)
+	require.NoError(t, err)
+	defer mn.Close()
+	server, client := mn.Hosts()[0], mn.Hosts()[1]
+
+	handler := func(s network.Stream) { s.Close() }
+	negotiate := func(t *testing.T, id peer.ID, proc string) protocol.ID {
+		s, err := client.NewStream(ctx, id, system.ProtocolIDs(proc, "")...)
+		require.NoError(t, err)
+		defer s.Close()
+		return s.Protocol()
+	}
+
+	t.Run("newest", func(t *testing.T) {
+		server.SetStreamHandlerMatch(system.ProtocolIDs("new", "")[0], func(id protocol.ID) bool {
+			_, proc, _, err := system.ParseProtocol(id)
+			return err == nil && proc == "new"
+		}, handler)
+
+		assert.Equal(t, system.V0_2_0.ProtocolID("new", ""), negotiate(t, server.ID(), "new"))
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		// An older peer only speaks 0.1.0.
+		server.SetStreamHandler(system.V0_1_0.ProtocolID("old", ""), handler)
+
+		assert.Equal(t, system.V0_1_0.ProtocolID("old", ""), negotiate(t, server.ID(), "old"))
+	})
+}
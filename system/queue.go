@@ -0,0 +1,39 @@
+package system
+
+import (
+	"errors"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+// DefaultQueueDepth is the number of streams that may wait for a module
+// instance when QueueConfig.Depth is zero.
+const DefaultQueueDepth = 64
+
+// ErrBusy is returned by Proc.ProcessMessage when a stream is rejected
+// because the process is overloaded.
+var ErrBusy = errors.New("busy")
+
+// Stream error codes sent to the remote peer when a stream is reset.
+const (
+	ErrCodeBusy network.StreamErrorCode = 0x1001 // process overloaded
+)
+
+// QueueConfig bounds the number of streams waiting for a module instance.
+// Streams in excess of PoolConfig.Size + Depth are reset immediately with
+// ErrCodeBusy, as are streams that have waited longer than Timeout.
+type QueueConfig struct {
+	Depth   int           // max waiting streams; 0 means DefaultQueueDepth, < 0 disables queueing
+	Timeout time.Duration // max time a stream waits for an instance; 0 means no limit
+}
+
+func (c QueueConfig) depth() int {
+	switch {
+	case c.Depth == 0:
+		return DefaultQueueDepth
+	case c.Depth < 0:
+		return 0
+	}
+	return c.Depth
+}
@@ -0,0 +1,84 @@
+package system_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wetware/go/system"
+	"github.com/wetware/go/system/mocks"
+	"go.uber.org/mock/gomock"
+)
+
+func TestQueue_RejectsExcessStreams(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	proc, err := newTestProc(t, system.ProcConfig{
+		Async: true,
+		Queue: system.QueueConfig{Depth: -1},
+	}, loadEchoWasm(t))
+	require.NoError(t, err)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// Occupy the only instance with a stream that blocks before EOF.
+	release := make(chan struct{})
+	var out bytes.Buffer
+	errc := make(chan error, 1)
+	go func() {
+		errc <- proc.ProcessMessage(ctx, echoStream(ctrl, "hello\n", release, &out), "echo")
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// With queueing disabled, the next stream is rejected immediately.
+	rejected := mocks.NewMockStreamInterface(ctrl)
+	rejected.EXPECT().ResetWithError(system.ErrCodeBusy).Return(nil)
+
+	err = proc.ProcessMessage(ctx, rejected, "echo")
+	assert.ErrorIs(t, err, system.ErrBusy)
+
+	close(release)
+	require.NoError(t, <-errc)
+	assert.Equal(t, "hello\n", out.String())
+}
+
+func TestQueue_Timeout(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	proc, err := newTestProc(t, system.ProcConfig{
+		Async: true,
+		Queue: system.QueueConfig{
+			Depth:   1,
+			Timeout: 50 * time.Millisecond,
+		},
+	}, loadEchoWasm(t))
+	require.NoError(t, err)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	release := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() {
+		errc <- proc.ProcessMessage(ctx, echoStream(ctrl, "hello\n", release, &bytes.Buffer{}), "echo")
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// The second stream is queued, but gives up after the timeout.
+	waiting := mocks.NewMockStreamInterface(ctrl)
+	waiting.EXPECT().ResetWithError(system.ErrCodeBusy).Return(nil)
+
+	start := time.Now()
+	err = proc.ProcessMessage(ctx, waiting, "echo")
+	assert.ErrorIs(t, err, system.ErrBusy)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	close(release)
+	require.NoError(t, <-errc)
+}
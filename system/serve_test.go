@@ -0,0 +1,103 @@
+package system_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wetware/go/system"
+	"github.com/wetware/go/system/mocks"
+	"go.uber.org/mock/gomock"
+)
+
+// exitCommandWasm is a command whose _start exits with code 3.
+var exitCommandWasm = []byte{
+	0x00, 0x61, 0x73, 0x6d, // WASM magic number
+	0x01, 0x00, 0x00, 0x00, // Version 1
+	0x01, 0x08, 0x02, // Type section: 2 types
+	0x60, 0x01, 0x7f, 0x00, // (i32) -> ()
+	0x60, 0x00, 0x00, // () -> ()
+	0x02, 0x24, 0x01, // Import section: 1 import
+	0x16, 0x77, 0x61, 0x73, 0x69, 0x5f, 0x73, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x5f, 0x70, 0x72, 0x65, 0x76, 0x69, 0x65, 0x77, 0x31, // "wasi_snapshot_preview1"
+	0x09, 0x70, 0x72, 0x6f, 0x63, 0x5f, 0x65, 0x78, 0x69, 0x74, 0x00, 0x00, // "proc_exit" type 0
+	0x03, 0x02, 0x01, 0x01, // Function section: 1 function of type 1
+	0x05, 0x03, 0x01, 0x00, 0x01, // Memory section: 1 memory, min 1 page
+	0x07, 0x13, 0x02, // Export section: 2 exports
+	0x06, 0x6d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x02, 0x00, // "memory" memory 0
+	0x06, 0x5f, 0x73, 0x74, 0x61, 0x72, 0x74, 0x00, 0x01, // "_start" function 1
+	0x0a, 0x08, 0x01, // Code section: 1 body
+	0x06, 0x00, 0x41, 0x03, 0x10, 0x00, 0x0b, // proc_exit(3)
+}
+
+func TestProcConfig_Serve(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	proc, err := newTestProc(t, system.ProcConfig{
+		Async: true,
+		Serve: true,
+		Pool:  system.PoolConfig{Reuse: system.ReuseRecycle},
+	}, loadEchoWasm(t))
+	require.NoError(t, err)
+
+	assert.Equal(t, system.ReuseNever, proc.Config.Pool.Reuse,
+		"instances should never be reused")
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	eof := make(chan struct{})
+	close(eof)
+
+	// Every stream runs main() in a fresh instance.
+	for _, msg := range []string{"hello", "world"} {
+		var out bytes.Buffer
+		require.NoError(t, proc.ProcessMessage(ctx, echoStream(ctrl, msg, eof, &out), ""))
+		assert.Equal(t, msg, out.String())
+	}
+	assert.True(t, proc.Module.IsClosed(), "primary instance should be discarded")
+
+	// Only the default method is served, even if the module exports others.
+	for _, method := range []string{"poll", "echo", "_start", "missing"} {
+		s := mocks.NewMockStreamInterface(ctrl)
+		s.EXPECT().ResetWithError(system.ErrCodeUnknownMethod).Return(nil)
+		assert.ErrorIs(t, proc.ProcessMessage(ctx, s, method), system.ErrUnknownMethod, method)
+	}
+}
+
+func TestProcConfig_Serve_RequiresStart(t *testing.T) {
+	t.Parallel()
+
+	_, err := newTestProc(t, system.ProcConfig{Async: true, Serve: true}, limitsWasm)
+	assert.ErrorContains(t, err, "_start")
+}
+
+func TestSupervisor_Serve(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sup := &system.Supervisor{Policy: system.RestartNever}
+	svc := startService(t, sup, system.ProcConfig{Async: true, Serve: true}, exitCommandWasm)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// A non-zero exit fails the stream, but not the service.
+	for range 2 {
+		s := mocks.NewMockStreamInterface(ctrl)
+		s.EXPECT().ResetWithError(system.ErrCodeExit + 3).Return(nil)
+		require.Error(t, svc.ProcessMessage(ctx, s, ""))
+	}
+
+	select {
+	case <-svc.Done():
+		t.Fatal("service should keep running")
+	case <-time.After(10 * time.Millisecond):
+	}
+	assert.NotNil(t, svc.Proc())
+	assert.Zero(t, svc.Restarts())
+}
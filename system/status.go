@@ -0,0 +1,103 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// Stream error codes that report the outcome of a failed call to the remote
+// peer.  A call that succeeds closes the stream normally.
+const (
+	ErrCodeUnknownMethod network.StreamErrorCode = 0x1004 // no such export
+	ErrCodeTrap          network.StreamErrorCode = 0x1005 // guest trapped
+
+	// ErrCodeExit is the base of the codes sent when the guest exits with a
+	// non-zero code, which is added to it.  Exit codes above 255 are
+	// reported as 255.
+	ErrCodeExit network.StreamErrorCode = 0x1100
+)
+
+// ErrorCode returns the stream error code that reports err to the remote
+// peer, or 0 if err is nil or a clean exit.
+func ErrorCode(err error) network.StreamErrorCode {
+	var limitErr *LimitError
+	var exitErr *sys.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, ErrBusy), errors.Is(err, ErrStopped):
+		return ErrCodeBusy
+	case errors.Is(err, ErrDenied):
+		return ErrCodeDenied
+	case errors.Is(err, ErrUnknownMethod):
+		return ErrCodeUnknownMethod
+	case errors.Is(err, ErrMoved):
+		return ErrCodeMoved
+	case errors.As(err, &limitErr):
+		return ErrCodeLimit
+	case errors.As(err, &exitErr) && canceled(exitErr):
+		// The host stopped the call, not the guest.
+		return ErrCodeBusy
+	case errors.As(err, &exitErr):
+		if exitErr.ExitCode() == 0 {
+			return 0
+		}
+		return ErrCodeExit + network.StreamErrorCode(min(exitErr.ExitCode(), 0xff))
+	default:
+		return ErrCodeTrap
+	}
+}
+
+// ExitCode returns the exit status with which a command should report a
+// call whose stream was reset with code.  Guest exit codes are passed
+// through; other failures map to conventional shell statuses.
+func ExitCode(code network.StreamErrorCode) int {
+	switch {
+	case code > ErrCodeExit && code <= ErrCodeExit+0xff:
+		return int(code - ErrCodeExit)
+	case code == ErrCodeBusy, code == ErrCodeMoved:
+		return 75 // EX_TEMPFAIL
+	case code == ErrCodeDenied:
+		return 77 // EX_NOPERM
+	case code == ErrCodeUnknownMethod:
+		return 127 // command not found
+	case code == ErrCodeTrap:
+		return 134 // 128 + SIGABRT
+	case code == ErrCodeLimit:
+		return 137 // 128 + SIGKILL
+	default:
+		return 1
+	}
+}
+
+// canceled reports whether the guest was closed because the context of
+// its call was done, which wazero reports as an exit.
+func canceled(err *sys.ExitError) bool {
+	return err.ExitCode() == sys.ExitCodeContextCanceled ||
+		err.ExitCode() == sys.ExitCodeDeadlineExceeded
+}
+
+// StatusText describes the outcome reported by a stream error code.
+func StatusText(code network.StreamErrorCode) string {
+	switch {
+	case code > ErrCodeExit && code <= ErrCodeExit+0xff:
+		return fmt.Sprintf("exited with code %d", code-ErrCodeExit)
+	case code == ErrCodeBusy:
+		return ErrBusy.Error()
+	case code == ErrCodeDenied:
+		return ErrDenied.Error()
+	case code == ErrCodeUnknownMethod:
+		return ErrUnknownMethod.Error()
+	case code == ErrCodeMoved:
+		return ErrMoved.Error()
+	case code == ErrCodeTrap:
+		return "trapped"
+	case code == ErrCodeLimit:
+		return "limit exceeded"
+	default:
+		return fmt.Sprintf("stream reset (code 0x%x)", uint32(code))
+	}
+}
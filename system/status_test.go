@@ -0,0 +1,133 @@
+package system_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/sys"
+	"github.com/wetware/go/system"
+	"github.com/wetware/go/system/mocks"
+	"go.uber.org/mock/gomock"
+)
+
+// exitWasm exports "fail", which exits with code 3, and "done", which exits
+// with code 0.
+var exitWasm = []byte{
+	0x00, 0x61, 0x73, 0x6d, // WASM magic number
+	0x01, 0x00, 0x00, 0x00, // Version 1
+	0x01, 0x08, 0x02, // Type section: 2 types
+	0x60, 0x01, 0x7f, 0x00, // (i32) -> ()
+	0x60, 0x00, 0x00, // () -> ()
+	0x02, 0x24, 0x01, // Import section: 1 import
+	0x16, 0x77, 0x61, 0x73, 0x69, 0x5f, 0x73, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x5f, 0x70, 0x72, 0x65, 0x76, 0x69, 0x65, 0x77, 0x31, // "wasi_snapshot_preview1"
+	0x09, 0x70, 0x72, 0x6f, 0x63, 0x5f, 0x65, 0x78, 0x69, 0x74, 0x00, 0x00, // "proc_exit" type 0
+	0x03, 0x03, 0x02, 0x01, 0x01, // Function section: 2 functions of type 1
+	0x05, 0x03, 0x01, 0x00, 0x01, // Memory section: 1 memory, min 1 page
+	0x07, 0x18, 0x03, // Export section: 3 exports
+	0x06, 0x6d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x02, 0x00, // "memory" memory 0
+	0x04, 0x66, 0x61, 0x69, 0x6c, 0x00, 0x01, // "fail" function 1
+	0x04, 0x64, 0x6f, 0x6e, 0x65, 0x00, 0x02, // "done" function 2
+	0x0a, 0x0f, 0x02, // Code section: 2 bodies
+	0x06, 0x00, 0x41, 0x03, 0x10, 0x00, 0x0b, // proc_exit(3)
+	0x06, 0x00, 0x41, 0x00, 0x10, 0x00, 0x0b, // proc_exit(0)
+}
+
+func TestProcessMessage_ExitStatus(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	newProc := func(t *testing.T) *system.Proc {
+		runtime := wazero.NewRuntime(ctx)
+		t.Cleanup(func() { runtime.Close(ctx) })
+
+		proc, err := system.ProcConfig{
+			Runtime:   runtime,
+			Src:       io.NopCloser(bytes.NewReader(exitWasm)),
+			ErrWriter: &bytes.Buffer{},
+			Async:     true,
+		}.New(ctx)
+		require.NoError(t, err)
+		t.Cleanup(func() { proc.Close(ctx) })
+		return proc
+	}
+
+	t.Run("NonZero", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		s := mocks.NewMockStreamInterface(ctrl)
+		s.EXPECT().ResetWithError(system.ErrCodeExit + 3).Return(nil)
+
+		var exitErr *sys.ExitError
+		err := newProc(t).ProcessMessage(ctx, s, "fail")
+		require.ErrorAs(t, err, &exitErr)
+		assert.Equal(t, uint32(3), exitErr.ExitCode())
+	})
+
+	t.Run("Zero", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		// A clean exit closes the stream normally.
+		s := mocks.NewMockStreamInterface(ctrl)
+		require.NoError(t, newProc(t).ProcessMessage(ctx, s, "done"))
+	})
+
+	t.Run("UnknownMethod", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		s := mocks.NewMockStreamInterface(ctrl)
+		s.EXPECT().ResetWithError(system.ErrCodeUnknownMethod).Return(nil)
+
+		err := newProc(t).ProcessMessage(ctx, s, "missing")
+		require.ErrorIs(t, err, system.ErrUnknownMethod)
+	})
+}
+
+func TestErrorCode(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name string
+		err  error
+		code network.StreamErrorCode
+		exit int
+	}{
+		{"Nil", nil, 0, 1},
+		{"CleanExit", sys.NewExitError(0), 0, 1},
+		{"Exit", fmt.Errorf("proc::poll: %w", sys.NewExitError(42)), system.ErrCodeExit + 42, 42},
+		{"LargeExit", sys.NewExitError(1000), system.ErrCodeExit + 255, 255},
+		{"Canceled", sys.NewExitError(sys.ExitCodeContextCanceled), system.ErrCodeBusy, 75},
+		{"DeadlineExceeded", sys.NewExitError(sys.ExitCodeDeadlineExceeded), system.ErrCodeBusy, 75},
+		{"Busy", system.ErrBusy, system.ErrCodeBusy, 75},
+		{"Stopped", system.ErrStopped, system.ErrCodeBusy, 75},
+		{"Denied", system.ErrDenied, system.ErrCodeDenied, 77},
+		{"UnknownMethod", system.ErrUnknownMethod, system.ErrCodeUnknownMethod, 127},
+		{"Moved", system.ErrMoved, system.ErrCodeMoved, 75},
+		{"Limit", &system.LimitError{Limit: system.LimitMemory}, system.ErrCodeLimit, 137},
+		{"Trap", errors.New("wasm error: unreachable"), system.ErrCodeTrap, 134},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			code := system.ErrorCode(tt.err)
+			assert.Equal(t, tt.code, code)
+			assert.Equal(t, tt.exit, system.ExitCode(code))
+			assert.NotEmpty(t, system.StatusText(code))
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package system
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+// Tags of the frames sent by the server under protocol versions that
+// forward stderr (see Version.Stderr).  Each frame is varint
+// length-prefixed, and its first byte is the tag.
+const (
+	TagStdout byte = 1
+	TagStderr byte = 2
+)
+
+// MaxTaggedFrameSize bounds tagged frames, which carry a tag in addition to
+// at most MaxFrameSize bytes of output.
+const MaxTaggedFrameSize = MaxFrameSize + 1
+
+// TaggedStream multiplexes the output of a call onto a stream, as tagged
+// frames.  Writes are sent as TagStdout frames, and writes to Stderr as
+// TagStderr frames.  Reads are passed through.
+type TaggedStream struct {
+	network.Stream
+	mu sync.Mutex
+}
+
+// NewTaggedStream wraps s, whose protocol version forwards stderr.
+func NewTaggedStream(s network.Stream) *TaggedStream {
+	return &TaggedStream{Stream: s}
+}
+
+// Write sends p as one or more TagStdout frames.
+func (s *TaggedStream) Write(p []byte) (int, error) {
+	return s.write(TagStdout, p)
+}
+
+// WriteMsg sends msg as a single TagStdout frame, even if it is empty.
+// ServeFramed uses it to send response frames.
+func (s *TaggedStream) WriteMsg(msg []byte) error {
+	if len(msg) > MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.writeFrame(TagStdout, msg)
+}
+
+// Stderr returns a writer that sends TagStderr frames.
+func (s *TaggedStream) Stderr() io.Writer {
+	return stderrWriter{s}
+}
+
+type stderrWriter struct{ s *TaggedStream }
+
+func (w stderrWriter) Write(p []byte) (int, error) {
+	return w.s.write(TagStderr, p)
+}
+
+func (s *TaggedStream) write(tag byte, p []byte) (n int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(p) > 0 {
+		chunk := p[:min(len(p), MaxFrameSize)]
+		if err = s.writeFrame(tag, chunk); err != nil {
+			return
+		}
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return
+}
+
+// writeFrame sends a tagged frame.  Callers must hold s.mu.
+func (s *TaggedStream) writeFrame(tag byte, b []byte) error {
+	buf := make([]byte, 0, binary.MaxVarintLen64+1+len(b))
+	buf = binary.AppendUvarint(buf, uint64(len(b)+1))
+	buf = append(buf, tag)
+	buf = append(buf, b...)
+
+	_, err := s.Stream.Write(buf)
+	return err
+}
+
+type stderrKey struct{}
+
+// WithStderr returns a context in which calls forward the guest's stderr to
+// w, in addition to ProcConfig.ErrWriter.
+func WithStderr(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, stderrKey{}, w)
+}
+
+func stderrFromContext(ctx context.Context) io.Writer {
+	w, _ := ctx.Value(stderrKey{}).(io.Writer)
+	return w
+}
+
+// errWriter is the stderr of an instance.  It copies the output to the
+// writer of the call being served, if any, and to ProcConfig.ErrWriter.
+type errWriter struct {
+	sock *Endpoint
+	w    io.Writer // nil discards
+}
+
+func (w errWriter) Write(p []byte) (int, error) {
+	if w.sock.Stderr != nil {
+		// The caller may have gone away, which must not fail the guest.
+		_, _ = w.sock.Stderr.Write(p)
+	}
+
+	if w.w == nil {
+		return len(p), nil
+	}
+	return w.w.Write(p)
+}
@@ -0,0 +1,185 @@
+package system_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/libp2p/go-msgio"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tetratelabs/wazero"
+	"github.com/wetware/go/system"
+)
+
+// stderrWasm exports "warn", which writes "oops\n" to stderr, then "ok\n"
+// to stdout.
+var stderrWasm = []byte{
+	0x00, 0x61, 0x73, 0x6d, // WASM magic number
+	0x01, 0x00, 0x00, 0x00, // Version 1
+	0x01, 0x0c, 0x02, // Type section: 2 types
+	0x60, 0x04, 0x7f, 0x7f, 0x7f, 0x7f, 0x01, 0x7f, // (i32, i32, i32, i32) -> i32
+	0x60, 0x00, 0x00, // () -> ()
+	0x02, 0x23, 0x01, // Import section: 1 import
+	0x16, 0x77, 0x61, 0x73, 0x69, 0x5f, 0x73, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x5f, 0x70, 0x72, 0x65, 0x76, 0x69, 0x65, 0x77, 0x31, // "wasi_snapshot_preview1"
+	0x08, 0x66, 0x64, 0x5f, 0x77, 0x72, 0x69, 0x74, 0x65, 0x00, 0x00, // "fd_write" type 0
+	0x03, 0x02, 0x01, 0x01, // Function section: 1 function of type 1
+	0x05, 0x03, 0x01, 0x00, 0x01, // Memory section: 1 memory, min 1 page
+	0x07, 0x11, 0x02, // Export section: 2 exports
+	0x06, 0x6d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x02, 0x00, // "memory" memory 0
+	0x04, 0x77, 0x61, 0x72, 0x6e, 0x00, 0x01, // "warn" function 1
+	0x0a, 0x1c, 0x01, // Code section: 1 body
+	0x1a, 0x00,
+	0x41, 0x02, 0x41, 0x00, 0x41, 0x01, 0x41, 0xc0, 0x00, 0x10, 0x00, 0x1a, // fd_write(2, 0, 1, 64)
+	0x41, 0x01, 0x41, 0x08, 0x41, 0x01, 0x41, 0xc0, 0x00, 0x10, 0x00, 0x1a, // fd_write(1, 8, 1, 64)
+	0x0b,
+	0x0b, 0x2d, 0x04, // Data section: 4 segments
+	0x00, 0x41, 0x00, 0x0b, 0x08, 0x10, 0x00, 0x00, 0x00, 0x05, 0x00, 0x00, 0x00, // iovec {16, 5} at 0
+	0x00, 0x41, 0x08, 0x0b, 0x08, 0x20, 0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00, // iovec {32, 3} at 8
+	0x00, 0x41, 0x10, 0x0b, 0x05, 0x6f, 0x6f, 0x70, 0x73, 0x0a, // "oops\n" at 16
+	0x00, 0x41, 0x20, 0x0b, 0x03, 0x6f, 0x6b, 0x0a, // "ok\n" at 32
+}
+
+// bufStream is a stream whose writes are buffered.
+type bufStream struct {
+	network.Stream
+	buf bytes.Buffer
+}
+
+func (s *bufStream) Write(p []byte) (int, error) { return s.buf.Write(p) }
+
+func TestTaggedStream(t *testing.T) {
+	t.Parallel()
+
+	var buf bufStream
+	s := system.NewTaggedStream(&buf)
+
+	_, err := io.WriteString(s, "out")
+	require.NoError(t, err)
+	_, err = io.WriteString(s.Stderr(), "err")
+	require.NoError(t, err)
+	require.NoError(t, s.WriteMsg(nil))
+
+	// Writes larger than a frame are split.
+	big := strings.Repeat("x", system.MaxFrameSize+1)
+	n, err := io.WriteString(s, big)
+	require.NoError(t, err)
+	assert.Equal(t, len(big), n)
+
+	assert.ErrorIs(t, s.WriteMsg([]byte(big)), system.ErrFrameTooLarge)
+
+	r := msgio.NewVarintReaderSize(&buf.buf, system.MaxTaggedFrameSize)
+	for _, want := range []string{
+		"\x01out",
+		"\x02err",
+		"\x01",
+		"\x01" + big[:system.MaxFrameSize],
+		"\x01x",
+	} {
+		got, err := r.ReadMsg()
+		require.NoError(t, err)
+		assert.Equal(t, want, string(got))
+	}
+
+	_, err = r.ReadMsg()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestProcessMessage_Stderr(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	var errWriter bytes.Buffer
+	proc, err := system.ProcConfig{
+		Runtime:   runtime,
+		Src:       io.NopCloser(bytes.NewReader(stderrWasm)),
+		ErrWriter: &errWriter,
+		Async:     true,
+	}.New(ctx)
+	require.NoError(t, err)
+	defer proc.Close(ctx)
+
+	mn, err := mocknet.FullMeshLinked(2)
+	require.NoError(t, err)
+	defer mn.Close()
+	server, client := mn.Hosts()[0], mn.Hosts()[1]
+
+	errs := make(chan error, 1)
+	server.SetStreamHandlerMatch(system.V0_2_0_Stderr.ProtocolID(proc.ID(), ""), func(id protocol.ID) bool {
+		v, _, _, err := system.ParseProtocol(id)
+		return err == nil && v.Stderr()
+	}, func(s network.Stream) {
+		defer s.Close()
+
+		v, _, method, _ := system.ParseProtocol(s.Protocol())
+		ts := system.NewTaggedStream(s)
+		ctx := system.WithStderr(ctx, ts.Stderr())
+		if v.Framed() {
+			errs <- system.ServeFramed(ctx, ts, method, proc.ProcessMessage)
+		} else {
+			errs <- proc.ProcessMessage(ctx, ts, method)
+		}
+	})
+
+	recv := func(s network.Stream) (frames []string, err error) {
+		r := msgio.NewVarintReaderSize(s, system.MaxTaggedFrameSize)
+		for {
+			msg, err := r.ReadMsg()
+			if err == io.EOF {
+				return frames, nil
+			} else if err != nil {
+				return frames, err
+			}
+			frames = append(frames, string(msg))
+		}
+	}
+
+	t.Run("Stream", func(t *testing.T) {
+		s, err := client.NewStream(ctx, server.ID(), system.StderrProtocolIDs(proc.ID(), "warn")...)
+		require.NoError(t, err)
+		defer s.Close()
+		require.NoError(t, s.CloseWrite())
+
+		frames, err := recv(s)
+		require.NoError(t, err)
+		require.NoError(t, <-errs)
+		assert.Equal(t, []string{"\x02oops\n", "\x01ok\n"}, frames)
+	})
+
+	t.Run("Framed", func(t *testing.T) {
+		s, err := client.NewStream(ctx, server.ID(), system.FramedStderrProtocolIDs(proc.ID(), "warn")...)
+		require.NoError(t, err)
+		defer s.Close()
+
+		// Read responses while sending requests, as the server does not
+		// buffer them.
+		var frames []string
+		done := make(chan error, 1)
+		go func() {
+			var err error
+			frames, err = recv(s)
+			done <- err
+		}()
+
+		w := msgio.NewVarintWriter(s)
+		require.NoError(t, w.WriteMsg([]byte("one")))
+		require.NoError(t, w.WriteMsg([]byte("two")))
+		require.NoError(t, s.CloseWrite())
+
+		require.NoError(t, <-done)
+		require.NoError(t, <-errs)
+		assert.Equal(t, []string{"\x02oops\n", "\x01ok\n", "\x02oops\n", "\x01ok\n"}, frames)
+	})
+
+	// Diagnostics are still written to the server's stderr.
+	assert.Equal(t, strings.Repeat("oops\n", 3), errWriter.String())
+}
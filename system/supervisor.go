@@ -0,0 +1,554 @@
+package system
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/tetratelabs/wazero/sys"
+	"go.uber.org/multierr"
+)
+
+// ErrStopped is returned for streams sent to a service that has exited and
+// will not be restarted.
+var ErrStopped = errors.New("stopped")
+
+// RestartPolicy determines whether a supervised process is restarted after
+// it exits.
+type RestartPolicy uint8
+
+const (
+	RestartNever     RestartPolicy = iota // never restart
+	RestartOnFailure                      // restart after a failed run; see Supervisor.MaxFailures
+	RestartAlways                         // restart after any exit
+)
+
+// ParseRestartPolicy parses "never", "on-failure" or "always".
+func ParseRestartPolicy(s string) (RestartPolicy, error) {
+	for _, p := range []RestartPolicy{RestartNever, RestartOnFailure, RestartAlways} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid restart policy %q: expected never, on-failure or always", s)
+}
+
+func (p RestartPolicy) String() string {
+	switch p {
+	case RestartNever:
+		return "never"
+	case RestartOnFailure:
+		return "on-failure"
+	case RestartAlways:
+		return "always"
+	default:
+		return fmt.Sprintf("RestartPolicy(%d)", uint8(p))
+	}
+}
+
+func (p RestartPolicy) restart(failed bool) bool {
+	return p == RestartAlways || (p == RestartOnFailure && failed)
+}
+
+const (
+	DefaultBackoffMin = 100 * time.Millisecond
+	DefaultBackoffMax = 30 * time.Second
+)
+
+// DefaultShutdownTimeout bounds the calls to the shutdown export of a
+// service, unless the supervisor says otherwise.
+const DefaultShutdownTimeout = 5 * time.Second
+
+// DefaultMaxFailures is the number of calls in a row that may fail before
+// an async process is deemed to have failed, unless the supervisor says
+// otherwise.
+const DefaultMaxFailures = 3
+
+// Backoff bounds the delay between consecutive restarts, which doubles
+// after each restart and is reset once the process serves a stream
+// successfully.
+type Backoff struct {
+	Min, Max time.Duration // zero means the default
+}
+
+func (b Backoff) min() time.Duration {
+	if b.Min > 0 {
+		return b.Min
+	}
+	return DefaultBackoffMin
+}
+
+func (b Backoff) max() time.Duration {
+	if b.Max > 0 {
+		return b.Max
+	}
+	return DefaultBackoffMax
+}
+
+// next returns the delay that follows d.
+func (b Backoff) next(d time.Duration) time.Duration {
+	if d == 0 {
+		return b.min()
+	}
+	return min(2*d, b.max())
+}
+
+// Supervisor runs processes as services, and restarts them when they exit
+// according to its restart policy.  A restarted process keeps its endpoint
+// name, so that its protocol ID is stable.
+type Supervisor struct {
+	Policy  RestartPolicy
+	Backoff Backoff
+
+	// OnExit is called when a service's process exits, with the error that
+	// caused it, if any, and whether it will be restarted.  Optional.
+	OnExit func(svc *Service, err error, restart bool)
+
+	// ShutdownTimeout bounds the calls to the shutdown export of a service
+	// that is shut down gracefully.  Zero means DefaultShutdownTimeout.
+	ShutdownTimeout time.Duration
+
+	// MaxFailures is the number of calls in a row that may fail before an
+	// async process is deemed to have failed, and exits.  Zero means
+	// DefaultMaxFailures.
+	MaxFailures int
+
+	// Migrate moves the checkpoint of a service to another peer, for
+	// Service.Migrate and MigrateMethod.  Optional.
+	Migrate MigrateFunc
+
+	mu       sync.Mutex
+	services map[string]*Service
+}
+
+// Start a service from config.  Errors that prevent the process from
+// starting the first time, such as invalid bytecode, are returned rather
+// than retried.
+func (s *Supervisor) Start(ctx context.Context, config ProcConfig) (*Service, error) {
+	if config.Src == nil {
+		return nil, errors.New("no source provided")
+	}
+
+	// The source is read once, and replayed on every restart.
+	bytecode, err := io.ReadAll(config.Src)
+	config.Src.Close()
+	if err != nil {
+		return nil, err
+	}
+	config.Src = nil
+
+	if config.Name = config.name(bytecode); !ValidName(config.Name) {
+		return nil, fmt.Errorf("invalid endpoint name %q", config.Name)
+	}
+
+	svc := &Service{
+		sup:      s,
+		ctx:      ctx,
+		config:   config,
+		bytecode: bytecode,
+		done:     make(chan struct{})}
+
+	s.mu.Lock()
+	if _, ok := s.services[config.Name]; ok {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("service %s already exists", config.Name)
+	} else if s.services == nil {
+		s.services = make(map[string]*Service)
+	}
+	s.services[config.Name] = svc
+	s.mu.Unlock()
+
+	p, err := svc.newProc(ctx)
+
+	var exitErr *sys.ExitError
+	switch {
+	case err == nil:
+	case !config.Async && errors.As(err, &exitErr):
+		// A sync process that exits with an error is subject to the
+		// restart policy, like any other exit.
+		svc.goexit(nil, err, true)
+		return svc, nil
+	default:
+		s.mu.Lock()
+		delete(s.services, config.Name)
+		s.mu.Unlock()
+		return nil, err
+	}
+
+	svc.mu.Lock()
+	if svc.stopped {
+		svc.mu.Unlock()
+		p.Close(ctx)
+		return svc, nil
+	}
+	svc.proc = p
+	svc.mu.Unlock()
+
+	// In sync mode, the process ran to completion during instantiation.
+	if !config.Async {
+		svc.goexit(p, nil, false)
+	}
+
+	return svc, nil
+}
+
+// Lookup returns the service with the given endpoint name.
+func (s *Supervisor) Lookup(name string) (*Service, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	svc, ok := s.services[name]
+	return svc, ok
+}
+
+// Close stops every service.
+func (s *Supervisor) Close(ctx context.Context) error {
+	s.mu.Lock()
+	services := s.services
+	s.services = nil
+	s.mu.Unlock()
+
+	var cs CloserSlice
+	for _, svc := range services {
+		cs = append(cs, svc)
+	}
+	return cs.Close(ctx)
+}
+
+// Shutdown stops every service gracefully, concurrently.  See
+// Service.Shutdown.
+func (s *Supervisor) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	services := make([]*Service, 0, len(s.services))
+	for _, svc := range s.services {
+		services = append(services, svc)
+	}
+	s.mu.Unlock()
+
+	errs := make([]error, len(services))
+	var wg sync.WaitGroup
+	for i, svc := range services {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = svc.Shutdown(ctx)
+		}()
+	}
+	wg.Wait()
+
+	return multierr.Combine(errs...)
+}
+
+// Service is a supervised process.
+type Service struct {
+	sup      *Supervisor
+	ctx      context.Context
+	config   ProcConfig
+	bytecode []byte
+
+	mu       sync.Mutex
+	proc     *Proc // nil while restarting or stopped
+	restarts int
+	failures int // calls in a row that failed
+	backoff  time.Duration
+	timer    *time.Timer
+	err      error
+	stopped  bool
+	redirect *Redirect // set once migrated
+	done     chan struct{}
+	wg       sync.WaitGroup // pending restarts
+}
+
+// Name returns the endpoint name, which is preserved across restarts.
+func (svc *Service) Name() string {
+	return svc.config.Name
+}
+
+// Proc returns the running process, or nil if the service is restarting
+// or has stopped.
+func (svc *Service) Proc() *Proc {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	return svc.proc
+}
+
+// Restarts returns the number of times the process has been restarted.
+func (svc *Service) Restarts() int {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	return svc.restarts
+}
+
+// Done is closed when the service stops for good, either because it was
+// closed, or because its process exited and the policy did not restart it.
+func (svc *Service) Done() <-chan struct{} {
+	return svc.done
+}
+
+// Err returns the error that caused the most recent exit, if any.
+func (svc *Service) Err() error {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	return svc.err
+}
+
+// ProcessMessage passes the stream to the running process.  Streams that
+// arrive while the process is restarting are rejected as busy, and those
+// that arrive once the service has migrated are rejected with ErrMoved.
+func (svc *Service) ProcessMessage(ctx context.Context, s network.Stream, method string) error {
+	svc.mu.Lock()
+	p, stopped, redirect := svc.proc, svc.stopped, svc.redirect
+	svc.mu.Unlock()
+
+	if redirect != nil {
+		_ = s.ResetWithError(ErrCodeMoved)
+		return fmt.Errorf("%s: %w to %s", svc.Name(), ErrMoved, redirect.Peer.ID)
+	}
+
+	if p == nil {
+		_ = s.ResetWithError(ErrCodeBusy)
+		if stopped {
+			return fmt.Errorf("%s: %w", svc.Name(), ErrStopped)
+		}
+		return fmt.Errorf("%s: %w", svc.Name(), ErrBusy)
+	}
+
+	// Migration stops the service, so it is handled here, rather than by
+	// the process.
+	if method == MigrateMethod && svc.config.Checkpoints {
+		return svc.serveMigrate(ctx, s)
+	}
+
+	err := p.ProcessMessage(ctx, s, method)
+	if method == MethodsMethod || method == CheckpointMethod {
+		return err // answered by the host, not the guest
+	}
+
+	if err == nil {
+		svc.mu.Lock()
+		svc.backoff = 0
+		svc.failures = 0
+		svc.mu.Unlock()
+	} else if svc.failed(ctx, p, err) {
+		svc.exit(p, err, true)
+	}
+
+	return err
+}
+
+// Close stops the service, and closes its process.  It waits for any
+// restart in progress to finish.
+func (svc *Service) Close(ctx context.Context) (err error) {
+	svc.mu.Lock()
+	p := svc.stop()
+	svc.mu.Unlock()
+
+	if p != nil {
+		err = p.Close(ctx)
+	}
+
+	svc.wg.Wait()
+	return
+}
+
+// Shutdown stops the service gracefully.  New streams are rejected, and
+// the calls in progress are given until ctx expires to return.  Then the
+// guest's shutdown export is called, if any, and the process is closed.
+func (svc *Service) Shutdown(ctx context.Context) error {
+	svc.mu.Lock()
+	p := svc.stop()
+	svc.mu.Unlock()
+
+	if p == nil {
+		svc.wg.Wait()
+		return nil
+	}
+
+	// The shutdown export runs even if the calls in progress overran ctx,
+	// on the instances that are not serving them.
+	err := p.Drain(ctx)
+
+	timeout := svc.sup.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	hookCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+
+	err = multierr.Combine(err,
+		p.Shutdown(hookCtx),
+		p.Close(context.WithoutCancel(ctx)))
+
+	svc.wg.Wait()
+	return err
+}
+
+func (s *Supervisor) maxFailures() int {
+	if s.MaxFailures <= 0 {
+		return DefaultMaxFailures
+	}
+	return s.MaxFailures
+}
+
+// failed records a call to p that returned err, and reports whether the
+// process has failed.  An exhausted run-time budget fails the process at
+// once, since it is permanent.  Other failures of the guest only discard
+// the instance that served the stream, and fail the process once
+// Supervisor.MaxFailures calls in a row have failed.
+func (svc *Service) failed(ctx context.Context, p *Proc, err error) bool {
+	if !callFailed(ctx, err, p.Config.Serve) {
+		return false
+	}
+
+	var limitErr *LimitError
+	if errors.As(err, &limitErr) {
+		return true // run-time budget
+	}
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	if svc.proc != p {
+		return false // already exited
+	}
+	svc.failures++
+	return svc.failures >= svc.sup.maxFailures()
+}
+
+// callFailed reports whether err, returned by a call, is a failure of the
+// guest, rather than of the stream.  Rejected streams, unknown methods and
+// per-call limits concern a single stream.  Traps, non-zero exit codes and
+// an exhausted run-time budget are failures, but in serve mode, the exit
+// code of a command is its answer to the caller.
+func callFailed(ctx context.Context, err error, serve bool) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+
+	var limitErr *LimitError
+	var exitErr *sys.ExitError
+	switch {
+	case errors.Is(err, ErrBusy), errors.Is(err, ErrUnknownMethod):
+		return false
+	case errors.As(err, &limitErr):
+		return limitErr.Limit == LimitRunTime
+	case errors.As(err, &exitErr):
+		return !serve
+	default:
+		return true // trapped
+	}
+}
+
+// goexit handles the exit of p in the background.  If p is nil, the
+// process failed to start.
+func (svc *Service) goexit(p *Proc, err error, failed bool) {
+	svc.wg.Add(1)
+	go func() {
+		defer svc.wg.Done()
+
+		if p == nil {
+			svc.exited(err, failed)
+		} else {
+			svc.exit(p, err, failed)
+		}
+	}()
+}
+
+// exit handles the exit of p.
+func (svc *Service) exit(p *Proc, err error, failed bool) {
+	svc.mu.Lock()
+	if svc.proc != p {
+		svc.mu.Unlock()
+		return // already handled
+	}
+	svc.proc = nil
+	svc.mu.Unlock()
+
+	p.Close(context.Background())
+	svc.exited(err, failed)
+}
+
+// exited restarts the process after a delay, if the policy allows, or
+// stops the service.
+func (svc *Service) exited(err error, failed bool) {
+	svc.mu.Lock()
+	svc.err = err
+	restart := !svc.stopped && svc.sup.Policy.restart(failed)
+	svc.mu.Unlock()
+
+	if svc.sup.OnExit != nil {
+		svc.sup.OnExit(svc, err, restart)
+	}
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	if !restart || svc.stopped {
+		svc.stop()
+		return
+	}
+
+	svc.backoff = svc.sup.Backoff.next(svc.backoff)
+	svc.wg.Add(1)
+	svc.timer = time.AfterFunc(svc.backoff, svc.restart)
+}
+
+func (svc *Service) restart() {
+	defer svc.wg.Done()
+
+	p, err := svc.newProc(svc.ctx)
+
+	svc.mu.Lock()
+	if svc.stopped {
+		svc.mu.Unlock()
+		if p != nil {
+			p.Close(context.Background())
+		}
+		return
+	}
+	svc.restarts++
+	if err == nil {
+		svc.proc = p
+		svc.failures = 0
+	}
+	svc.mu.Unlock()
+
+	switch {
+	case err != nil:
+		svc.exited(err, true)
+	case !svc.config.Async:
+		svc.exit(p, nil, false)
+	}
+}
+
+// stop marks the service as stopped, and returns its process, if any.
+// Callers must hold svc.mu.
+func (svc *Service) stop() *Proc {
+	if svc.stopped {
+		return nil
+	}
+	svc.stopped = true
+	close(svc.done)
+
+	if svc.timer != nil && svc.timer.Stop() {
+		svc.wg.Done() // the restart will never run
+	}
+
+	p := svc.proc
+	svc.proc = nil
+	return p
+}
+
+func (svc *Service) newProc(ctx context.Context) (*Proc, error) {
+	config := svc.config
+	config.Src = io.NopCloser(bytes.NewReader(svc.bytecode))
+	return config.New(ctx)
+}
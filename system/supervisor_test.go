@@ -0,0 +1,274 @@
+package system_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tetratelabs/wazero"
+	"github.com/wetware/go/system"
+	"github.com/wetware/go/system/mocks"
+	"go.uber.org/mock/gomock"
+)
+
+// trapWasm is limitsWasm with a maximum of 2 memory pages, so that "grow"
+// always traps.
+var trapWasm = bytes.Replace(limitsWasm,
+	[]byte{0x05, 0x03, 0x01, 0x00, 0x01},
+	[]byte{0x05, 0x04, 0x01, 0x01, 0x01, 0x02}, 1)
+
+func startService(t *testing.T, sup *system.Supervisor, config system.ProcConfig, bytecode []byte) *system.Service {
+	t.Helper()
+	ctx := context.Background()
+
+	runtime := wazero.NewRuntimeWithConfig(ctx, config.Limits.RuntimeConfig(wazero.NewRuntimeConfig()))
+	t.Cleanup(func() { runtime.Close(ctx) })
+
+	config.Runtime = runtime
+	config.Src = io.NopCloser(bytes.NewReader(bytecode))
+	config.ErrWriter = &bytes.Buffer{}
+
+	svc, err := sup.Start(ctx, config)
+	require.NoError(t, err)
+	t.Cleanup(func() { sup.Close(ctx) })
+
+	return svc
+}
+
+func TestSupervisor_RestartOnFailure(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sup := &system.Supervisor{
+		Policy:  system.RestartOnFailure,
+		Backoff: system.Backoff{Min: time.Millisecond}}
+	svc := startService(t, sup, system.ProcConfig{
+		Name:   "trap",
+		Async:  true,
+		Limits: system.Limits{RunTime: 20 * time.Millisecond},
+	}, trapWasm)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// An unknown method concerns only the stream, and is not an exit.
+	s := mocks.NewMockStreamInterface(ctrl)
+	s.EXPECT().ResetWithError(system.ErrCodeUnknownMethod).Return(nil)
+	require.ErrorIs(t, svc.ProcessMessage(ctx, s, "missing"), system.ErrUnknownMethod)
+	require.NotNil(t, svc.Proc())
+
+	// An exhausted run-time budget is.
+	var limitErr *system.LimitError
+	require.ErrorAs(t, svc.ProcessMessage(ctx, limitedStream(ctrl), "spin"), &limitErr)
+	assert.Error(t, svc.Err())
+
+	require.Eventually(t, func() bool { return svc.Proc() != nil },
+		time.Second, time.Millisecond, "process should be restarted")
+	assert.Equal(t, 1, svc.Restarts())
+	assert.Equal(t, "trap", svc.Proc().Endpoint.Name, "name should be preserved")
+
+	found, ok := sup.Lookup("trap")
+	require.True(t, ok)
+	assert.Same(t, svc, found)
+}
+
+func TestSupervisor_RestartNever(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sup := &system.Supervisor{Policy: system.RestartNever}
+	svc := startService(t, sup, system.ProcConfig{
+		Async:  true,
+		Pool:   system.PoolConfig{Size: 2},
+		Limits: system.Limits{RunTime: 20 * time.Millisecond},
+	}, trapWasm)
+	p := svc.Proc()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// A trap only discards the instance that served the stream, so the
+	// process keeps serving streams on fresh instances.
+	for range 2 {
+		trapped := mocks.NewMockStreamInterface(ctrl)
+		trapped.EXPECT().ResetWithError(system.ErrCodeTrap).Return(nil)
+		require.Error(t, svc.ProcessMessage(ctx, trapped, "grow"))
+		assert.Same(t, p, svc.Proc(), "process should keep running")
+	}
+
+	select {
+	case <-svc.Done():
+		t.Fatal("service should not stop after a trap")
+	default:
+	}
+	assert.Zero(t, svc.Restarts())
+
+	// An exhausted run-time budget stops the process for good.
+	var limitErr *system.LimitError
+	require.ErrorAs(t, svc.ProcessMessage(ctx, limitedStream(ctrl), "spin"), &limitErr)
+
+	select {
+	case <-svc.Done():
+	case <-time.After(time.Second):
+		t.Fatal("service should stop")
+	}
+	assert.Nil(t, svc.Proc())
+
+	// Further streams are rejected.
+	s := mocks.NewMockStreamInterface(ctrl)
+	s.EXPECT().ResetWithError(system.ErrCodeBusy).Return(nil)
+	assert.ErrorIs(t, svc.ProcessMessage(ctx, s, "grow"), system.ErrStopped)
+}
+
+func TestSupervisor_RepeatedFailures(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	for _, tt := range []struct {
+		policy  system.RestartPolicy
+		restart bool
+	}{
+		{system.RestartNever, false},
+		{system.RestartOnFailure, true},
+		{system.RestartAlways, true},
+	} {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			t.Parallel()
+
+			sup := &system.Supervisor{
+				Policy:      tt.policy,
+				Backoff:     system.Backoff{Min: time.Millisecond},
+				MaxFailures: 2}
+			svc := startService(t, sup, system.ProcConfig{Async: true}, counterWasm)
+			p := svc.Proc()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			// "check" traps on a fresh instance, and "incr" succeeds.
+			call := func(method string) error {
+				s := mocks.NewMockStreamInterface(ctrl)
+				if method == "check" {
+					s.EXPECT().ResetWithError(system.ErrCodeTrap).Return(nil)
+				}
+				return svc.ProcessMessage(ctx, s, method)
+			}
+
+			// A success resets the count of failures.
+			require.Error(t, call("check"))
+			require.NoError(t, call("incr"))
+			require.Error(t, call("check"))
+			assert.Same(t, p, svc.Proc(), "process should keep running")
+			assert.NoError(t, svc.Err())
+
+			// Failures in a row fail the process.
+			require.Error(t, call("check"))
+			assert.Error(t, svc.Err())
+
+			if !tt.restart {
+				select {
+				case <-svc.Done():
+				case <-time.After(time.Second):
+					t.Fatal("service should stop")
+				}
+				assert.Zero(t, svc.Restarts())
+				return
+			}
+
+			require.Eventually(t, func() bool { return svc.Proc() != nil },
+				time.Second, time.Millisecond, "process should be restarted")
+			assert.NotSame(t, p, svc.Proc())
+			assert.Equal(t, 1, svc.Restarts())
+
+			// The replacement may fail as many times.
+			require.Error(t, call("check"))
+			assert.NotNil(t, svc.Proc())
+		})
+	}
+}
+
+func TestSupervisor_RunTimeBudgetIsRestored(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sup := &system.Supervisor{
+		Policy:  system.RestartOnFailure,
+		Backoff: system.Backoff{Min: time.Millisecond}}
+	svc := startService(t, sup, system.ProcConfig{
+		Async:  true,
+		Limits: system.Limits{RunTime: 20 * time.Millisecond},
+	}, limitsWasm)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// Spending the budget exits the process...
+	var limitErr *system.LimitError
+	require.ErrorAs(t, svc.ProcessMessage(ctx, limitedStream(ctrl), "spin"), &limitErr)
+	assert.Equal(t, system.LimitRunTime, limitErr.Limit)
+
+	// ... and its replacement starts with a fresh budget.
+	require.Eventually(t, func() bool { return svc.Proc() != nil },
+		time.Second, time.Millisecond, "process should be restarted")
+
+	start := time.Now()
+	require.ErrorAs(t, svc.ProcessMessage(ctx, limitedStream(ctrl), "spin"), &limitErr)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+}
+
+func TestSupervisor_SyncRestartAlways(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	sup := &system.Supervisor{
+		Policy:  system.RestartAlways,
+		Backoff: system.Backoff{Min: time.Millisecond, Max: time.Millisecond}}
+	svc := startService(t, sup, system.ProcConfig{
+		Stdin:  bytes.NewReader(nil),
+		Stdout: &out,
+	}, loadEchoWasm(t))
+
+	// Each run of _start exits cleanly, and is restarted regardless.
+	require.Eventually(t, func() bool { return svc.Restarts() >= 2 },
+		time.Second, time.Millisecond)
+
+	require.NoError(t, svc.Close(context.Background()))
+	<-svc.Done()
+}
+
+func TestSupervisor_SyncRestartOnFailure(t *testing.T) {
+	t.Parallel()
+
+	sup := &system.Supervisor{Policy: system.RestartOnFailure}
+	svc := startService(t, sup, system.ProcConfig{
+		Stdin:  bytes.NewReader(nil),
+		Stdout: io.Discard,
+	}, loadEchoWasm(t))
+
+	// A clean exit is not a failure.
+	select {
+	case <-svc.Done():
+	case <-time.After(time.Second):
+		t.Fatal("service should stop")
+	}
+	assert.NoError(t, svc.Err())
+	assert.Zero(t, svc.Restarts())
+}
+
+func TestParseRestartPolicy(t *testing.T) {
+	t.Parallel()
+
+	for _, p := range []system.RestartPolicy{system.RestartNever, system.RestartOnFailure, system.RestartAlways} {
+		got, err := system.ParseRestartPolicy(p.String())
+		require.NoError(t, err)
+		assert.Equal(t, p, got)
+	}
+
+	_, err := system.ParseRestartPolicy("sometimes")
+	assert.Error(t, err)
+}
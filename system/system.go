@@ -11,7 +11,22 @@ import (
 type Endpoint struct {
 	Name string
 	io.ReadWriteCloser
-	sem *semaphore.Weighted
+	Stderr io.Writer           // receives a copy of the guest's stderr; optional
+	sem    *semaphore.Weighted // bounds in-flight and queued streams
+	meter  *meter              // paused while the guest is blocked on the stream
+}
+
+// admit reserves a slot for an incoming stream, returning false if the
+// endpoint is at capacity.  Endpoints without a semaphore admit everything.
+func (e *Endpoint) admit() bool {
+	return e.sem == nil || e.sem.TryAcquire(1)
+}
+
+// done releases a slot reserved by admit.
+func (e *Endpoint) done() {
+	if e.sem != nil {
+		e.sem.Release(1)
+	}
 }
 
 // Read implements io.Reader for Endpoint
@@ -21,6 +36,9 @@ func (e *Endpoint) Read(p []byte) (n int, err error) {
 		// This allows the WASM module to complete its main() function
 		return 0, io.EOF
 	}
+
+	e.meter.pause()
+	defer e.meter.resume()
 	return e.ReadWriteCloser.Read(p)
 }
 
@@ -30,6 +48,9 @@ func (e *Endpoint) Write(p []byte) (n int, err error) {
 		// If no stream is available, discard output
 		return len(p), nil
 	}
+
+	e.meter.pause()
+	defer e.meter.resume()
 	return e.ReadWriteCloser.Write(p)
 }
 
@@ -40,7 +61,13 @@ func (e Endpoint) String() string {
 
 // Protocol returns the libp2p protocol ID for this endpoint.
 func (e Endpoint) Protocol() protocol.ID {
-	return protocol.ID("/ww/0.1.0/" + e.Name)
+	return ProtocolID(e.Name, "")
+}
+
+// Protocols returns the libp2p protocol IDs for this endpoint, one per
+// supported version, in order of preference.
+func (e Endpoint) Protocols() []protocol.ID {
+	return ProtocolIDs(e.Name, "")
 }
 
 func (e *Endpoint) Close(context.Context) (err error) {
@@ -0,0 +1,93 @@
+package system
+
+import "iter"
+
+// Wazero does not report everything about a module before it is
+// instantiated, e.g. its globals, so the little that is missing is decoded
+// from the bytecode, which the compiler has already validated.
+
+// moduleSections iterates over the sections of a valid module, by id.
+func moduleSections(bytecode []byte) iter.Seq2[byte, []byte] {
+	return func(yield func(byte, []byte) bool) {
+		b := bytecode[min(8, len(bytecode)):] // magic number and version
+		for len(b) > 0 {
+			id := b[0]
+			size, n := uleb128(b[1:])
+			if n == 0 || uint64(len(b)-1-n) < size {
+				return // malformed; already rejected by the compiler
+			}
+			body := b[1+n : 1+n+int(size)]
+			b = b[1+n+int(size):]
+
+			if !yield(id, body) {
+				return
+			}
+		}
+	}
+}
+
+// uleb128 decodes an unsigned LEB128 integer, and returns the number of
+// bytes read, or 0 if b is malformed.
+func uleb128(b []byte) (v uint64, n int) {
+	for shift := 0; n < len(b) && shift < 64; shift += 7 {
+		c := b[n]
+		n++
+		v |= uint64(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return v, n
+		}
+	}
+	return 0, 0
+}
+
+// sectionReader decodes the body of a section.  Once it fails, ok is false
+// and it reads zeros.
+type sectionReader struct {
+	b  []byte
+	ok bool
+}
+
+func (r *sectionReader) byte() byte {
+	if !r.ok || len(r.b) == 0 {
+		r.ok = false
+		return 0
+	}
+	c := r.b[0]
+	r.b = r.b[1:]
+	return c
+}
+
+func (r *sectionReader) bytes(n uint64) []byte {
+	if !r.ok || uint64(len(r.b)) < n {
+		r.ok = false
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *sectionReader) uleb() uint64 {
+	if !r.ok {
+		return 0
+	}
+	v, n := uleb128(r.b)
+	if n == 0 {
+		r.ok = false
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *sectionReader) name() string {
+	return string(r.bytes(r.uleb()))
+}
+
+func (r *sectionReader) limits() {
+	if flags := r.byte(); flags&^0x07 != 0 {
+		r.ok = false
+	} else if r.uleb(); flags&1 != 0 {
+		r.uleb()
+	}
+}
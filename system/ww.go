@@ -0,0 +1,279 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"go.uber.org/multierr"
+)
+
+// HostModuleName is the module name under which guests import wetware host
+// functions, e.g. `//go:wasmimport ww console_write`.  See SPEC-WW.md.
+const HostModuleName = "ww"
+
+// Errno is returned by host functions, negated, to report an error.
+type Errno int32
+
+const (
+	ErrnoFault     Errno = 1 // guest memory access out of bounds
+	ErrnoInval     Errno = 2 // invalid argument
+	ErrnoBadHandle Errno = 3 // no such handle
+	ErrnoIO        Errno = 4 // I/O error
+	ErrnoRange     Errno = 5 // output buffer too small
+)
+
+var i32 = api.ValueTypeI32
+
+// hostFunc is a function exported by the "ww" host module.
+type hostFunc struct {
+	Name    string
+	Cap     Capability // capability required to import the function, if any
+	Params  []api.ValueType
+	Names   []string // parameter names
+	Results []api.ValueType
+	Fn      api.GoModuleFunc
+}
+
+// hostModule implements the "ww" host module for a single process.  Only
+// functions whose capability was granted in ProcConfig.Caps are exported.
+type hostModule struct {
+	Config ProcConfig
+
+	ctx    context.Context // canceled when the process is closed
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	tables map[api.Module]*handleTable // per guest instance
+}
+
+func newHostModule(c ProcConfig) *hostModule {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &hostModule{
+		Config: c,
+		ctx:    ctx,
+		cancel: cancel}
+}
+
+func (h *hostModule) functions() []hostFunc {
+	return []hostFunc{{
+		Name:    "read",
+		Params:  []api.ValueType{i32, i32, i32},
+		Names:   []string{"handle", "buf", "len"},
+		Results: []api.ValueType{i32},
+		Fn:      h.read,
+	}, {
+		Name:    "write",
+		Params:  []api.ValueType{i32, i32, i32},
+		Names:   []string{"handle", "buf", "len"},
+		Results: []api.ValueType{i32},
+		Fn:      h.write,
+	}, {
+		Name:    "close",
+		Params:  []api.ValueType{i32},
+		Names:   []string{"handle"},
+		Results: []api.ValueType{i32},
+		Fn:      h.close,
+	}, {
+		Name:    "close_write",
+		Params:  []api.ValueType{i32},
+		Names:   []string{"handle"},
+		Results: []api.ValueType{i32},
+		Fn:      h.closeWrite,
+	}, {
+		Name:    "call_info",
+		Params:  []api.ValueType{i32, i32},
+		Names:   []string{"out", "out_cap"},
+		Results: []api.ValueType{i32},
+		Fn:      h.callInfo,
+	}, {
+		Name:    "console_write",
+		Cap:     CapConsole,
+		Params:  []api.ValueType{i32, i32},
+		Names:   []string{"buf", "len"},
+		Results: []api.ValueType{i32},
+		Fn:      h.consoleWrite,
+	}, {
+		Name:    "ipfs_open",
+		Cap:     CapIPFS,
+		Params:  []api.ValueType{i32, i32},
+		Names:   []string{"path", "len"},
+		Results: []api.ValueType{i32},
+		Fn:      h.ipfsOpen,
+	}, {
+		Name:    "ipfs_add",
+		Cap:     CapIPFS,
+		Params:  []api.ValueType{i32, i32, i32, i32},
+		Names:   []string{"buf", "len", "out", "out_cap"},
+		Results: []api.ValueType{i32},
+		Fn:      h.ipfsAdd,
+	}, {
+		Name:    "ipfs_add_dir",
+		Cap:     CapIPFS,
+		Params:  []api.ValueType{i32, i32, i32, i32},
+		Names:   []string{"entries", "len", "out", "out_cap"},
+		Results: []api.ValueType{i32},
+		Fn:      h.ipfsAddDir,
+	}, {
+		Name:    "p2p_dial",
+		Cap:     CapP2P,
+		Params:  []api.ValueType{i32, i32, i32, i32, i32, i32},
+		Names:   []string{"peer", "peer_len", "proc", "proc_len", "method", "method_len"},
+		Results: []api.ValueType{i32},
+		Fn:      h.p2pDial,
+	}, {
+		Name:    "spawn",
+		Cap:     CapExec,
+		Params:  []api.ValueType{i32, i32, i32, i32, i32, i32, i32},
+		Names:   []string{"path", "path_len", "argv", "argv_len", "caps", "stdin", "stdout"},
+		Results: []api.ValueType{i32},
+		Fn:      h.spawn,
+	}, {
+		Name:    "wait",
+		Cap:     CapExec,
+		Params:  []api.ValueType{i32},
+		Names:   []string{"pid"},
+		Results: []api.ValueType{i32},
+		Fn:      h.wait,
+	}, {
+		Name:    "kill",
+		Cap:     CapExec,
+		Params:  []api.ValueType{i32},
+		Names:   []string{"pid"},
+		Results: []api.ValueType{i32},
+		Fn:      h.kill,
+	}, {
+		Name:    "pipe",
+		Cap:     CapExec,
+		Params:  []api.ValueType{i32},
+		Names:   []string{"out"},
+		Results: []api.ValueType{i32},
+		Fn:      h.pipe,
+	}}
+}
+
+// CheckImports returns an error if the compiled module imports a "ww"
+// function that does not exist, or that requires a capability that was
+// not granted.  Catching this before instantiation produces a clearer error
+// than the runtime's generic linker failure.
+func (h *hostModule) CheckImports(cm wazero.CompiledModule) error {
+	fns := make(map[string]hostFunc)
+	for _, fn := range h.functions() {
+		fns[fn.Name] = fn
+	}
+
+	for _, def := range cm.ImportedFunctions() {
+		module, name, _ := def.Import()
+		if module != HostModuleName {
+			continue
+		}
+
+		fn, ok := fns[name]
+		if !ok {
+			return fmt.Errorf("%s.%s: no such host function", module, name)
+		}
+
+		if !h.Config.Caps.Has(fn.Cap) {
+			return fmt.Errorf("%s.%s: requires capability %q, which was not granted",
+				module, name, fn.Cap)
+		}
+	}
+
+	return nil
+}
+
+// Instantiate the host module in the runtime, exporting only the functions
+// permitted by the granted capabilities.
+func (h *hostModule) Instantiate(ctx context.Context, r wazero.Runtime) (api.Module, error) {
+	b := r.NewHostModuleBuilder(HostModuleName)
+	for _, fn := range h.functions() {
+		if !h.Config.Caps.Has(fn.Cap) {
+			continue
+		}
+
+		b.NewFunctionBuilder().
+			WithGoModuleFunction(unmetered(fn.Fn), fn.Params, fn.Results).
+			WithParameterNames(fn.Names...).
+			Export(fn.Name)
+	}
+
+	return b.Instantiate(ctx)
+}
+
+// unmetered returns fn, such that the time spent in it is not charged to
+// the run-time budget of the caller.  Host functions may block on I/O.
+func unmetered(fn api.GoModuleFunc) api.GoModuleFunc {
+	return func(ctx context.Context, mod api.Module, stack []uint64) {
+		m := meterFromContext(ctx)
+		m.pause()
+		defer m.resume()
+
+		fn(ctx, mod, stack)
+	}
+}
+
+// Close releases every handle still held by any instance of the guest.
+func (h *hostModule) Close(context.Context) error {
+	h.cancel()
+
+	h.mu.Lock()
+	tables := h.tables
+	h.tables = nil
+	h.mu.Unlock()
+
+	var errs []error
+	for _, t := range tables {
+		errs = append(errs, t.Close())
+	}
+	return multierr.Combine(errs...)
+}
+
+func (h *hostModule) console() io.Writer {
+	if h.Config.Console != nil {
+		return h.Config.Console
+	}
+	return os.Stdout
+}
+
+// consoleWrite(buf, len i32) -> i32
+//
+// Writes len bytes at buf to the host console.  Returns the number of bytes
+// written.
+func (h *hostModule) consoleWrite(ctx context.Context, mod api.Module, stack []uint64) {
+	buf, ok := mod.Memory().Read(api.DecodeU32(stack[0]), api.DecodeU32(stack[1]))
+	if !ok {
+		stack[0] = errno(ErrnoFault)
+		return
+	}
+
+	n, err := h.console().Write(buf)
+	if err != nil {
+		stack[0] = errno(ErrnoIO)
+		return
+	}
+
+	stack[0] = api.EncodeI32(int32(n))
+}
+
+// writeString copies s to the guest buffer at out, whose capacity is size,
+// and returns its length.
+func writeString(mod api.Module, s string, out, size uint64) uint64 {
+	if uint64(len(s)) > uint64(api.DecodeU32(size)) {
+		return errno(ErrnoRange)
+	}
+
+	if !mod.Memory().WriteString(api.DecodeU32(out), s) {
+		return errno(ErrnoFault)
+	}
+
+	return api.EncodeI32(int32(len(s)))
+}
+
+// errno encodes e as a negative i32 result.
+func errno(e Errno) uint64 {
+	return api.EncodeI32(-int32(e))
+}
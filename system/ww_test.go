@@ -0,0 +1,83 @@
+package system_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wetware/go/system"
+	"github.com/wetware/go/system/mocks"
+	"go.uber.org/mock/gomock"
+)
+
+// consoleWasm imports ww.console_write and exports "run", which writes
+// "hi" to the console.
+var consoleWasm = []byte{
+	0x00, 0x61, 0x73, 0x6d, // WASM magic number
+	0x01, 0x00, 0x00, 0x00, // Version 1
+	0x01, 0x0a, 0x02, // Type section: 2 types
+	0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7f, // (i32, i32) -> i32
+	0x60, 0x00, 0x00, // () -> ()
+	0x02, 0x14, 0x01, 0x02, 0x77, 0x77, // Import section: "ww"
+	0x0d, 0x63, 0x6f, 0x6e, 0x73, 0x6f, 0x6c, 0x65, // "console_write"
+	0x5f, 0x77, 0x72, 0x69, 0x74, 0x65, 0x00, 0x00, // function of type 0
+	0x03, 0x02, 0x01, 0x01, // Function section: 1 function of type 1
+	0x05, 0x03, 0x01, 0x00, 0x01, // Memory section: 1 memory, min 1 page
+	0x07, 0x07, 0x01, 0x03, 0x72, 0x75, 0x6e, 0x00, 0x01, // Export section: "run" function 1
+	0x0a, 0x0b, 0x01, 0x09, 0x00, // Code section: 1 body
+	0x41, 0x00, 0x41, 0x02, 0x10, 0x00, 0x1a, 0x0b, // drop(console_write(0, 2))
+	0x0b, 0x08, 0x01, 0x00, 0x41, 0x00, 0x0b, 0x02, 0x68, 0x69, // Data section: "hi" at 0
+}
+
+func TestHostModule_Console(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("granted", func(t *testing.T) {
+		var console bytes.Buffer
+		proc, err := newTestProc(t, system.ProcConfig{
+			Async:   true,
+			Caps:    system.CapConsole,
+			Console: &console,
+		}, consoleWasm)
+		require.NoError(t, err)
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		err = proc.ProcessMessage(ctx, mocks.NewMockStreamInterface(ctrl), "run")
+		require.NoError(t, err)
+		assert.Equal(t, "hi", console.String())
+	})
+
+	t.Run("not granted", func(t *testing.T) {
+		proc, err := newTestProc(t, system.ProcConfig{
+			Async: true,
+			Caps:  system.CapAll &^ system.CapConsole,
+		}, consoleWasm)
+		require.Error(t, err)
+		assert.Nil(t, proc)
+		assert.Contains(t, err.Error(), "ww.console_write")
+		assert.Contains(t, err.Error(), `"console"`)
+	})
+
+	t.Run("no such function", func(t *testing.T) {
+		bytecode := bytes.Replace(consoleWasm, []byte("console_write"), []byte("console_xxxxx"), 1)
+
+		proc, err := newTestProc(t, system.ProcConfig{Async: true, Caps: system.CapAll}, bytecode)
+		require.Error(t, err)
+		assert.Nil(t, proc)
+		assert.Contains(t, err.Error(), "no such host function")
+	})
+}
+
+func TestCapability_String(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "none", system.Capability(0).String())
+	assert.Equal(t, "console|p2p", (system.CapConsole | system.CapP2P).String())
+	assert.Equal(t, "console|ipfs|exec|p2p", system.CapAll.String())
+}
@@ -0,0 +1,156 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/tetratelabs/wazero"
+)
+
+// ModuleCache is a content-addressed, on-disk cache of compiled WASM modules.
+// Each module is compiled into its own subdirectory of Dir, named after the
+// CID of its bytecode, so that entries can be listed and pruned individually.
+type ModuleCache struct {
+	Dir string
+}
+
+// CacheEntry describes the compiled artifacts for a single module.
+type CacheEntry struct {
+	Key      string    // CIDv1 (raw codec) of the module bytecode
+	Size     int64     // total size of compiled artifacts, in bytes
+	LastUsed time.Time // last time the entry was opened
+}
+
+// Key returns the cache key for the given bytecode.  This is the CIDv1 of the
+// raw bytes, which is not necessarily the CID of the UnixFS file from which
+// the bytecode was loaded.
+func (c ModuleCache) Key(bytecode []byte) (cid.Cid, error) {
+	hash, err := multihash.Sum(bytecode, multihash.SHA2_256, -1)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return cid.NewCidV1(cid.Raw, hash), nil
+}
+
+// Open the compilation cache for the given bytecode.  The returned cache
+// should be passed to wazero.RuntimeConfig.WithCompilationCache, and closed
+// when the runtime is closed.
+func (c ModuleCache) Open(bytecode []byte) (wazero.CompilationCache, error) {
+	key, err := c.Key(bytecode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute cache key: %w", err)
+	}
+
+	dir := filepath.Join(c.Dir, key.String())
+	cache, err := wazero.NewCompilationCacheWithDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open compilation cache: %w", err)
+	}
+
+	// Record the access time, which is used to evict stale entries.
+	now := time.Now()
+	if err := os.Chtimes(dir, now, now); err != nil {
+		cache.Close(context.Background())
+		return nil, err
+	}
+
+	return cache, nil
+}
+
+// Entries returns the entries in the cache, most recently used first.
+func (c ModuleCache) Entries() ([]CacheEntry, error) {
+	dirents, err := os.ReadDir(c.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var entries []CacheEntry
+	for _, dirent := range dirents {
+		if !dirent.IsDir() {
+			continue
+		}
+
+		if _, err := cid.Decode(dirent.Name()); err != nil {
+			continue // not ours
+		}
+
+		info, err := dirent.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := dirSize(filepath.Join(c.Dir, dirent.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, CacheEntry{
+			Key:      dirent.Name(),
+			Size:     size,
+			LastUsed: info.ModTime(),
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastUsed.After(entries[j].LastUsed)
+	})
+
+	return entries, nil
+}
+
+// Remove the entry with the given key.  It is not an error to remove an
+// entry that does not exist.
+func (c ModuleCache) Remove(key string) error {
+	if _, err := cid.Decode(key); err != nil {
+		return fmt.Errorf("invalid cache key %s: %w", key, err)
+	}
+	return os.RemoveAll(filepath.Join(c.Dir, key))
+}
+
+// Prune removes entries that were last used before the cutoff, and returns
+// the removed entries.
+func (c ModuleCache) Prune(cutoff time.Time) ([]CacheEntry, error) {
+	entries, err := c.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []CacheEntry
+	for _, entry := range entries {
+		if entry.LastUsed.After(cutoff) {
+			continue
+		}
+
+		if err := c.Remove(entry.Key); err != nil {
+			return removed, err
+		}
+		removed = append(removed, entry)
+	}
+
+	return removed, nil
+}
+
+func dirSize(dir string) (size int64, err error) {
+	err = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		info, err := d.Info()
+		if err == nil {
+			size += info.Size()
+		}
+		return err
+	})
+	return
+}
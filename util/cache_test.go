@@ -0,0 +1,56 @@
+package util
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestModuleCache tests creating, listing and pruning cache entries
+func TestModuleCache(t *testing.T) {
+	cache := ModuleCache{Dir: t.TempDir()}
+
+	// An empty cache has no entries
+	entries, err := cache.Entries()
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// Opening the cache for a module creates an entry keyed by its CID
+	bytecode := []byte("\x00asm\x01\x00\x00\x00")
+	key, err := cache.Key(bytecode)
+	require.NoError(t, err)
+
+	cc, err := cache.Open(bytecode)
+	require.NoError(t, err)
+	require.NoError(t, cc.Close(context.Background()))
+
+	entries, err = cache.Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, key.String(), entries[0].Key)
+
+	// Recently used entries survive pruning
+	removed, err := cache.Prune(time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, removed)
+
+	// Stale entries are removed
+	removed, err = cache.Prune(time.Now())
+	require.NoError(t, err)
+	assert.Len(t, removed, 1)
+
+	entries, err = cache.Entries()
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+// TestModuleCache_Remove_InvalidKey tests that only CID-named entries can be removed
+func TestModuleCache_Remove_InvalidKey(t *testing.T) {
+	cache := ModuleCache{Dir: t.TempDir()}
+
+	err := cache.Remove("../etc")
+	assert.Error(t, err, "Remove should reject keys that are not CIDs")
+}
@@ -2,6 +2,7 @@ package util
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"math/rand"
 	"time"
@@ -76,3 +77,47 @@ func WaitForDHTReady(ctx context.Context, dht *dual.DHT) error {
 		}
 	}
 }
+
+// Connect finds the peer through a client-mode DHT seeded with IPFS peers,
+// and connects h to it.  The DHT is closed once the connection is made.
+func (env *IPFSEnv) Connect(ctx context.Context, h host.Host, id peer.ID) error {
+	dht, err := env.NewDHT(ctx, h)
+	if err != nil {
+		return fmt.Errorf("failed to create DHT client: %w", err)
+	}
+	defer dht.Close()
+
+	// Set up DHT readiness monitoring BEFORE bootstrapping
+	slog.DebugContext(ctx, "setting up DHT readiness monitoring")
+	readyChan := make(chan error, 1)
+	go func() {
+		readyChan <- WaitForDHTReady(ctx, dht)
+	}()
+
+	// Bootstrap the DHT to populate routing table with IPFS peers
+	slog.DebugContext(ctx, "bootstrapping DHT")
+	if err := dht.Bootstrap(ctx); err != nil {
+		return fmt.Errorf("failed to bootstrap DHT: %w", err)
+	}
+
+	// Wait for DHT to be ready
+	slog.DebugContext(ctx, "waiting for DHT routing table to populate")
+	if err := <-readyChan; err != nil {
+		slog.WarnContext(ctx, "DHT may not be fully ready", "error", err)
+	}
+
+	// Use DHT for peer discovery
+	slog.DebugContext(ctx, "searching for peer via DHT", "peer", id.String()[:12])
+
+	info, err := dht.FindPeer(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to find peer %s via DHT: %w", id, err)
+	}
+
+	slog.DebugContext(ctx, "target peer found via DHT", "peer", info.ID.String()[:12])
+	if err := h.Connect(ctx, info); err != nil {
+		return fmt.Errorf("failed to connect to peer %s: %w", info.ID, err)
+	}
+
+	return nil
+}
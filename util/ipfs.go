@@ -3,9 +3,11 @@ package util
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
 
 	"github.com/ipfs/boxo/files"
@@ -57,6 +59,59 @@ func (env *IPFSEnv) Close() error {
 	return nil
 }
 
+// LoadIPFSFile resolves an IPFS path to WASM bytecode
+func (env *IPFSEnv) LoadIPFSFile(ctx context.Context, p path.Path) (files.File, error) {
+	if env.IPFS == nil {
+		return nil, fmt.Errorf("IPFS environment not initialized")
+	}
+
+	// Get the file from IPFS
+	node, err := env.IPFS.Unixfs().Get(ctx, p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get from IPFS: %w", err)
+	}
+
+	// Read the file content
+	file, ok := node.(files.File)
+	if !ok {
+		return nil, fmt.Errorf("IPFS path does not point to a file")
+	}
+
+	return file, nil
+}
+
+// ResolveBinary resolves a binary path to WASM bytecode.  The name is an
+// IPFS path, a local file path, or the name of an executable in $PATH.
+func (env *IPFSEnv) ResolveBinary(ctx context.Context, name string) (io.ReadCloser, error) {
+	// Parse the IPFS path
+	ipfsPath, err := path.NewPath(name)
+	if err == nil {
+		return env.LoadIPFSFile(ctx, ipfsPath)
+	}
+
+	// Check if it's an absolute path
+	if filepath.IsAbs(name) {
+		return os.Open(name)
+	}
+
+	// Check if it's a relative path (starts with . or /)
+	if len(name) > 0 && (name[0] == '.' || name[0] == '/') {
+		return os.Open(name)
+	}
+
+	// Check if it's in $PATH
+	if resolvedPath, err := exec.LookPath(name); err == nil {
+		return os.Open(resolvedPath)
+	}
+
+	// Try as a relative path in current directory
+	if _, err := os.Stat(name); err == nil {
+		return os.Open(name)
+	}
+
+	return nil, fmt.Errorf("binary not found: %s", name)
+}
+
 // AddToIPFS adds a file or directory to IPFS recursively
 func (env IPFSEnv) AddToIPFS(ctx context.Context, localPath string) (string, error) {
 	// Get file info to determine if it's a directory
@@ -0,0 +1,106 @@
+package util
+
+import (
+	"sync"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+)
+
+// ResourceLimits bounds the libp2p resources available to each wetware
+// process, and to each peer.  Streams are attached to the resource manager
+// service named after the process they call, so that every process has a
+// scope of its own, and one busy process cannot starve the others.  Zero
+// values keep the libp2p defaults.
+type ResourceLimits struct {
+	Streams        int   // inbound streams per process
+	StreamsPerPeer int   // inbound streams per process, from a single peer
+	Memory         int64 // bytes reserved by the streams of a process
+	MemoryPerPeer  int64 // bytes reserved by the streams of a process, for a single peer
+	ConnsPerPeer   int   // connections per peer, across processes
+}
+
+// LimitConfig returns the resource manager limits, with the limits of a
+// process applied to each of the named services.  Other services, including
+// those of libp2p itself, keep their default limits.  Anything left unset is
+// scaled to the system.
+func (l ResourceLimits) LimitConfig(services ...string) rcmgr.ConcreteLimitConfig {
+	defaults := rcmgr.DefaultLimits
+	libp2p.SetDefaultServiceLimits(&defaults)
+
+	partial := rcmgr.PartialLimitConfig{
+		Service:     map[string]rcmgr.ResourceLimits{},
+		ServicePeer: map[string]rcmgr.ResourceLimits{},
+		PeerDefault: rcmgr.ResourceLimits{
+			Conns: rcmgr.LimitVal(l.ConnsPerPeer),
+		},
+	}
+	for _, name := range services {
+		partial.Service[name] = rcmgr.ResourceLimits{
+			StreamsInbound: rcmgr.LimitVal(l.Streams),
+			Memory:         rcmgr.LimitVal64(l.Memory),
+		}
+		partial.ServicePeer[name] = rcmgr.ResourceLimits{
+			StreamsInbound: rcmgr.LimitVal(l.StreamsPerPeer),
+			Memory:         rcmgr.LimitVal64(l.MemoryPerPeer),
+		}
+	}
+
+	return partial.Build(defaults.AutoScale())
+}
+
+// NewLimiter returns a limiter that enforces the limits on the services of
+// the processes added to it.
+func (l ResourceLimits) NewLimiter() *Limiter {
+	return &Limiter{
+		Limiter:  rcmgr.NewFixedLimiter(l.LimitConfig()),
+		limits:   l,
+		services: map[string]rcmgr.Limiter{},
+	}
+}
+
+// Limiter is a resource limiter whose process services can be added while
+// it is in use, as processes are started, restarted or migrated in.
+type Limiter struct {
+	rcmgr.Limiter // limits of everything but processes
+
+	limits   ResourceLimits
+	mu       sync.RWMutex
+	services map[string]rcmgr.Limiter
+}
+
+// AddService applies the limits of a process to the named service.  The
+// resource manager reads them when the service first has a stream, so call
+// it before attaching any stream to the service.
+func (l *Limiter) AddService(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.services[name]; !ok {
+		l.services[name] = rcmgr.NewFixedLimiter(l.limits.LimitConfig(name))
+	}
+}
+
+func (l *Limiter) GetServiceLimits(svc string) rcmgr.Limit {
+	return l.limiter(svc).GetServiceLimits(svc)
+}
+
+func (l *Limiter) GetServicePeerLimits(svc string) rcmgr.Limit {
+	return l.limiter(svc).GetServicePeerLimits(svc)
+}
+
+func (l *Limiter) limiter(svc string) rcmgr.Limiter {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if limiter, ok := l.services[svc]; ok {
+		return limiter
+	}
+	return l.Limiter
+}
+
+// NewResourceManager returns a resource manager that enforces the limits.
+func (l *Limiter) NewResourceManager() (network.ResourceManager, error) {
+	return rcmgr.NewResourceManager(l)
+}
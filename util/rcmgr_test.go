@@ -0,0 +1,82 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResourceLimits_LimitConfig(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Set", func(t *testing.T) {
+		t.Parallel()
+
+		limits := ResourceLimits{
+			Streams:        10,
+			StreamsPerPeer: 2,
+			Memory:         1 << 20,
+			MemoryPerPeer:  1 << 16,
+			ConnsPerPeer:   3,
+		}
+		cfg := limits.LimitConfig("proc").ToPartialLimitConfig()
+
+		require.Equal(t, rcmgr.LimitVal(10), cfg.Service["proc"].StreamsInbound)
+		require.Equal(t, rcmgr.LimitVal64(1<<20), cfg.Service["proc"].Memory)
+		require.Equal(t, rcmgr.LimitVal(2), cfg.ServicePeer["proc"].StreamsInbound)
+		require.Equal(t, rcmgr.LimitVal64(1<<16), cfg.ServicePeer["proc"].Memory)
+		require.Equal(t, rcmgr.LimitVal(3), cfg.PeerDefault.Conns)
+
+		// Other services keep their defaults.
+		defaults := ResourceLimits{}.LimitConfig().ToPartialLimitConfig()
+		require.Equal(t, defaults.ServiceDefault, cfg.ServiceDefault)
+		require.Equal(t, defaults.Service[identify.ServiceName], cfg.Service[identify.ServiceName])
+	})
+
+	t.Run("Default", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := ResourceLimits{}.LimitConfig().ToPartialLimitConfig()
+		require.Equal(t, rcmgr.DefaultLimits.AutoScale().ToPartialLimitConfig().ServiceDefault,
+			cfg.ServiceDefault)
+		require.Contains(t, cfg.Service, identify.ServiceName,
+			"libp2p services should keep limits of their own")
+	})
+}
+
+func TestLimiter_MaxStreams(t *testing.T) {
+	t.Parallel()
+
+	limiter := ResourceLimits{Streams: 2}.NewLimiter()
+	limiter.AddService("proc")
+
+	rm, err := limiter.NewResourceManager()
+	require.NoError(t, err)
+	defer rm.Close()
+
+	attach := func(service string) error {
+		s, err := rm.OpenStream(peer.ID("peer"), network.DirInbound)
+		require.NoError(t, err)
+		require.NoError(t, s.SetProtocol("/test"))
+		if err = s.SetService(service); err != nil {
+			s.Done()
+		}
+		return err
+	}
+
+	// The process accepts --max-streams streams, and rejects the next one.
+	for range 2 {
+		require.NoError(t, attach("proc"))
+	}
+	require.Error(t, attach("proc"), "stream beyond the limit should be rejected")
+
+	// Other services are not limited by the process's limits.
+	for range 3 {
+		require.NoError(t, attach(identify.ServiceName))
+		require.NoError(t, attach("other"))
+	}
+}
@@ -0,0 +1,164 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	pathpkg "path"
+	"strings"
+	"time"
+
+	"github.com/ipfs/boxo/files"
+	"github.com/ipfs/boxo/path"
+	iface "github.com/ipfs/kubo/core/coreiface"
+	"github.com/ipfs/kubo/core/coreiface/options"
+)
+
+// UnixFS is a read-only fs.FS over a UnixFS tree in IPFS.  Nodes are
+// fetched lazily, as they are opened.
+type UnixFS struct {
+	Ctx  context.Context
+	API  iface.CoreAPI
+	Root path.Path
+}
+
+// FS returns a read-only filesystem rooted at the IPFS path root.
+func (env IPFSEnv) FS(ctx context.Context, root path.Path) UnixFS {
+	return UnixFS{Ctx: ctx, API: env.IPFS, Root: root}
+}
+
+func (u UnixFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	p := u.Root
+	if name != "." {
+		var err error
+		if p, err = path.Join(u.Root, strings.Split(name, "/")...); err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+	}
+
+	node, err := u.API.Unixfs().Get(u.Ctx, p)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	switch n := node.(type) {
+	case files.File:
+		size, err := n.Size()
+		if err != nil {
+			n.Close()
+			return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+		}
+		return &unixfsFile{File: n, info: fileInfo{name: pathpkg.Base(name), size: size}}, nil
+
+	case files.Directory:
+		n.Close()
+		return &unixfsDir{
+			fs:   u,
+			path: p,
+			info: fileInfo{name: pathpkg.Base(name), dir: true},
+		}, nil
+
+	default:
+		node.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.ErrUnsupported}
+	}
+}
+
+type unixfsFile struct {
+	files.File
+	info fileInfo
+}
+
+func (f *unixfsFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+type unixfsDir struct {
+	fs      UnixFS
+	path    path.Path
+	info    fileInfo
+	entries []fs.DirEntry // nil until listed
+}
+
+func (d *unixfsDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *unixfsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+func (d *unixfsDir) Close() error {
+	return nil
+}
+
+func (d *unixfsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.entries == nil {
+		if err := d.list(); err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: d.info.name, Err: err}
+		}
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = d.entries[len(entries):]
+		return entries, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+func (d *unixfsDir) list() error {
+	ch, err := d.fs.API.Unixfs().Ls(d.fs.Ctx, d.path, options.Unixfs.ResolveChildren(true))
+	if err != nil {
+		return err
+	}
+
+	d.entries = []fs.DirEntry{}
+	for entry := range ch {
+		if entry.Err != nil {
+			return entry.Err
+		}
+
+		d.entries = append(d.entries, fs.FileInfoToDirEntry(fileInfo{
+			name: entry.Name,
+			size: int64(entry.Size),
+			dir:  entry.Type == iface.TDirectory,
+		}))
+	}
+
+	return nil
+}
+
+// fileInfo describes UnixFS nodes.  Files are read-only, and carry no
+// modification time.
+type fileInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return fi.size }
+func (fi fileInfo) ModTime() time.Time { return time.Time{} }
+func (fi fileInfo) IsDir() bool        { return fi.dir }
+func (fi fileInfo) Sys() any           { return nil }
+
+func (fi fileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
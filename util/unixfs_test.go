@@ -0,0 +1,107 @@
+package util
+
+import (
+	"context"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/ipfs/boxo/files"
+	"github.com/ipfs/boxo/path"
+	iface "github.com/ipfs/kubo/core/coreiface"
+	"github.com/ipfs/kubo/core/coreiface/options"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memIPFS serves a UnixFS tree from an in-memory map of path to content.
+// Unimplemented methods panic.
+type memIPFS struct {
+	iface.CoreAPI
+	iface.UnixfsAPI
+	tree fstest.MapFS
+	root path.Path
+}
+
+func (m *memIPFS) Unixfs() iface.UnixfsAPI { return m }
+
+// rel returns the name of p relative to the root.
+func (m *memIPFS) rel(p path.Path) string {
+	segs := p.Segments()[len(m.root.Segments()):]
+	if len(segs) == 0 {
+		return "."
+	}
+	return path.SegmentsToString(segs...)[1:]
+}
+
+func (m *memIPFS) Get(ctx context.Context, p path.Path) (files.Node, error) {
+	name := m.rel(p)
+	info, err := fs.Stat(m.tree, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return files.NewMapDirectory(nil), nil
+	}
+
+	b, err := fs.ReadFile(m.tree, name)
+	if err != nil {
+		return nil, err
+	}
+	return files.NewBytesFile(b), nil
+}
+
+func (m *memIPFS) Ls(ctx context.Context, p path.Path, opts ...options.UnixfsLsOption) (<-chan iface.DirEntry, error) {
+	entries, err := fs.ReadDir(m.tree, m.rel(p))
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan iface.DirEntry, len(entries))
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		entry := iface.DirEntry{Name: e.Name(), Type: iface.TFile, Size: uint64(info.Size())}
+		if e.IsDir() {
+			entry.Type, entry.Size = iface.TDirectory, 0
+		}
+		ch <- entry
+	}
+	close(ch)
+	return ch, nil
+}
+
+func TestUnixFS(t *testing.T) {
+	t.Parallel()
+
+	root, err := path.NewPath("/ipfs/bafkqaaa")
+	require.NoError(t, err)
+
+	api := &memIPFS{
+		root: root,
+		tree: fstest.MapFS{
+			"hello.txt":        {Data: []byte("hello, world")},
+			"data/a.csv":       {Data: []byte("a,b,c\n1,2,3\n")},
+			"data/nested/b.md": {Data: []byte("# b\n")},
+		},
+	}
+
+	fsys := IPFSEnv{IPFS: api}.FS(context.Background(), root)
+	require.NoError(t, fstest.TestFS(fsys, "hello.txt", "data/a.csv", "data/nested/b.md"))
+
+	b, err := fs.ReadFile(fsys, "data/a.csv")
+	require.NoError(t, err)
+	assert.Equal(t, "a,b,c\n1,2,3\n", string(b))
+
+	info, err := fs.Stat(fsys, "data")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+	assert.Equal(t, fs.ModeDir|0o555, info.Mode())
+
+	_, err = fsys.Open("missing.txt")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}